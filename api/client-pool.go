@@ -2,11 +2,24 @@ package api
 
 import (
 	"context"
+	"fmt"
 	"sync"
+	"time"
 
 	"golang.org/x/time/rate"
 )
 
+const (
+	reloginBackoffMin = 2 * time.Second
+	reloginBackoffMax = 2 * time.Minute
+)
+
+// reloginCall tracks a re-login in progress so concurrent callers can wait for it
+type reloginCall struct {
+	done chan struct{}
+	err  error
+}
+
 // HTTPClientPool manages a pool of HTTPClient instances for concurrent requests
 type HTTPClientPool struct {
 	clients     []*HTTPClient
@@ -22,6 +35,19 @@ type HTTPClientPool struct {
 	bearer       string
 	cryptoKeyHex string
 	limiter      *rate.Limiter
+
+	// Single-flight re-login state
+	reloginMu       sync.Mutex
+	reloginCall     *reloginCall
+	reloginFailures int
+	reloginRetryAt  time.Time
+	reloginLastErr  error
+
+	// Token lifetime tracking
+	tokenIssuedAt  time.Time
+	tokenExpiresAt time.Time
+	tokenLifetime  time.Duration
+	refreshStop    chan struct{}
 }
 
 // NewHTTPClientPool creates a new pool with the specified number of clients
@@ -40,12 +66,18 @@ func NewHTTPClientPool(size int, requestsPerMinute float64) *HTTPClientPool {
 	// Initialize all clients
 	for i := 0; i < size; i++ {
 		p.clients[i] = NewHTTPClientWithEnv()
+		p.clients[i].pool = p
 		p.pool <- p.clients[i]
 	}
 
 	return p
 }
 
+// Size returns the number of clients in the pool, i.e. the maximum number of concurrent requests
+func (p *HTTPClientPool) Size() int {
+	return p.size
+}
+
 // Acquire gets a client from the pool (blocks if none available)
 func (p *HTTPClientPool) Acquire() *HTTPClient {
 	client := <-p.pool
@@ -67,9 +99,6 @@ func (p *HTTPClientPool) Acquire() *HTTPClient {
 		client.SetHeaders(p.headers)
 	}
 	client.SetDebug(p.debug)
-	if p.reloginFunc != nil {
-		client.SetReloginFunc(p.reloginFunc)
-	}
 
 	return client
 }
@@ -80,7 +109,7 @@ func (p *HTTPClientPool) Release(client *HTTPClient) {
 	p.mu.Lock()
 	newToken := client.GetBearerToken()
 	if newToken != "" && newToken != p.bearer {
-		p.bearer = newToken
+		p.setTokenLocked(newToken)
 	}
 	p.mu.Unlock()
 
@@ -92,7 +121,7 @@ func (p *HTTPClientPool) SetBearerToken(token string) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	p.bearer = token
+	p.setTokenLocked(token)
 	// Update all clients in the pool
 	for _, client := range p.clients {
 		client.SetBearerToken(token)
@@ -161,16 +190,94 @@ func (p *HTTPClientPool) SetDebug(debug bool) {
 	}
 }
 
-// SetReloginFunc updates the re-login function for all clients
+// SetReloginFunc sets the re-login function used by all clients in the pool.
+// The function is expected to update the pool's bearer token on success.
 func (p *HTTPClientPool) SetReloginFunc(fn ReloginFunc) {
 	p.mu.Lock()
-	defer p.mu.Unlock()
-
 	p.reloginFunc = fn
-	// Update all clients in the pool
-	for _, client := range p.clients {
-		client.SetReloginFunc(fn)
+	p.mu.Unlock()
+
+	// A new login function gets a fresh start
+	p.reloginMu.Lock()
+	p.reloginFailures = 0
+	p.reloginRetryAt = time.Time{}
+	p.reloginLastErr = nil
+	p.reloginMu.Unlock()
+}
+
+// Relogin re-authenticates after staleToken was rejected and returns the token to use.
+// Only one login runs at a time: concurrent callers wait for it and share its result,
+// and callers whose token was already replaced get the new token without logging in.
+// After a failed login further attempts are refused with exponential backoff.
+func (p *HTTPClientPool) Relogin(staleToken string) (string, error) {
+	p.reloginMu.Lock()
+
+	// Someone else already refreshed the token since our request was sent
+	if current := p.GetBearerToken(); current != "" && current != staleToken {
+		p.reloginMu.Unlock()
+		return current, nil
+	}
+
+	// A re-login is in progress, wait for it and reuse its outcome
+	if call := p.reloginCall; call != nil {
+		p.reloginMu.Unlock()
+		<-call.done
+		if call.err != nil {
+			return "", call.err
+		}
+		return p.GetBearerToken(), nil
+	}
+
+	if wait := time.Until(p.reloginRetryAt); wait > 0 {
+		lastErr := p.reloginLastErr
+		p.reloginMu.Unlock()
+		return "", fmt.Errorf("re-login backing off for %v after failure: %w", wait.Round(time.Second), lastErr)
+	}
+
+	p.mu.RLock()
+	fn := p.reloginFunc
+	p.mu.RUnlock()
+	if fn == nil {
+		p.reloginMu.Unlock()
+		return "", ErrNoReloginFunc
+	}
+
+	call := &reloginCall{done: make(chan struct{})}
+	p.reloginCall = call
+	p.reloginMu.Unlock()
+
+	call.err = fn()
+
+	p.reloginMu.Lock()
+	p.reloginCall = nil
+	if call.err != nil {
+		p.reloginFailures++
+		p.reloginRetryAt = time.Now().Add(reloginBackoff(p.reloginFailures))
+		p.reloginLastErr = call.err
+	} else {
+		p.reloginFailures = 0
+		p.reloginRetryAt = time.Time{}
+		p.reloginLastErr = nil
+	}
+	p.reloginMu.Unlock()
+	close(call.done)
+
+	if call.err != nil {
+		return "", call.err
+	}
+	return p.GetBearerToken(), nil
+}
+
+// reloginBackoff returns the wait time after the given number of consecutive failures
+func reloginBackoff(failures int) time.Duration {
+	d := reloginBackoffMin
+	for i := 1; i < failures && d < reloginBackoffMax; i++ {
+		d *= 2
+	}
+	if d > reloginBackoffMax {
+		d = reloginBackoffMax
 	}
+	return d
 }
 
 // GetApiBase returns the current API base URL
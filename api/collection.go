@@ -68,11 +68,11 @@ func GetCollection(h *HTTPClient, folderID uint64, cType CollectionType) (*Colle
 	if h == nil {
 		h = NewHTTPClientWithEnv()
 	}
-	if strings.TrimSpace(h.bearer) == "" {
+	if strings.TrimSpace(h.GetBearerToken()) == "" {
 		return nil, fmt.Errorf("missing bearer token; call Login first")
 	}
 
-	if cType != CollectionCloud && cType != CollectionCrypto {
+	if cType != CollectionCloud && cType != CollectionCrypto && cType != CollectionTrash {
 		return nil, fmt.Errorf("invalid collection type: %s", cType)
 	}
 
@@ -118,7 +118,7 @@ func GetFolderProperties(h *HTTPClient, folderUID string, crypto bool) (*FolderP
 	if h == nil {
 		h = NewHTTPClientWithEnv()
 	}
-	if strings.TrimSpace(h.bearer) == "" {
+	if strings.TrimSpace(h.GetBearerToken()) == "" {
 		return nil, fmt.Errorf("missing bearer token; call Login first")
 	}
 
@@ -0,0 +1,301 @@
+package api
+
+import (
+	"crypto/cipher"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/twofish"
+)
+
+const (
+	cryptoHeaderSize = 32
+	cryptoChunkSize  = 4 * 1024 * 1024
+)
+
+var (
+	// ErrWrongCryptoKey is returned by strict decryption when the header does not
+	// decrypt to a valid Icedrive header, i.e. the key is wrong or the data is not encrypted
+	ErrWrongCryptoKey = errors.New("invalid crypto header: wrong key or not an Icedrive encrypted file")
+	// ErrTruncatedCryptoFile is returned when encrypted data ends early
+	ErrTruncatedCryptoFile = errors.New("encrypted data is truncated")
+)
+
+// CryptoHeader is the decrypted 32 byte header in front of every encrypted file body
+type CryptoHeader struct {
+	IV       []byte // CBC IV of the file body
+	Padding  int    // number of zero bytes appended to the plaintext
+	Version  int    // format version, only 0 is known
+	Reserved []byte // remaining header bytes, zero in files written by Icedrive
+}
+
+// Valid reports whether the header looks like one written with the same key
+func (hdr *CryptoHeader) Valid() bool {
+	if hdr.Version != 0 || hdr.Padding >= blockSize {
+		return false
+	}
+	for _, b := range hdr.Reserved {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// CryptoFileInfo describes an encrypted blob checked by VerifyCryptoStream
+type CryptoFileInfo struct {
+	Header     CryptoHeader
+	CipherSize int64 // total encrypted size including the header
+	PlainSize  int64 // size after decryption
+}
+
+func newTwofishBlock(hexkey string) (cipher.Block, error) {
+	key, err := hex.DecodeString(hexkey)
+	if err != nil {
+		return nil, err
+	}
+	if l := len(key); l != 16 && l != 24 && l != 32 {
+		return nil, fmt.Errorf("invalid key length: %d", l)
+	}
+	return twofish.NewCipher(key)
+}
+
+// DecryptCryptoHeader decrypts the first 32 bytes of an encrypted file
+func DecryptCryptoHeader(headerCipher []byte, hexkey string) (*CryptoHeader, error) {
+	block, err := newTwofishBlock(hexkey)
+	if err != nil {
+		return nil, err
+	}
+	return decryptCryptoHeader(headerCipher, block)
+}
+
+func decryptCryptoHeader(headerCipher []byte, block cipher.Block) (*CryptoHeader, error) {
+	if len(headerCipher) < cryptoHeaderSize {
+		return nil, ErrTruncatedCryptoFile
+	}
+	headerPlain := make([]byte, cryptoHeaderSize)
+	cipher.NewCBCDecrypter(block, []byte("1234567887654321")).CryptBlocks(headerPlain, headerCipher[:cryptoHeaderSize])
+	return &CryptoHeader{
+		IV:       headerPlain[:blockSize],
+		Padding:  int(headerPlain[blockSize]),
+		Version:  int(headerPlain[blockSize+1]),
+		Reserved: headerPlain[blockSize+2:],
+	}, nil
+}
+
+// DecryptTwofishCBCStreamStrict decrypts like DecryptTwofishCBCStream but also
+// rejects invalid headers with ErrWrongCryptoKey, truncated input with
+// ErrTruncatedCryptoFile and non-zero padding bytes. Truncation exactly at a
+// block boundary of a file without padding cannot be detected.
+func DecryptTwofishCBCStreamStrict(dst io.Writer, src io.Reader, hexkey string) error {
+	return decryptTwofishCBCParallel(dst, src, hexkey, true, 0)
+}
+
+// VerifyCryptoStream reads a whole encrypted blob, checking it as DecryptTwofishCBCStreamStrict does,
+// and returns its header and sizes without keeping the plaintext
+func VerifyCryptoStream(src io.Reader, hexkey string) (*CryptoFileInfo, error) {
+	info := &CryptoFileInfo{}
+	cr := &countingReader{r: src}
+	hw := &headerCapture{}
+	err := decryptTwofishCBCParallel(&plainCounter{n: &info.PlainSize}, io.TeeReader(cr, hw), hexkey, true, 0)
+	info.CipherSize = cr.n
+	if len(hw.buf) == cryptoHeaderSize {
+		if hdr, herr := DecryptCryptoHeader(hw.buf, hexkey); herr == nil {
+			info.Header = *hdr
+		}
+	}
+	if err != nil {
+		return info, err
+	}
+	return info, nil
+}
+
+// DecryptLocalFile decrypts an exported encrypted file into dstPath, writing
+// through a temporary file so that a failed check leaves no partial output
+func DecryptLocalFile(srcPath, dstPath, hexkey string) error {
+	in, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	return writeFileAtomic(dstPath, func(w io.Writer) error {
+		return DecryptTwofishCBCStreamStrict(w, in, hexkey)
+	})
+}
+
+// EncryptLocalFile encrypts srcPath into dstPath in the Icedrive file format
+func EncryptLocalFile(srcPath, dstPath, hexkey string) error {
+	in, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(dstPath, func(w io.Writer) error {
+		return EncryptTwofishCBCStream(w, in, hexkey, uint64(fi.Size()))
+	})
+}
+
+func writeFileAtomic(dstPath string, fill func(io.Writer) error) error {
+	if dir := filepath.Dir(dstPath); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	tmp := dstPath + ".part"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := fill(out); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dstPath)
+}
+
+// headerCapture keeps the first bytes written to it
+type headerCapture struct {
+	buf []byte
+}
+
+func (hc *headerCapture) Write(p []byte) (int, error) {
+	if need := cryptoHeaderSize - len(hc.buf); need > 0 {
+		if need > len(p) {
+			need = len(p)
+		}
+		hc.buf = append(hc.buf, p[:need]...)
+	}
+	return len(p), nil
+}
+
+// plainCounter discards everything written to it, counting the bytes
+type plainCounter struct {
+	n *int64
+}
+
+func (pc *plainCounter) Write(p []byte) (int, error) {
+	*pc.n += int64(len(p))
+	return len(p), nil
+}
+
+// decryptTwofishCBC decrypts an Icedrive encrypted stream. The body is CBC
+// encrypted with the IV from the header, restarting the CBC chain every 4 MiB
+// of file data (the first chunk includes the header). The last block is held
+// back until EOF so that the padding can be stripped regardless of how the
+// reader reports the end of the data.
+func decryptTwofishCBC(dst io.Writer, src io.Reader, hexkey string, strict bool) error {
+	block, err := newTwofishBlock(hexkey)
+	if err != nil {
+		return err
+	}
+
+	headerCipher := make([]byte, cryptoHeaderSize)
+	if _, err := io.ReadFull(src, headerCipher); err != nil {
+		if strict && (err == io.EOF || err == io.ErrUnexpectedEOF) {
+			return ErrTruncatedCryptoFile
+		}
+		return err
+	}
+	hdr, err := decryptCryptoHeader(headerCipher, block)
+	if err != nil {
+		return err
+	}
+	if strict && !hdr.Valid() {
+		return ErrWrongCryptoKey
+	}
+	if hdr.Version != 0 {
+		return fmt.Errorf("unsupported file version: %d", hdr.Version)
+	}
+
+	newCBC := func() cipher.BlockMode { return cipher.NewCBCDecrypter(block, hdr.IV) }
+	cbc := newCBC()
+	chunkRemaining := cryptoChunkSize - cryptoHeaderSize
+
+	buf := make([]byte, 128*1024)
+	out := make([]byte, len(buf))
+	held := make([]byte, 0, blockSize)
+	n := 0
+
+	for {
+		m, rerr := src.Read(buf[n:])
+		n += m
+
+		aligned := (n / blockSize) * blockSize
+		for done := 0; done < aligned; {
+			if chunkRemaining == 0 {
+				cbc = newCBC()
+				chunkRemaining = cryptoChunkSize
+			}
+			toProcess := aligned - done
+			if toProcess > chunkRemaining {
+				toProcess = chunkRemaining
+			}
+			cbc.CryptBlocks(out[done:done+toProcess], buf[done:done+toProcess])
+			done += toProcess
+			chunkRemaining -= toProcess
+		}
+		if aligned > 0 {
+			if len(held) > 0 {
+				if _, err := dst.Write(held); err != nil {
+					return err
+				}
+			}
+			if aligned > blockSize {
+				if _, err := dst.Write(out[:aligned-blockSize]); err != nil {
+					return err
+				}
+			}
+			held = append(held[:0], out[aligned-blockSize:aligned]...)
+			n = copy(buf, buf[aligned:n])
+		}
+
+		if rerr == io.EOF {
+			if n != 0 {
+				if strict {
+					return ErrTruncatedCryptoFile
+				}
+				return io.ErrUnexpectedEOF
+			}
+			return finishDecrypt(dst, held, hdr.Padding, strict)
+		}
+		if rerr != nil {
+			return rerr
+		}
+	}
+}
+
+// finishDecrypt writes the held back last block without its padding
+func finishDecrypt(dst io.Writer, last []byte, numPadding int, strict bool) error {
+	if numPadding < 0 || numPadding > len(last) {
+		if strict && len(last) == 0 {
+			return ErrTruncatedCryptoFile
+		}
+		return fmt.Errorf("invalid padding")
+	}
+	keep := len(last) - numPadding
+	if strict {
+		for _, b := range last[keep:] {
+			if b != 0 {
+				return fmt.Errorf("invalid padding: %w", ErrTruncatedCryptoFile)
+			}
+		}
+	}
+	if keep == 0 {
+		return nil
+	}
+	_, err := dst.Write(last[:keep])
+	return err
+}
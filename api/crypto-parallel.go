@@ -0,0 +1,176 @@
+package api
+
+import (
+	"crypto/cipher"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// cryptoSegmentSize is the unit of work of the parallel pipelines. It divides
+// the 4 MiB chunk size so that segments never straddle a CBC restart.
+const cryptoSegmentSize = 1024 * 1024
+
+// segmentPool recycles segment buffers between parallel encryptions and decryptions
+var segmentPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, cryptoSegmentSize)
+		return &b
+	},
+}
+
+// cryptoSegment is one unit of work flowing through a parallel pipeline in order
+type cryptoSegment struct {
+	buf  *[]byte
+	n    int
+	iv   [blockSize]byte
+	done chan struct{}
+}
+
+// DecryptTwofishCBCStreamParallel decrypts like DecryptTwofishCBCStream but
+// spreads the work across workers goroutines (GOMAXPROCS if workers <= 0).
+//
+// CBC decryption of a block only needs the previous ciphertext block, so the
+// body is cut into 1 MiB segments, each decrypted independently with the last
+// ciphertext block of its predecessor (or the file IV at a 4 MiB chunk start)
+// as IV, and written out in order.
+func DecryptTwofishCBCStreamParallel(dst io.Writer, src io.Reader, hexkey string, workers int) error {
+	return decryptTwofishCBCParallel(dst, src, hexkey, false, workers)
+}
+
+func decryptTwofishCBCParallel(dst io.Writer, src io.Reader, hexkey string, strict bool, workers int) error {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers == 1 {
+		return decryptTwofishCBC(dst, src, hexkey, strict)
+	}
+	block, err := newTwofishBlock(hexkey)
+	if err != nil {
+		return err
+	}
+
+	headerCipher := make([]byte, cryptoHeaderSize)
+	if _, err := io.ReadFull(src, headerCipher); err != nil {
+		if strict && (err == io.EOF || err == io.ErrUnexpectedEOF) {
+			return ErrTruncatedCryptoFile
+		}
+		return err
+	}
+	hdr, err := decryptCryptoHeader(headerCipher, block)
+	if err != nil {
+		return err
+	}
+	if strict && !hdr.Valid() {
+		return ErrWrongCryptoKey
+	}
+	if hdr.Version != 0 {
+		return fmt.Errorf("unsupported file version: %d", hdr.Version)
+	}
+
+	jobs := make(chan *cryptoSegment, workers)
+	ordered := make(chan *cryptoSegment, 2*workers)
+	stop := make(chan struct{})
+	readErr := make(chan error, 1)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for seg := range jobs {
+				b := (*seg.buf)[:seg.n]
+				cipher.NewCBCDecrypter(block, seg.iv[:]).CryptBlocks(b, b)
+				close(seg.done)
+			}
+		}()
+	}
+
+	// Reader: cut the body into segments, never crossing a 4 MiB chunk boundary
+	go func() {
+		defer close(ordered)
+		defer close(jobs)
+		chunkRemaining := cryptoChunkSize - cryptoHeaderSize
+		var prev [blockSize]byte
+		copy(prev[:], hdr.IV)
+		for {
+			if chunkRemaining == 0 {
+				chunkRemaining = cryptoChunkSize
+				copy(prev[:], hdr.IV)
+			}
+			want := cryptoSegmentSize
+			if want > chunkRemaining {
+				want = chunkRemaining
+			}
+			buf := segmentPool.Get().(*[]byte)
+			n, err := io.ReadFull(src, (*buf)[:want])
+			if n%blockSize != 0 {
+				segmentPool.Put(buf)
+				if strict {
+					readErr <- ErrTruncatedCryptoFile
+				} else {
+					readErr <- io.ErrUnexpectedEOF
+				}
+				return
+			}
+			if n > 0 {
+				seg := &cryptoSegment{buf: buf, n: n, iv: prev, done: make(chan struct{})}
+				copy(prev[:], (*buf)[n-blockSize:n])
+				chunkRemaining -= n
+				select {
+				case ordered <- seg:
+				case <-stop:
+					segmentPool.Put(buf)
+					return
+				}
+				jobs <- seg
+			} else {
+				segmentPool.Put(buf)
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				readErr <- nil
+				return
+			}
+			if err != nil {
+				readErr <- err
+				return
+			}
+		}
+	}()
+
+	// Writer: emit segments in order, holding back the last block for padding removal
+	var held [blockSize]byte
+	haveHeld := false
+	var werr error
+	for seg := range ordered {
+		<-seg.done
+		if werr == nil {
+			b := (*seg.buf)[:seg.n]
+			if haveHeld {
+				_, werr = dst.Write(held[:])
+			}
+			if werr == nil && len(b) > blockSize {
+				_, werr = dst.Write(b[:len(b)-blockSize])
+			}
+			copy(held[:], b[len(b)-blockSize:])
+			haveHeld = true
+			if werr != nil {
+				close(stop)
+			}
+		}
+		segmentPool.Put(seg.buf)
+	}
+	wg.Wait()
+
+	if werr != nil {
+		return werr
+	}
+	if err := <-readErr; err != nil {
+		return err
+	}
+	if !haveHeld {
+		return finishDecrypt(dst, nil, hdr.Padding, strict)
+	}
+	return finishDecrypt(dst, held[:], hdr.Padding, strict)
+}
@@ -0,0 +1,279 @@
+package api
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+)
+
+// chunkPool recycles 4 MiB chunk buffers of the encrypting writer
+var chunkPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, cryptoChunkSize)
+		return &b
+	},
+}
+
+// maxEncryptChunks bounds the chunks an encrypting writer holds at once, being
+// filled, encrypted or waiting to be written, to 24 MiB whatever GOMAXPROCS is
+const maxEncryptChunks = 6
+
+// encryptWriter encrypts into the Icedrive file format. Every 4 MiB chunk of
+// the file restarts the CBC chain with the same IV, so chunks are encrypted
+// independently by a pool of workers and written to dst in order.
+type encryptWriter struct {
+	dst   io.Writer
+	block cipher.Block
+	iv    [blockSize]byte
+	size  int64 // announced plaintext size, -1 if unknown
+
+	written int64
+	cur     *[]byte
+	curN    int
+	curCap  int
+	padding int
+	closed  bool
+	jobs    chan *cryptoSegment
+	ordered chan *cryptoSegment
+	slots   chan struct{} // one per chunk taken from chunkPool
+	workers sync.WaitGroup
+	outDone chan struct{}
+	errMu   sync.Mutex
+	err     error
+}
+
+// NewEncryptWriter returns a writer that encrypts everything written to it
+// into dst, using workers goroutines (GOMAXPROCS if workers <= 0). At most
+// maxEncryptChunks chunks of 4 MiB are in flight, so workers beyond that are
+// not started. Close must be called to flush the last chunk.
+//
+// The header carries the padding length, so the plaintext size must be known
+// up front to stream. With size < 0 nothing reaches dst before Close: the first
+// encrypted chunk is kept in memory and the following ones are spooled to a
+// temporary file, so memory stays bounded at a few chunks but the whole
+// encrypted file takes up temporary disk space until Close.
+func NewEncryptWriter(dst io.Writer, hexkey string, size int64, workers int) (io.WriteCloser, error) {
+	block, err := newTwofishBlock(hexkey)
+	if err != nil {
+		return nil, err
+	}
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > maxEncryptChunks {
+		workers = maxEncryptChunks
+	}
+	e := &encryptWriter{
+		dst:     dst,
+		block:   block,
+		size:    size,
+		curCap:  cryptoChunkSize - cryptoHeaderSize,
+		jobs:    make(chan *cryptoSegment, workers),
+		ordered: make(chan *cryptoSegment, maxEncryptChunks),
+		slots:   make(chan struct{}, maxEncryptChunks),
+		outDone: make(chan struct{}),
+	}
+	if _, err := rand.Read(e.iv[:]); err != nil {
+		return nil, err
+	}
+	if size >= 0 {
+		e.padding = paddingFor(size)
+		if err := e.writeHeader(); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := 0; i < workers; i++ {
+		e.workers.Add(1)
+		go func() {
+			defer e.workers.Done()
+			for seg := range e.jobs {
+				b := (*seg.buf)[:seg.n]
+				cipher.NewCBCEncrypter(e.block, e.iv[:]).CryptBlocks(b, b)
+				close(seg.done)
+			}
+		}()
+	}
+	go e.output()
+	return e, nil
+}
+
+func paddingFor(size int64) int {
+	if m := size % blockSize; m != 0 {
+		return int(blockSize - m)
+	}
+	return 0
+}
+
+func (e *encryptWriter) writeHeader() error {
+	headerPlain := make([]byte, cryptoHeaderSize)
+	copy(headerPlain[:blockSize], e.iv[:])
+	headerPlain[blockSize] = byte(e.padding)
+	headerPlain[blockSize+1] = 0
+	cipher.NewCBCEncrypter(e.block, []byte("1234567887654321")).CryptBlocks(headerPlain, headerPlain)
+	_, err := e.dst.Write(headerPlain)
+	return err
+}
+
+// output writes finished chunks in order; with unknown size they are held
+// until the header can be written, see spool
+func (e *encryptWriter) output() {
+	defer close(e.outDone)
+	var held *cryptoSegment
+	var spool *os.File
+	for seg := range e.ordered {
+		<-seg.done
+		switch {
+		case e.size >= 0:
+			e.emit(seg)
+		case held == nil && spool == nil:
+			held = seg
+		default:
+			if spool == nil {
+				f, err := os.CreateTemp("", "icedrive-encrypt-*")
+				e.setErr(err)
+				spool = f
+				e.spool(spool, held)
+				held = nil
+			}
+			e.spool(spool, seg)
+		}
+	}
+	if e.size >= 0 {
+		return
+	}
+	if e.firstErr() == nil {
+		e.setErr(e.writeHeader())
+	}
+	if held != nil {
+		e.emit(held)
+	}
+	if spool != nil {
+		if e.firstErr() == nil {
+			_, err := spool.Seek(0, io.SeekStart)
+			if err == nil {
+				_, err = io.Copy(e.dst, spool)
+			}
+			e.setErr(err)
+		}
+		spool.Close()
+		os.Remove(spool.Name())
+	}
+}
+
+// spool appends an encrypted chunk to the temporary file of a writer of unknown size
+func (e *encryptWriter) spool(f *os.File, seg *cryptoSegment) {
+	if f != nil && e.firstErr() == nil {
+		_, err := f.Write((*seg.buf)[:seg.n])
+		e.setErr(err)
+	}
+	e.putChunk(seg.buf)
+}
+
+func (e *encryptWriter) emit(seg *cryptoSegment) {
+	if e.firstErr() == nil {
+		_, err := e.dst.Write((*seg.buf)[:seg.n])
+		e.setErr(err)
+	}
+	e.putChunk(seg.buf)
+}
+
+// getChunk takes a chunk buffer from the pool, waiting while maxEncryptChunks are in flight
+func (e *encryptWriter) getChunk() *[]byte {
+	e.slots <- struct{}{}
+	return chunkPool.Get().(*[]byte)
+}
+
+// putChunk returns a chunk buffer to the pool and frees its slot
+func (e *encryptWriter) putChunk(b *[]byte) {
+	chunkPool.Put(b)
+	<-e.slots
+}
+
+func (e *encryptWriter) setErr(err error) {
+	if err == nil {
+		return
+	}
+	e.errMu.Lock()
+	defer e.errMu.Unlock()
+	if e.err == nil {
+		e.err = err
+	}
+}
+
+func (e *encryptWriter) firstErr() error {
+	e.errMu.Lock()
+	defer e.errMu.Unlock()
+	return e.err
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, errors.New("write to closed encrypt writer")
+	}
+	if err := e.firstErr(); err != nil {
+		return 0, err
+	}
+	if e.size >= 0 && e.written+int64(len(p)) > e.size {
+		return 0, fmt.Errorf("write exceeds announced size of %d bytes", e.size)
+	}
+	total := len(p)
+	for len(p) > 0 {
+		if e.cur == nil {
+			e.cur = e.getChunk()
+			e.curN = 0
+		}
+		n := copy((*e.cur)[e.curN:e.curCap], p)
+		e.curN += n
+		e.written += int64(n)
+		p = p[n:]
+		if e.curN == e.curCap {
+			e.submit()
+		}
+	}
+	return total, nil
+}
+
+// submit hands the current chunk to the workers
+func (e *encryptWriter) submit() {
+	seg := &cryptoSegment{buf: e.cur, n: e.curN, done: make(chan struct{})}
+	e.ordered <- seg
+	e.jobs <- seg
+	e.cur = nil
+	e.curCap = cryptoChunkSize
+}
+
+// Close pads and encrypts the last chunk and waits until everything is written to dst
+func (e *encryptWriter) Close() error {
+	if e.closed {
+		return e.firstErr()
+	}
+	e.closed = true
+	if e.size >= 0 && e.written != e.size {
+		e.setErr(fmt.Errorf("encrypt size mismatch: got %d of %d bytes", e.written, e.size))
+	}
+	if e.size < 0 {
+		e.padding = paddingFor(e.written)
+	}
+	if e.cur != nil && e.curN > 0 && e.firstErr() == nil {
+		// Zero padding up to the block size
+		for i := 0; i < e.padding; i++ {
+			(*e.cur)[e.curN+i] = 0
+		}
+		e.curN += e.padding
+		e.submit()
+	} else if e.cur != nil {
+		e.putChunk(e.cur)
+		e.cur = nil
+	}
+	close(e.jobs)
+	close(e.ordered)
+	e.workers.Wait()
+	<-e.outDone
+	return e.firstErr()
+}
@@ -1,15 +1,18 @@
 package api
 
 import (
-	"crypto/cipher"
+	"bytes"
 	"crypto/pbkdf2"
-	"crypto/rand"
 	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/url"
 	"strings"
 
@@ -24,6 +27,9 @@ type cryptoAuthResp struct {
 
 const blockSize = 16
 
+// ErrWrongCryptoPassword is returned when a derived crypto key does not match the stored hash
+var ErrWrongCryptoPassword = errors.New("crypto password does not match stored hash")
+
 // DecryptFilename takes the 64-byte hex key and a hex-encoded filename string
 // and returns the decoded plaintext filename.
 func DecryptFilename(keyHex string, cipherHex string) (string, error) {
@@ -139,391 +145,34 @@ func EncryptFilename(keyHex string, filename string) (string, error) {
 }
 
 func DecryptTwofishCBCStream(dst io.Writer, src io.Reader, hexkey string) error {
-	key, err := hex.DecodeString(hexkey)
-	if err != nil {
-		return err
-	}
-	if l := len(key); l != 16 && l != 24 && l != 32 {
-		return fmt.Errorf("invalid key length: %d", l)
-	}
-	block, err := twofish.NewCipher(key)
-	if err != nil {
-		return err
-	}
-
-	const blockSize = 16
-	const chunkSize = 4 * 1024 * 1024
-
-	headerCipher := make([]byte, 2*blockSize)
-	if _, err := io.ReadFull(src, headerCipher); err != nil {
-		return err
-	}
-
-	headerIV := []byte("1234567887654321")
-	hcbc := cipher.NewCBCDecrypter(block, headerIV)
-	headerPlain := make([]byte, len(headerCipher))
-	hcbc.CryptBlocks(headerPlain, headerCipher)
-
-	contentIV := headerPlain[:blockSize]
-	numPadding := int(headerPlain[blockSize])
-	if headerPlain[blockSize+1] != 0 {
-		return fmt.Errorf("unsupported file version: %d", headerPlain[blockSize+1])
-	}
-
-	newCBC := func() cipher.BlockMode { return cipher.NewCBCDecrypter(block, contentIV) }
-	cbc := newCBC()
-	chunkRemaining := chunkSize - 2*blockSize
-
-	buf := make([]byte, 128*1024)
-	var carry []byte
-
-	writeOut := func(b []byte, final bool) error {
-		if final {
-			if numPadding < 0 || numPadding > len(b) {
-				return fmt.Errorf("invalid padding")
-			}
-			b = b[:len(b)-numPadding]
-		}
-		if len(b) == 0 {
-			return nil
-		}
-		_, err := dst.Write(b)
-		return err
-	}
-
-	for {
-		n, rerr := src.Read(buf)
-		if n > 0 {
-			data := append(carry, buf[:n]...)
-			for len(data) >= blockSize {
-				toProcess := len(data)
-				if toProcess > chunkRemaining {
-					toProcess = chunkRemaining
-				}
-				toProcess = (toProcess / blockSize) * blockSize
-				if toProcess == 0 {
-					break
-				}
-				out := make([]byte, toProcess)
-				cbc.CryptBlocks(out, data[:toProcess])
-				data = data[toProcess:]
-				chunkRemaining -= toProcess
-
-				lastRead := rerr == io.EOF && len(data) == 0
-				if lastRead {
-					if err := writeOut(out, true); err != nil {
-						return err
-					}
-				} else {
-					if err := writeOut(out, false); err != nil {
-						return err
-					}
-				}
-
-				if chunkRemaining == 0 && !lastRead {
-					cbc = newCBC()
-					chunkRemaining = chunkSize
-				}
-			}
-			carry = data
-		}
-
-		if rerr != nil {
-			if rerr == io.EOF {
-				if len(carry) != 0 {
-					return io.ErrUnexpectedEOF
-				}
-				return nil
-			}
-			return rerr
-		}
-	}
+	return decryptTwofishCBC(dst, src, hexkey, false)
 }
 
 func EncryptTwofishCBCStream(dst io.Writer, src io.Reader, hexkey string, totalSize uint64) error {
-	key, err := hex.DecodeString(hexkey)
+	w, err := NewEncryptWriter(dst, hexkey, int64(totalSize), 0)
 	if err != nil {
 		return err
 	}
-	if l := len(key); l != 16 && l != 24 && l != 32 {
-		return fmt.Errorf("invalid key length: %d", l)
-	}
-	block, err := twofish.NewCipher(key)
-	if err != nil {
-		return err
-	}
-	const blockSize = 16
-	const chunkSize = 4 * 1024 * 1024
-	iv := make([]byte, blockSize)
-	if _, err := rand.Read(iv); err != nil {
+	if _, err := io.Copy(w, src); err != nil {
+		_ = w.Close()
 		return err
 	}
-	numPadding := byte(0)
-	if m := totalSize % blockSize; m != 0 {
-		numPadding = byte(blockSize - m)
-	}
-	headerPlain := make([]byte, 2*blockSize)
-	copy(headerPlain[:blockSize], iv)
-	headerPlain[blockSize] = numPadding
-	headerPlain[blockSize+1] = 0
-	headerIV := []byte("1234567887654321")
-	headerCBC := cipher.NewCBCEncrypter(block, headerIV)
-	headerCipher := make([]byte, len(headerPlain))
-	headerCBC.CryptBlocks(headerCipher, headerPlain)
-	if _, err := dst.Write(headerCipher); err != nil {
-		return err
-	}
-	newCBC := func() cipher.BlockMode { return cipher.NewCBCEncrypter(block, iv) }
-	cbc := newCBC()
-	chunkRemaining := chunkSize - 2*blockSize
-	buf := make([]byte, 128*1024)
-	var carry []byte
-	var readTotal uint64
-	for {
-		n, rerr := src.Read(buf)
-		if n > 0 {
-			readTotal += uint64(n)
-			data := append(carry, buf[:n]...)
-			for len(data) >= blockSize {
-				toProcess := len(data)
-				if toProcess > chunkRemaining {
-					toProcess = chunkRemaining
-				}
-				toProcess = (toProcess / blockSize) * blockSize
-				if toProcess == 0 {
-					break
-				}
-				out := make([]byte, toProcess)
-				cbc.CryptBlocks(out, data[:toProcess])
-				if _, err := dst.Write(out); err != nil {
-					return err
-				}
-				data = data[toProcess:]
-				chunkRemaining -= toProcess
-				if chunkRemaining == 0 && !(rerr == io.EOF && len(data) == 0) {
-					cbc = newCBC()
-					chunkRemaining = chunkSize
-				}
-			}
-			carry = data
-		}
-		if rerr != nil {
-			if rerr == io.EOF {
-				final := carry
-				if numPadding > 0 {
-					final = append(final, make([]byte, int(numPadding))...)
-				}
-				if len(final)%blockSize != 0 {
-					return fmt.Errorf("final block size invalid")
-				}
-				for len(final) > 0 {
-					toProcess := len(final)
-					if toProcess > chunkRemaining {
-						toProcess = chunkRemaining
-					}
-					toProcess = (toProcess / blockSize) * blockSize
-					out := make([]byte, toProcess)
-					cbc.CryptBlocks(out, final[:toProcess])
-					if _, err := dst.Write(out); err != nil {
-						return err
-					}
-					final = final[toProcess:]
-					chunkRemaining -= toProcess
-					if chunkRemaining == 0 && len(final) > 0 {
-						cbc = newCBC()
-						chunkRemaining = chunkSize
-					}
-				}
-				return nil
-			}
-			return rerr
-		}
-	}
+	return w.Close()
 }
 
+// EncryptTwofishCBCStreamUnknownSize encrypts a stream whose size is not known
+// in advance. The encrypted data is spooled to a temporary file until src is
+// exhausted, because the header that starts the output carries the padding length.
 func EncryptTwofishCBCStreamUnknownSize(dst io.Writer, src io.Reader, hexkey string) error {
-	key, err := hex.DecodeString(hexkey)
+	w, err := NewEncryptWriter(dst, hexkey, -1, 0)
 	if err != nil {
 		return err
 	}
-	if l := len(key); l != 16 && l != 24 && l != 32 {
-		return fmt.Errorf("invalid key length: %d", l)
-	}
-	block, err := twofish.NewCipher(key)
-	if err != nil {
+	if _, err := io.Copy(w, src); err != nil {
+		_ = w.Close()
 		return err
 	}
-
-	const (
-		blockSize = 16
-		chunkSize = 4 * 1024 * 1024
-	)
-
-	// Read plaintext into memory in fixed-size chunks (no temp files).
-	var (
-		chunks    [][]byte
-		totalSize uint64
-	)
-	for {
-		buf := make([]byte, 128*1024)
-		n, rerr := src.Read(buf)
-		if n > 0 {
-			totalSize += uint64(n)
-			// keep exact size written
-			chunk := make([]byte, n)
-			copy(chunk, buf[:n])
-			chunks = append(chunks, chunk)
-		}
-		if rerr != nil {
-			if rerr == io.EOF {
-				break
-			}
-			return rerr
-		}
-	}
-
-	iv := make([]byte, blockSize)
-	if _, err := rand.Read(iv); err != nil {
-		return err
-	}
-
-	var numPadding byte
-	if m := totalSize % blockSize; m != 0 {
-		numPadding = byte(blockSize - m)
-	}
-
-	// Header (same format as size-known variant)
-	headerPlain := make([]byte, 2*blockSize)
-	copy(headerPlain[:blockSize], iv)
-	headerPlain[blockSize] = numPadding
-	headerPlain[blockSize+1] = 0
-
-	headerIV := []byte("1234567887654321")
-	headerCBC := cipher.NewCBCEncrypter(block, headerIV)
-	headerCipher := make([]byte, len(headerPlain))
-	headerCBC.CryptBlocks(headerCipher, headerPlain)
-
-	if _, err := dst.Write(headerCipher); err != nil {
-		return err
-	}
-
-	// Encrypt body with CBC and the same 4 MiB chunking semantics
-	newCBC := func() cipher.BlockMode { return cipher.NewCBCEncrypter(block, iv) }
-	cbc := newCBC()
-	remaining := chunkSize - 2*blockSize
-
-	// stream over chunks, handling block alignment and per-chunk resets
-	var carry []byte
-	writeBlocks := func(data []byte, final bool) error {
-		data = append(carry, data...)
-		for {
-			toProcess := len(data)
-			if toProcess == 0 {
-				carry = data
-				return nil
-			}
-			// respect remaining per-chunk window
-			if toProcess > remaining {
-				toProcess = remaining
-			}
-			// only full blocks except at the very end when final
-			if !final {
-				toProcess = (toProcess / blockSize) * blockSize
-			} else {
-				// for final, we may need to pad
-				if (toProcess % blockSize) != 0 {
-					// leave partial block in carry to be padded later
-					toProcess = (toProcess / blockSize) * blockSize
-				}
-			}
-			if toProcess == 0 {
-				carry = data
-				return nil
-			}
-			out := make([]byte, toProcess)
-			cbc.CryptBlocks(out, data[:toProcess])
-			if _, err := dst.Write(out); err != nil {
-				return err
-			}
-			data = data[toProcess:]
-			remaining -= toProcess
-			if remaining == 0 && !(final && len(data) == 0) {
-				cbc = newCBC()
-				remaining = chunkSize
-			}
-		}
-	}
-
-	for i := 0; i < len(chunks); i++ {
-		final := i == len(chunks)-1
-		if err := writeBlocks(chunks[i], final); err != nil {
-			return err
-		}
-	}
-
-	// Finalize: pad the last partial block if needed and encrypt it (and any remainder windows)
-	if pad := int(numPadding); pad > 0 {
-		last := append(carry, make([]byte, pad)...)
-		if len(last)%blockSize != 0 {
-			return fmt.Errorf("final block size invalid")
-		}
-		for len(last) > 0 {
-			toProcess := len(last)
-			if toProcess > remaining {
-				toProcess = remaining
-			}
-			toProcess = (toProcess / blockSize) * blockSize
-			if toProcess == 0 {
-				// reset if window exhausted
-				cbc = newCBC()
-				remaining = chunkSize
-				continue
-			}
-			out := make([]byte, toProcess)
-			cbc.CryptBlocks(out, last[:toProcess])
-			if _, err := dst.Write(out); err != nil {
-				return err
-			}
-			last = last[toProcess:]
-			remaining -= toProcess
-			if remaining == 0 && len(last) > 0 {
-				cbc = newCBC()
-				remaining = chunkSize
-			}
-		}
-	} else {
-		// no padding; any carry must be empty or full-block aligned
-		if len(carry) != 0 {
-			if len(carry)%blockSize != 0 {
-				return fmt.Errorf("final block size invalid")
-			}
-			for len(carry) > 0 {
-				toProcess := len(carry)
-				if toProcess > remaining {
-					toProcess = remaining
-				}
-				toProcess = (toProcess / blockSize) * blockSize
-				if toProcess == 0 {
-					cbc = newCBC()
-					remaining = chunkSize
-					continue
-				}
-				out := make([]byte, toProcess)
-				cbc.CryptBlocks(out, carry[:toProcess])
-				if _, err := dst.Write(out); err != nil {
-					return err
-				}
-				carry = carry[toProcess:]
-				remaining -= toProcess
-				if remaining == 0 && len(carry) > 0 {
-					cbc = newCBC()
-					remaining = chunkSize
-				}
-			}
-		}
-	}
-
-	return nil
+	return w.Close()
 }
 
 func FetchCryptoSaltAndStoredHash(h *HTTPClient) (storedHex, salt string, err error) {
@@ -559,3 +208,117 @@ func DeriveCryptoKey(password, salt string) (string, error) {
 	}
 	return hex.EncodeToString(dk), nil
 }
+
+// CryptoHashScheme names a digest the stored crypto hash may be made with
+type CryptoHashScheme string
+
+const (
+	CryptoHashSHA1   CryptoHashScheme = "sha1"
+	CryptoHashSHA256 CryptoHashScheme = "sha256"
+	CryptoHashSHA512 CryptoHashScheme = "sha512"
+)
+
+// cryptoHashSchemes are the candidates tried by MatchCryptoHashScheme
+var cryptoHashSchemes = []CryptoHashScheme{CryptoHashSHA1, CryptoHashSHA256, CryptoHashSHA512}
+
+// CryptoKeyHash returns the hex digest of the key bytes under scheme
+func CryptoKeyHash(keyHex string, scheme CryptoHashScheme) (string, error) {
+	key, err := hex.DecodeString(keyHex)
+	if err != nil {
+		return "", err
+	}
+	if len(key) != 32 {
+		return "", errors.New("key must decode to 32 bytes (64 hex chars)")
+	}
+	switch scheme {
+	case CryptoHashSHA1:
+		sum := sha1.Sum(key)
+		return hex.EncodeToString(sum[:]), nil
+	case CryptoHashSHA256:
+		sum := sha256.Sum256(key)
+		return hex.EncodeToString(sum[:]), nil
+	case CryptoHashSHA512:
+		sum := sha512.Sum512(key)
+		return hex.EncodeToString(sum[:]), nil
+	}
+	return "", fmt.Errorf("unknown crypto hash scheme %q", scheme)
+}
+
+// MatchCryptoHashScheme returns the scheme under which the key hashes to
+// storedHex, or ErrWrongCryptoPassword if there is none
+func MatchCryptoHashScheme(keyHex, storedHex string) (CryptoHashScheme, error) {
+	stored := []byte(strings.ToLower(strings.TrimSpace(storedHex)))
+	if len(stored) == 0 {
+		return "", errors.New("missing stored hash")
+	}
+	var found CryptoHashScheme
+	for _, scheme := range cryptoHashSchemes {
+		sum, err := CryptoKeyHash(keyHex, scheme)
+		if err != nil {
+			return "", err
+		}
+		if subtle.ConstantTimeCompare([]byte(sum), stored) == 1 {
+			found = scheme
+		}
+	}
+	if found == "" {
+		return "", ErrWrongCryptoPassword
+	}
+	return found, nil
+}
+
+// VerifyCryptoKey checks a derived key against the stored hash returned by
+// FetchCryptoSaltAndStoredHash and returns ErrWrongCryptoPassword on mismatch.
+// The digest Icedrive stores has not been confirmed against a real account;
+// SHA-1, SHA-256 and SHA-512 of the key bytes are accepted.
+func VerifyCryptoKey(keyHex, storedHex string) error {
+	_, err := MatchCryptoHashScheme(keyHex, storedHex)
+	return err
+}
+
+// SetCryptoStoredHash replaces the crypto hash stored on the server, e.g.
+// after the vault was re-keyed. Like the ICE:: format it writes, the request
+// mirrors GET /crypto-auth and has not been confirmed against the apps.
+func SetCryptoStoredHash(h *HTTPClient, storedHex, salt string) error {
+	if h == nil {
+		h = NewHTTPClientWithEnv()
+	}
+	if strings.TrimSpace(h.GetBearerToken()) == "" {
+		return fmt.Errorf("missing bearer token; call Login first")
+	}
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	_ = w.SetBoundary("----geckoformboundary" + randHex(16))
+	_ = w.WriteField("request", "crypto-auth")
+	_ = w.WriteField("hash", "ICE::"+storedHex+"::"+salt)
+	if err := w.Close(); err != nil {
+		return err
+	}
+	status, _, body, err := h.httpPOST("/crypto-auth", w.FormDataContentType(), buf.Bytes())
+	if err != nil {
+		return err
+	}
+	if status >= 400 {
+		return fmt.Errorf("crypto-auth update failed with status %d", status)
+	}
+	var resp cryptoAuthResp
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return err
+	}
+	if resp.Error {
+		return errors.New("crypto-auth update rejected")
+	}
+	return nil
+}
+
+// DeriveAndVerifyCryptoKey derives the crypto key and checks it against the stored hash
+func DeriveAndVerifyCryptoKey(password, salt, storedHex string) (string, error) {
+	keyHex, err := DeriveCryptoKey(password, salt)
+	if err != nil {
+		return "", err
+	}
+	if err := VerifyCryptoKey(keyHex, storedHex); err != nil {
+		return "", err
+	}
+	return keyHex, nil
+}
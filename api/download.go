@@ -37,7 +37,7 @@ func GetDownloadURLs(h *HTTPClient, itemUIDs []string, crypto bool) ([]DownloadU
 	if h == nil {
 		h = NewHTTPClientWithEnv()
 	}
-	if strings.TrimSpace(h.bearer) == "" {
+	if strings.TrimSpace(h.GetBearerToken()) == "" {
 		return nil, fmt.Errorf("missing bearer token; call Login first")
 	}
 
@@ -137,7 +137,7 @@ func DownloadFile(h *HTTPClient, item Item, destPath string, crypted bool) error
 			return err
 		}
 	} else {
-		if err := DecryptTwofishCBCStream(out, res.Body, h.GetCryptoKeyHex()); err != nil {
+		if err := DecryptTwofishCBCStreamParallel(out, res.Body, h.GetCryptoKeyHex(), 0); err != nil {
 			return err
 		}
 	}
@@ -154,15 +154,36 @@ func OpenDownloadStream(h *HTTPClient, item Item, crypted bool) (io.ReadCloser,
 	if h == nil {
 		h = NewHTTPClientWithEnv()
 	}
-	if strings.TrimSpace(h.bearer) == "" {
+	if !crypted {
+		return OpenDownloadStreamWithKey(h, item, "")
+	}
+	return OpenDownloadStreamWithKey(h, item, h.GetCryptoKeyHex())
+}
+
+// OpenDownloadStreamWithKey opens a download stream for an encrypted item using
+// the given crypto key instead of the client's. An empty key downloads a plain item.
+func OpenDownloadStreamWithKey(h *HTTPClient, item Item, hexkey string) (io.ReadCloser, error) {
+	if h == nil {
+		h = NewHTTPClientWithEnv()
+	}
+	if strings.TrimSpace(h.GetBearerToken()) == "" {
 		return nil, fmt.Errorf("missing bearer token; call Login first")
 	}
-	urls, err := GetDownloadURLs(h, []string{item.UID}, crypted)
+	urls, err := GetDownloadURLs(h, []string{item.UID}, hexkey != "")
 	if err != nil {
 		return nil, err
 	}
-	dl := urls[0]
-	req, err := http.NewRequest("GET", dl.URL, nil)
+	return OpenDownloadURL(h, urls[0].URL, hexkey)
+}
+
+// OpenDownloadURL opens a URL returned by GetDownloadURLs as a stream,
+// decrypting it with hexkey unless the key is empty
+func OpenDownloadURL(h *HTTPClient, url string, hexkey string) (io.ReadCloser, error) {
+	if h == nil {
+		h = NewHTTPClientWithEnv()
+	}
+	crypted := hexkey != ""
+	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -184,7 +205,7 @@ func OpenDownloadStream(h *HTTPClient, item Item, crypted bool) (io.ReadCloser,
 	pr, pw := io.Pipe()
 	go func() {
 		defer resp.Body.Close()
-		if err := DecryptTwofishCBCStream(pw, resp.Body, h.GetCryptoKeyHex()); err != nil {
+		if err := DecryptTwofishCBCStreamParallel(pw, resp.Body, hexkey, 0); err != nil {
 			_ = pw.CloseWithError(err)
 			return
 		}
@@ -200,7 +221,7 @@ func GetPlainSize(h *HTTPClient, item Item, crypted bool) (int64, error) {
 	if h == nil {
 		h = NewHTTPClientWithEnv()
 	}
-	if strings.TrimSpace(h.bearer) == "" {
+	if strings.TrimSpace(h.GetBearerToken()) == "" {
 		return 0, fmt.Errorf("missing bearer token; call Login first")
 	}
 	urls, err := GetDownloadURLs(h, []string{item.UID}, crypted)
@@ -10,6 +10,14 @@ import (
 )
 
 func RenameFile(h *HTTPClient, item Item, newName string, keepExt bool) error {
+	if h == nil {
+		h = NewHTTPClientWithEnv()
+	}
+	return RenameFileWithKey(h, item, newName, keepExt, h.GetCryptoKeyHex())
+}
+
+// RenameFileWithKey renames a file, encrypting the name of crypto items with hexkey
+func RenameFileWithKey(h *HTTPClient, item Item, newName string, keepExt bool, hexkey string) error {
 	if h == nil {
 		h = NewHTTPClientWithEnv()
 	}
@@ -19,10 +27,11 @@ func RenameFile(h *HTTPClient, item Item, newName string, keepExt bool) error {
 	_ = w.WriteField("id", item.UID)
 	if item.Crypto == 1 {
 		_ = w.WriteField("crypto", "1")
-		encFileName, err := EncryptFilename(h.GetCryptoKeyHex(), newName)
-		if err == nil {
-			_ = w.WriteField("filename", encFileName)
+		encFileName, err := EncryptFilename(hexkey, newName)
+		if err != nil {
+			return err
 		}
+		_ = w.WriteField("filename", encFileName)
 	} else {
 		_ = w.WriteField("filename", newName)
 	}
@@ -55,6 +64,14 @@ func RenameFile(h *HTTPClient, item Item, newName string, keepExt bool) error {
 }
 
 func RenameFolder(h *HTTPClient, item Item, newName string) error {
+	if h == nil {
+		h = NewHTTPClientWithEnv()
+	}
+	return RenameFolderWithKey(h, item, newName, h.GetCryptoKeyHex())
+}
+
+// RenameFolderWithKey renames a folder, encrypting the name of crypto items with hexkey
+func RenameFolderWithKey(h *HTTPClient, item Item, newName string, hexkey string) error {
 	if h == nil {
 		h = NewHTTPClientWithEnv()
 	}
@@ -65,10 +82,11 @@ func RenameFolder(h *HTTPClient, item Item, newName string) error {
 
 	if item.Crypto == 1 {
 		_ = w.WriteField("crypto", "1")
-		encFileName, err := EncryptFilename(h.GetCryptoKeyHex(), newName)
-		if err == nil {
-			_ = w.WriteField("filename", encFileName)
+		encFileName, err := EncryptFilename(hexkey, newName)
+		if err != nil {
+			return err
 		}
+		_ = w.WriteField("filename", encFileName)
 	} else {
 		_ = w.WriteField("filename", newName)
 	}
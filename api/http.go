@@ -5,6 +5,7 @@ import (
 	"compress/gzip"
 	"compress/zlib"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/cookiejar"
@@ -30,10 +31,14 @@ func (e *APIError) IsAuthError() bool {
 // ReloginFunc is a function that can re-authenticate the client
 type ReloginFunc func() error
 
+// ErrNoReloginFunc is returned when authentication fails and no re-login function is set
+var ErrNoReloginFunc = errors.New("no re-login function set")
+
 type HTTPClient struct {
 	c            *http.Client
 	jar          http.CookieJar
 	bearer       string
+	bearerMutex  sync.RWMutex
 	debug        bool
 	headers      string
 	apiBase      string
@@ -43,6 +48,9 @@ type HTTPClient struct {
 	reloginFunc  ReloginFunc
 	reloginMutex sync.Mutex
 
+	// Pool this client belongs to; re-login is coordinated there when set
+	pool *HTTPClientPool
+
 	// Upload endpoints cache
 	uploadEndpoints      []string
 	uploadEndpointsTime  time.Time
@@ -62,6 +70,8 @@ func NewHTTPClientWithEnv() *HTTPClient {
 }
 
 func (h *HTTPClient) SetBearerToken(t string) {
+	h.bearerMutex.Lock()
+	defer h.bearerMutex.Unlock()
 	h.bearer = t
 }
 
@@ -70,6 +80,8 @@ func (h *HTTPClient) SetCryptoKeyHex(hex string) {
 }
 
 func (h *HTTPClient) GetBearerToken() string {
+	h.bearerMutex.RLock()
+	defer h.bearerMutex.RUnlock()
 	return h.bearer
 }
 
@@ -162,14 +174,15 @@ func (h *HTTPClient) SetHeaders(headers string) {
 }
 
 func (h *HTTPClient) addHeaders(req *http.Request) {
+	bearer := h.GetBearerToken()
 	for _, kv := range parseEnvHeaders(h.headers) {
-		if strings.EqualFold(kv[0], "authorization") && h.bearer != "" {
+		if strings.EqualFold(kv[0], "authorization") && bearer != "" {
 			continue
 		}
 		req.Header.Set(kv[0], kv[1])
 	}
-	if h.bearer != "" {
-		req.Header.Set("Authorization", "Bearer "+h.bearer)
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
 	}
 	if req.Header.Get("User-Agent") == "" {
 		req.Header.Set("User-Agent", "Mozilla/5.0")
@@ -219,62 +232,59 @@ func decodeBody(res *http.Response) ([]byte, error) {
 
 // withRetryOnAuthError wraps an HTTP operation and retries it after re-login if auth fails
 func (h *HTTPClient) withRetryOnAuthError(operation func() (int, http.Header, []byte, error)) (int, http.Header, []byte, error) {
+	staleToken := h.GetBearerToken()
 	status, headers, body, err := operation()
+	if err != nil {
+		return status, headers, body, err
+	}
 
 	// Check for HTTP-level auth errors (401 Unauthorized, 403 Forbidden)
-	if err == nil && (status == 401 || status == 403) {
-		h.reloginMutex.Lock()
-		reloginFunc := h.reloginFunc
-		h.reloginMutex.Unlock()
-
-		if reloginFunc != nil {
-			// Clear the invalid token before attempting re-login
-			oldToken := h.bearer
-			h.bearer = ""
-
-			reloginErr := reloginFunc()
-
-			if reloginErr == nil {
-				// Re-login succeeded, retry the original operation
-				return operation()
-			} else {
-				// Re-login failed, restore the old token (even though it's invalid)
-				h.bearer = oldToken
-			}
-		}
-	}
+	authFailed := status == 401 || status == 403
 
 	// If the request succeeded at HTTP level, check for API-level auth errors
-	if err == nil && body != nil {
+	if !authFailed && body != nil {
 		apiErr, parseErr := tryParseAPIError(body)
+		authFailed = parseErr == nil && apiErr != nil && apiErr.IsAuthError()
+	}
 
-		if parseErr == nil && apiErr != nil && apiErr.IsAuthError() {
-			// Authentication error detected - try to re-login once
-			h.reloginMutex.Lock()
-			reloginFunc := h.reloginFunc
-			h.reloginMutex.Unlock()
-
-			if reloginFunc != nil {
-				// Clear the invalid token before attempting re-login
-				oldToken := h.bearer
-				h.bearer = ""
-
-				reloginErr := reloginFunc()
-
-				if reloginErr == nil {
-					// Re-login succeeded, retry the original operation
-					return operation()
-				} else {
-					// Re-login failed, restore the old token (even though it's invalid)
-					h.bearer = oldToken
-				}
-			}
-		}
+	if authFailed && h.relogin(staleToken) == nil {
+		// Re-login succeeded, retry the original operation
+		return operation()
 	}
 
 	return status, headers, body, err
 }
 
+// relogin re-authenticates after staleToken was rejected. Pooled clients defer
+// to the pool so that only one login runs at a time; standalone clients call
+// their own ReloginFunc.
+func (h *HTTPClient) relogin(staleToken string) error {
+	if h.pool != nil {
+		token, err := h.pool.Relogin(staleToken)
+		if err != nil {
+			return err
+		}
+		h.SetBearerToken(token)
+		return nil
+	}
+
+	h.reloginMutex.Lock()
+	reloginFunc := h.reloginFunc
+	h.reloginMutex.Unlock()
+	if reloginFunc == nil {
+		return ErrNoReloginFunc
+	}
+
+	// Clear the invalid token before attempting re-login
+	h.SetBearerToken("")
+	if err := reloginFunc(); err != nil {
+		// Re-login failed, restore the old token (even though it's invalid)
+		h.SetBearerToken(staleToken)
+		return err
+	}
+	return nil
+}
+
 func (h *HTTPClient) httpGET(u string) (int, http.Header, []byte, error) {
 	if h == nil || h.c == nil {
 		h = NewHTTPClientWithEnv()
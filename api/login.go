@@ -39,6 +39,35 @@ func LoginWithUsernameAndPassword(h *HTTPClient, email, password, hmacKeyHex str
 	if h == nil {
 		h = NewHTTPClientWithEnv()
 	}
+	formData := url.Values{}
+	formData.Set("password", password)
+	formData.Set("request", "login")
+	formData.Set("email", email)
+	formData.Set("no_token_check", "true")
+	formData.Set("app", "ios")
+
+	lr, err := postLogin(h, formData)
+	if err != nil {
+		return nil, err
+	}
+	if lr.Token == "" {
+		// Accounts with two-factor authentication end up here as well; the
+		// second step of their login is not supported
+		if lr.Error && lr.Message != "" {
+			return nil, fmt.Errorf("login failed: %s", lr.Message)
+		}
+		return nil, fmt.Errorf("login response missing token")
+	}
+	userData, err := UserData(h)
+	if err != nil {
+		return nil, err
+	}
+	h.SetBearerToken(lr.Token)
+	return userData, nil
+}
+
+// postLogin solves a fresh login proof-of-work and submits the login form
+func postLogin(h *HTTPClient, formData url.Values) (*LoginResponse, error) {
 	// Fetch new POW challenge
 	challenge, err := FetchPOWChallenge(h, "login")
 	if err != nil {
@@ -67,16 +96,7 @@ func LoginWithUsernameAndPassword(h *HTTPClient, email, password, hmacKeyHex str
 	if err != nil {
 		return nil, err
 	}
-	powProofStr := base64.StdEncoding.EncodeToString(powProofBytes)
-
-	// Prepare URL-encoded form data
-	formData := url.Values{}
-	formData.Set("password", password)
-	formData.Set("pow_proof", powProofStr)
-	formData.Set("request", "login")
-	formData.Set("email", email)
-	formData.Set("no_token_check", "true")
-	formData.Set("app", "ios")
+	formData.Set("pow_proof", base64.StdEncoding.EncodeToString(powProofBytes))
 	payload := formData.Encode()
 
 	code, _, body, err := h.httpPOST("/api", "application/x-www-form-urlencoded", []byte(payload))
@@ -90,15 +110,7 @@ func LoginWithUsernameAndPassword(h *HTTPClient, email, password, hmacKeyHex str
 	if err = json.Unmarshal(body, &lr); err != nil {
 		return nil, err
 	}
-	if lr.Token == "" {
-		return nil, fmt.Errorf("login response missing token")
-	}
-	userData, err := UserData(h)
-	if err != nil {
-		return nil, err
-	}
-	h.SetBearerToken(lr.Token)
-	return userData, nil
+	return &lr, nil
 }
 
 func LoginWithBearerToken(h *HTTPClient, token string) (*User, error) {
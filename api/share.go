@@ -0,0 +1,281 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Public links. These endpoints are unconfirmed in the same way as those in
+// flags.go. Icedrive does not share items of the encrypted collection, so
+// those are rejected before any request is made. The public-share and
+// public-download requests need no bearer token and carry link passwords in
+// the POST body, never in the URL.
+
+// ErrCryptoShare is returned when a public link is requested for an encrypted item
+var ErrCryptoShare = errors.New("encrypted items cannot be shared publicly")
+
+// PublicLink describes the public link of a file or folder
+type PublicLink struct {
+	// ID identifies the share, it is the last element of URL
+	ID       string `json:"id"`
+	UID      string `json:"uid"`
+	Filename string `json:"filename"`
+	IsFolder int    `json:"isFolder"`
+	Filesize uint64 `json:"filesize"`
+	URL      string `json:"url"`
+	// Password is 1 if the link is password protected
+	Password int `json:"password"`
+	// Expires is the expiry as Unix time, 0 if the link does not expire
+	Expires   uint64 `json:"expires"`
+	Created   uint64 `json:"created"`
+	Downloads int    `json:"downloads"`
+}
+
+// PublicLinkOptions configures a new public link
+type PublicLinkOptions struct {
+	// Password protects the link, none if empty
+	Password string
+	// Expires is when the link stops working, never if zero
+	Expires time.Time
+}
+
+type PublicLinkResponse struct {
+	Error   bool       `json:"error"`
+	Code    int        `json:"code"`
+	Message string     `json:"message"`
+	Link    PublicLink `json:"link"`
+}
+
+type PublicLinksResponse struct {
+	Error   bool         `json:"error"`
+	Code    int          `json:"code"`
+	Message string       `json:"message"`
+	Links   []PublicLink `json:"data"`
+}
+
+// PublicShareResponse is the content of a shared folder, or the shared file itself
+type PublicShareResponse struct {
+	Error   bool   `json:"error"`
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	ID      uint64 `json:"id"`
+	Data    []Item `json:"data"`
+}
+
+// CreatePublicLink creates a public link for a plain file or folder, or
+// updates password and expiry if the item is shared already
+func CreatePublicLink(h *HTTPClient, item Item, opts PublicLinkOptions) (*PublicLink, error) {
+	if h == nil {
+		h = NewHTTPClientWithEnv()
+	}
+	if strings.TrimSpace(h.GetBearerToken()) == "" {
+		return nil, fmt.Errorf("missing bearer token; call Login first")
+	}
+	if item.Crypto == 1 {
+		return nil, ErrCryptoShare
+	}
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	_ = w.SetBoundary("----geckoformboundary" + randHex(16))
+	_ = w.WriteField("request", "public-link-create")
+	_ = w.WriteField("items", item.UID)
+	if opts.Password != "" {
+		_ = w.WriteField("password", opts.Password)
+	}
+	if !opts.Expires.IsZero() {
+		_ = w.WriteField("expires", strconv.FormatInt(opts.Expires.Unix(), 10))
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	status, _, body, err := h.httpPOST("/public-link-create", w.FormDataContentType(), buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if status >= 400 {
+		return nil, fmt.Errorf("public-link-create failed with status %d", status)
+	}
+	var resp PublicLinkResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Error {
+		return nil, fmt.Errorf("public-link-create error: %s", resp.Message)
+	}
+	return &resp.Link, nil
+}
+
+// RemovePublicLinks revokes the public links of items
+func RemovePublicLinks(h *HTTPClient, items ...Item) error {
+	for _, item := range items {
+		if item.Crypto == 1 {
+			return ErrCryptoShare
+		}
+	}
+	return postItemsRequest(h, "public-link-remove", items, nil)
+}
+
+// ListPublicLinks returns all public links of the account
+func ListPublicLinks(h *HTTPClient) ([]PublicLink, error) {
+	if h == nil || strings.TrimSpace(h.GetBearerToken()) == "" {
+		return nil, fmt.Errorf("missing bearer token; call Login first")
+	}
+	var resp PublicLinksResponse
+	if err := getJSON(h, "/public-links", "public-links", &resp, &resp.Error, &resp.Message); err != nil {
+		return nil, err
+	}
+	return resp.Links, nil
+}
+
+// GetPublicLink returns the public link of a shared item
+func GetPublicLink(h *HTTPClient, item Item) (*PublicLink, error) {
+	if h == nil || strings.TrimSpace(h.GetBearerToken()) == "" {
+		return nil, fmt.Errorf("missing bearer token; call Login first")
+	}
+	if item.Crypto == 1 {
+		return nil, ErrCryptoShare
+	}
+	q := url.Values{}
+	q.Set("id", item.UID)
+	var resp PublicLinkResponse
+	if err := getJSON(h, "/public-link?"+q.Encode(), "public-link", &resp, &resp.Error, &resp.Message); err != nil {
+		return nil, err
+	}
+	return &resp.Link, nil
+}
+
+// ParsePublicLink returns the share ID of a public link such as
+// https://icedrive.net/s/abc123; a bare ID is returned as is
+func ParsePublicLink(link string) (string, error) {
+	link = strings.TrimSpace(link)
+	if !strings.Contains(link, "/") {
+		if link == "" {
+			return "", errors.New("empty public link")
+		}
+		return link, nil
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", fmt.Errorf("invalid public link: %w", err)
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	id := parts[len(parts)-1]
+	if len(parts) < 2 || id == "" {
+		return "", fmt.Errorf("invalid public link %q", link)
+	}
+	return id, nil
+}
+
+// GetPublicShare lists a public share without logging in. folderID selects a
+// subfolder of a shared folder, 0 is the shared item itself.
+func GetPublicShare(h *HTTPClient, shareID, password string, folderID uint64) (*PublicShareResponse, error) {
+	if h == nil {
+		h = NewHTTPClientWithEnv()
+	}
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	_ = w.SetBoundary("----geckoformboundary" + randHex(16))
+	_ = w.WriteField("request", "public-share")
+	_ = w.WriteField("id", shareID)
+	if password != "" {
+		_ = w.WriteField("password", password)
+	}
+	if folderID != 0 {
+		_ = w.WriteField("folderId", strconv.FormatUint(folderID, 10))
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	status, _, body, err := h.httpPOST("/public-share", w.FormDataContentType(), buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if status >= 400 {
+		return nil, fmt.Errorf("public-share failed with status %d", status)
+	}
+	var resp PublicShareResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Error {
+		return nil, fmt.Errorf("public-share error: %s", resp.Message)
+	}
+	return &resp, nil
+}
+
+// GetPublicDownloadURLs resolves download URLs for files of a public share
+// without logging in. The URLs can be opened with OpenDownloadURL and an empty key.
+func GetPublicDownloadURLs(h *HTTPClient, shareID, password string, items ...Item) ([]DownloadURLEntry, error) {
+	if h == nil {
+		h = NewHTTPClientWithEnv()
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("no items provided")
+	}
+	uids := make([]string, len(items))
+	for i, item := range items {
+		if item.IsFolder == 1 {
+			return nil, fmt.Errorf("%s is a folder", item.Filename)
+		}
+		uids[i] = item.UID
+	}
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	_ = w.SetBoundary("----geckoformboundary" + randHex(16))
+	_ = w.WriteField("request", "public-download")
+	_ = w.WriteField("id", shareID)
+	if password != "" {
+		_ = w.WriteField("password", password)
+	}
+	_ = w.WriteField("items", strings.Join(uids, ","))
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	status, _, body, err := h.httpPOST("/public-download", w.FormDataContentType(), buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if status >= 400 {
+		return nil, fmt.Errorf("public-download failed with status %d", status)
+	}
+	var resp DownloadMultiResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Error {
+		return nil, fmt.Errorf("public-download error: %s (code %d)", resp.Message, resp.Code)
+	}
+	if len(resp.Urls) != len(items) {
+		return nil, fmt.Errorf("public-download returned %d urls for %d items", len(resp.Urls), len(items))
+	}
+	return resp.Urls, nil
+}
+
+// getJSON performs a GET request and decodes the answer into resp, whose
+// error flag and message are passed along to turn API errors into Go errors
+func getJSON(h *HTTPClient, u, request string, resp any, apiErr *bool, message *string) error {
+	if h == nil {
+		h = NewHTTPClientWithEnv()
+	}
+	status, _, body, err := h.httpGET(u)
+	if err != nil {
+		return err
+	}
+	if status >= 400 {
+		return fmt.Errorf("%s failed with status %d", request, status)
+	}
+	if err := json.Unmarshal(body, resp); err != nil {
+		return err
+	}
+	if *apiErr {
+		return fmt.Errorf("%s error: %s", request, *message)
+	}
+	return nil
+}
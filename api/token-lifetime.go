@@ -0,0 +1,131 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// DefaultTokenLifetime is the assumed validity of a bearer token that does not carry its own expiry.
+// Icedrive does not report token lifetimes, so this is a conservative estimate.
+const DefaultTokenLifetime = 24 * time.Hour
+
+// tokenRefreshCheckInterval is how often the background refresher looks at the token
+const tokenRefreshCheckInterval = 30 * time.Second
+
+// setTokenLocked stores a new bearer token and resets its lifetime tracking. Caller must hold p.mu.
+func (p *HTTPClientPool) setTokenLocked(token string) {
+	if token != p.bearer {
+		p.tokenIssuedAt = time.Now()
+		p.tokenExpiresAt, _ = jwtExpiry(token)
+	}
+	p.bearer = token
+}
+
+// SetTokenLifetime overrides the assumed lifetime of tokens without an embedded expiry
+func (p *HTTPClientPool) SetTokenLifetime(d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.tokenLifetime = d
+}
+
+// TokenAge returns how long ago the current bearer token was obtained
+func (p *HTTPClientPool) TokenAge() time.Duration {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.bearer == "" {
+		return 0
+	}
+	return time.Since(p.tokenIssuedAt)
+}
+
+// TokenExpiry returns when the current bearer token is expected to expire.
+// The zero time is returned if there is no token.
+func (p *HTTPClientPool) TokenExpiry() time.Time {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.bearer == "" {
+		return time.Time{}
+	}
+	if !p.tokenExpiresAt.IsZero() {
+		return p.tokenExpiresAt
+	}
+	lifetime := p.tokenLifetime
+	if lifetime <= 0 {
+		lifetime = DefaultTokenLifetime
+	}
+	return p.tokenIssuedAt.Add(lifetime)
+}
+
+// TokenExpiresWithin reports whether the current bearer token expires within d
+func (p *HTTPClientPool) TokenExpiresWithin(d time.Duration) bool {
+	exp := p.TokenExpiry()
+	if exp.IsZero() {
+		return false
+	}
+	return time.Until(exp) < d
+}
+
+// RefreshToken logs in again to replace the current token, sharing the
+// single-flight and backoff behaviour of Relogin
+func (p *HTTPClientPool) RefreshToken() error {
+	_, err := p.Relogin(p.GetBearerToken())
+	return err
+}
+
+// StartTokenRefresh starts a background goroutine that refreshes the token
+// once it is within margin of its expiry. Any previous refresher is stopped.
+func (p *HTTPClientPool) StartTokenRefresh(margin time.Duration) {
+	p.StopTokenRefresh()
+
+	stop := make(chan struct{})
+	p.mu.Lock()
+	p.refreshStop = stop
+	p.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(tokenRefreshCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if p.GetBearerToken() != "" && p.TokenExpiresWithin(margin) {
+					// Failures back off inside Relogin, the next tick tries again
+					_ = p.RefreshToken()
+				}
+			}
+		}
+	}()
+}
+
+// StopTokenRefresh stops the background token refresher, if running
+func (p *HTTPClientPool) StopTokenRefresh() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.refreshStop != nil {
+		close(p.refreshStop)
+		p.refreshStop = nil
+	}
+}
+
+// jwtExpiry extracts the exp claim if the token is a JWT
+func jwtExpiry(token string) (time.Time, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}, false
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(claims.Exp, 0), true
+}
@@ -58,7 +58,7 @@ func TrashEraseAll(h *HTTPClient) error {
 	if h == nil {
 		h = NewHTTPClientWithEnv()
 	}
-	if strings.TrimSpace(h.bearer) == "" {
+	if strings.TrimSpace(h.GetBearerToken()) == "" {
 		return fmt.Errorf("missing bearer token; call Login first")
 	}
 	status, _, body, err := h.httpGET("/trash-erase-all")
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/twofish"
+)
+
+// UploadConflictPolicy decides what an upload does when the target folder
+// already holds a file with the same name
+type UploadConflictPolicy int
+
+const (
+	// UploadOverwrite lets the server replace the file, keeping the old content as a version
+	UploadOverwrite UploadConflictPolicy = iota
+	// UploadRename uploads under the first free name of the form "name (1).ext"
+	UploadRename
+	// UploadSkipIdentical skips the upload if the existing file has the same
+	// size and modification time, and overwrites it otherwise
+	UploadSkipIdentical
+	// UploadFail refuses the upload with a *FileExistsError
+	UploadFail
+)
+
+var uploadConflictPolicyNames = map[UploadConflictPolicy]string{
+	UploadOverwrite:     "overwrite",
+	UploadRename:        "rename",
+	UploadSkipIdentical: "skip-identical",
+	UploadFail:          "fail",
+}
+
+func (p UploadConflictPolicy) String() string {
+	if name, ok := uploadConflictPolicyNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("UploadConflictPolicy(%d)", int(p))
+}
+
+// ParseUploadConflictPolicy returns the policy with the given name, e.g. "skip-identical"
+func ParseUploadConflictPolicy(name string) (UploadConflictPolicy, error) {
+	for p, n := range uploadConflictPolicyNames {
+		if n == name {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown upload conflict policy %q", name)
+}
+
+var (
+	// ErrUploadSkipped is returned by uploads skipped under UploadSkipIdentical
+	ErrUploadSkipped = errors.New("upload skipped: identical file exists")
+	// ErrFileExists matches every *FileExistsError with errors.Is
+	ErrFileExists = errors.New("file already exists")
+)
+
+// FileExistsError is returned by uploads refused under UploadFail
+type FileExistsError struct {
+	FolderID uint64
+	Name     string
+	Existing Item
+}
+
+func (e *FileExistsError) Error() string {
+	return fmt.Sprintf("%s already exists in folder %d", e.Name, e.FolderID)
+}
+
+func (e *FileExistsError) Is(target error) bool {
+	return target == ErrFileExists
+}
+
+// ResolveUploadConflict applies opts.Conflict to an upload of filename into
+// folderID and returns the name to upload under. It returns ErrUploadSkipped
+// or a *FileExistsError if the upload must not happen. Names in the encrypted
+// collection are compared using the client's crypto key.
+func ResolveUploadConflict(h *HTTPClient, folderID uint64, filename string, opts UploadOptions) (string, error) {
+	name := filepath.Base(filename)
+	if opts.Conflict == UploadOverwrite {
+		return name, nil
+	}
+	if h == nil {
+		h = NewHTTPClientWithEnv()
+	}
+	cType := CollectionCloud
+	if opts.Crypto {
+		cType = CollectionCrypto
+	}
+	listing, err := GetCollection(h, folderID, cType)
+	if err != nil {
+		return "", fmt.Errorf("check for existing %s: %w", name, err)
+	}
+	existing, found := findFile(listing.Data, name)
+	if !found {
+		return name, nil
+	}
+
+	switch opts.Conflict {
+	case UploadRename:
+		ext := filepath.Ext(name)
+		base := strings.TrimSuffix(name, ext)
+		for i := 1; ; i++ {
+			candidate := fmt.Sprintf("%s (%d)%s", base, i, ext)
+			if _, taken := findFile(listing.Data, candidate); !taken {
+				return candidate, nil
+			}
+		}
+	case UploadSkipIdentical:
+		if opts.Size >= 0 && ItemMatches(existing, opts.Size, opts.Moddate) {
+			return "", ErrUploadSkipped
+		}
+		return name, nil
+	case UploadFail:
+		return "", &FileExistsError{FolderID: folderID, Name: name, Existing: existing}
+	}
+	return name, nil
+}
+
+// findFile looks up an item by name, ignoring case like the Icedrive web app does
+func findFile(items []Item, name string) (Item, bool) {
+	for _, item := range items {
+		if strings.EqualFold(item.Filename, name) {
+			return item, true
+		}
+	}
+	return Item{}, false
+}
+
+// ItemMatches reports whether a file of the given size and modification time
+// matches item, at one-second precision. The listed size of encrypted items
+// may include the crypto header and padding, so both sizes are accepted.
+func ItemMatches(item Item, size int64, modTime time.Time) bool {
+	if modTime.Unix() != int64(item.Moddate) {
+		return false
+	}
+	if item.Crypto == 1 && item.Filesize == CryptoFileSize(uint64(size)) {
+		return true
+	}
+	return item.Filesize == uint64(size)
+}
+
+// CryptoFileSize returns the stored size of an encrypted file with plainSize bytes of content
+func CryptoFileSize(plainSize uint64) uint64 {
+	return cryptoHeaderSize + (plainSize+twofish.BlockSize-1)/twofish.BlockSize*twofish.BlockSize
+}
@@ -56,6 +56,7 @@ type UploadResponse struct {
 type uploadWriteCloser struct {
 	w    *io.PipeWriter
 	wait func() error
+	resp *UploadResponse
 }
 
 func (u *uploadWriteCloser) Write(p []byte) (int, error) {
@@ -70,6 +71,31 @@ func (u *uploadWriteCloser) Close() error {
 	return nil
 }
 
+// encryptInto streams src through a pipelined encrypt writer into dst; size < 0 means unknown
+func encryptInto(dst io.Writer, src io.Reader, hexkey string, size int64) error {
+	ew, err := NewEncryptWriter(dst, hexkey, size, 0)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(ew, src)
+	if cerr := ew.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
 func GetUploadEndpoints(h *HTTPClient) ([]string, error) {
 	if h == nil {
 		h = NewHTTPClientWithEnv()
@@ -284,7 +310,7 @@ func UploadEncryptedFile(h *HTTPClient, folderID uint64, filename string, hexkey
 	w := multipart.NewWriter(pw)
 	_ = w.SetBoundary("----geckoformboundary" + randHex(16))
 	go func() {
-		encryptedFilename, err := EncryptFilename(hexkey, filename)
+		encryptedFilename, err := EncryptFilename(hexkey, filepath.Base(filename))
 
 		_ = w.WriteField("folderId", strconv.FormatUint(folderID, 10))
 		_ = w.WriteField("moddate", strconv.FormatFloat(moddate, 'f', -1, 64))
@@ -295,7 +321,7 @@ func UploadEncryptedFile(h *HTTPClient, folderID uint64, filename string, hexkey
 		hdr.Set("Content-Type", ct)
 		part, err := w.CreatePart(hdr)
 		if err == nil {
-			err = EncryptTwofishCBCStream(part, f, hexkey, uint64(fi.Size()))
+			err = encryptInto(part, f, hexkey, fi.Size())
 		}
 		_ = w.Close()
 		_ = pw.CloseWithError(err)
@@ -317,76 +343,65 @@ func UploadEncryptedFile(h *HTTPClient, folderID uint64, filename string, hexkey
 	return &out, nil
 }
 
-func NewUploadFileWriter(h *HTTPClient, folderID uint64, filename string) (io.WriteCloser, error) {
-	if h == nil {
-		h = NewHTTPClientWithEnv()
-	}
-	endpoints, err := GetUploadEndpoints(h)
-	if err != nil || len(endpoints) == 0 {
-		return nil, fmt.Errorf("no upload endpoints: %w", err)
-	}
-	endpoint := endpoints[0]
-
-	moddate := float64(time.Now().UnixNano()) / 1e9
-	ct := mime.TypeByExtension(strings.ToLower(filepath.Ext(filename)))
-	if ct == "" {
-		ct = "application/octet-stream"
-	}
-
-	pr, pw := io.Pipe()
-	mp := multipart.NewWriter(pw)
-	_ = mp.SetBoundary("----geckoformboundary" + randHex(16))
-
-	partR, partW := io.Pipe()
-	errCh := make(chan error, 1)
+// UploadOptions controls how NewUploadWriter uploads a file
+type UploadOptions struct {
+	// Moddate is the modification time recorded for the file; zero means now
+	Moddate time.Time
+	// Crypto uploads into the encrypted collection
+	Crypto bool
+	// HexKey is the crypto key; defaults to the client's key
+	HexKey string
+	// Size is the plaintext size if known, -1 otherwise. Crypto uploads of known
+	// size are encrypted on the fly; otherwise the encrypted file is spooled to a
+	// temporary file before it is sent, see NewEncryptWriter.
+	Size int64
+	// Conflict decides what happens if folderID already holds a file of that name
+	Conflict UploadConflictPolicy
+}
 
-	go func() {
-		_ = mp.WriteField("folderId", strconv.FormatUint(folderID, 10))
-		_ = mp.WriteField("moddate", strconv.FormatFloat(moddate, 'f', -1, 64))
-		hdr := make(textproto.MIMEHeader)
-		hdr.Set("Content-Disposition", `form-data; name="files[]"; filename="`+filepath.Base(filename)+`"`)
-		hdr.Set("Content-Type", ct)
-		part, err := mp.CreatePart(hdr)
-		if err == nil {
-			_, err = io.Copy(part, partR)
-		}
-		_ = mp.Close()
-		_ = pw.CloseWithError(err)
-	}()
+// UploadWriter streams a file upload. Close waits for the server's answer,
+// which is then available from Response.
+type UploadWriter interface {
+	io.WriteCloser
+	Response() *UploadResponse
+}
 
-	go func() {
-		status, _, body, err := h.httpPOSTReader(endpoint, mp.FormDataContentType(), pr)
-		if err == nil && status >= 400 {
-			err = fmt.Errorf("upload failed with status %d", status)
-		}
-		if err == nil {
-			var out UploadResponse
-			if e := json.Unmarshal(body, &out); e == nil && out.Error {
-				err = fmt.Errorf("upload error: %s", out.Message)
-			}
-		}
-		errCh <- err
-	}()
+func (u *uploadWriteCloser) Response() *UploadResponse {
+	return u.resp
+}
 
-	return &uploadWriteCloser{
-		w: partW,
-		wait: func() error {
-			return <-errCh
-		},
-	}, nil
+func NewUploadFileWriter(h *HTTPClient, folderID uint64, filename string) (io.WriteCloser, error) {
+	return NewUploadWriter(h, folderID, filename, UploadOptions{Size: -1})
 }
 
 func NewUploadFileEncryptedWriter(h *HTTPClient, folderID uint64, filename string, hexkey string) (io.WriteCloser, error) {
+	return NewUploadWriter(h, folderID, filename, UploadOptions{Crypto: true, HexKey: hexkey, Size: -1})
+}
+
+// NewUploadWriter returns a writer that uploads everything written to it as filename into folderID
+func NewUploadWriter(h *HTTPClient, folderID uint64, filename string, opts UploadOptions) (UploadWriter, error) {
 	if h == nil {
 		h = NewHTTPClientWithEnv()
 	}
+	hexkey := opts.HexKey
+	if opts.Crypto && hexkey == "" {
+		hexkey = h.GetCryptoKeyHex()
+	}
+	filename, err := ResolveUploadConflict(h, folderID, filename, opts)
+	if err != nil {
+		return nil, err
+	}
 	endpoints, err := GetUploadEndpoints(h)
 	if err != nil || len(endpoints) == 0 {
 		return nil, fmt.Errorf("no upload endpoints: %w", err)
 	}
 	endpoint := endpoints[0]
 
-	moddate := float64(time.Now().UnixNano()) / 1e9
+	mod := opts.Moddate
+	if mod.IsZero() {
+		mod = time.Now()
+	}
+	moddate := float64(mod.UnixNano()) / 1e9
 	ct := mime.TypeByExtension(strings.ToLower(filepath.Ext(filename)))
 	if ct == "" {
 		ct = "application/octet-stream"
@@ -398,22 +413,30 @@ func NewUploadFileEncryptedWriter(h *HTTPClient, folderID uint64, filename strin
 
 	partR, partW := io.Pipe()
 	errCh := make(chan error, 1)
+	u := &uploadWriteCloser{w: partW}
 
 	go func() {
-		encryptedFilename, _ := EncryptFilename(hexkey, filepath.Base(filename))
 		_ = mp.WriteField("folderId", strconv.FormatUint(folderID, 10))
 		_ = mp.WriteField("moddate", strconv.FormatFloat(moddate, 'f', -1, 64))
-		_ = mp.WriteField("custom_filename", encryptedFilename)
-		_ = mp.WriteField("crypto", "1")
+		if opts.Crypto {
+			encryptedFilename, _ := EncryptFilename(hexkey, filepath.Base(filename))
+			_ = mp.WriteField("custom_filename", encryptedFilename)
+			_ = mp.WriteField("crypto", "1")
+		}
 		hdr := make(textproto.MIMEHeader)
 		hdr.Set("Content-Disposition", `form-data; name="files[]"; filename="`+filepath.Base(filename)+`"`)
 		hdr.Set("Content-Type", ct)
 		part, err := mp.CreatePart(hdr)
 		if err == nil {
-			err = EncryptTwofishCBCStreamUnknownSize(part, partR, hexkey)
+			if opts.Crypto {
+				err = encryptInto(part, partR, hexkey, opts.Size)
+			} else {
+				_, err = io.Copy(part, partR)
+			}
 		}
 		_ = mp.Close()
 		_ = pw.CloseWithError(err)
+		_ = partR.CloseWithError(err)
 	}()
 
 	go func() {
@@ -423,17 +446,20 @@ func NewUploadFileEncryptedWriter(h *HTTPClient, folderID uint64, filename strin
 		}
 		if err == nil {
 			var out UploadResponse
-			if e := json.Unmarshal(body, &out); e == nil && out.Error {
-				err = fmt.Errorf("upload error: %s", out.Message)
+			if e := json.Unmarshal(body, &out); e == nil {
+				if out.Error {
+					err = fmt.Errorf("upload error: %s", out.Message)
+				} else {
+					u.resp = &out
+				}
 			}
 		}
+		_ = pr.CloseWithError(err)
 		errCh <- err
 	}()
 
-	return &uploadWriteCloser{
-		w: partW,
-		wait: func() error {
-			return <-errCh
-		},
-	}, nil
+	u.wait = func() error {
+		return <-errCh
+	}
+	return u, nil
 }
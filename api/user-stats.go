@@ -28,7 +28,7 @@ func GetUserStats(h *HTTPClient) (*UserStats, error) {
 	if h == nil {
 		h = NewHTTPClientWithEnv()
 	}
-	if strings.TrimSpace(h.bearer) == "" {
+	if strings.TrimSpace(h.GetBearerToken()) == "" {
 		return nil, fmt.Errorf("missing bearer token; call Login first")
 	}
 	status, _, body, err := h.httpGET("/user-stats")
@@ -0,0 +1,320 @@
+package client
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/StarHack/go-icedrive/api"
+)
+
+// ArchiveFormat is the container format written by WriteArchive
+type ArchiveFormat int
+
+const (
+	ArchiveZip ArchiveFormat = iota
+	ArchiveTar
+	ArchiveTarGz
+)
+
+// Extension returns the usual file name extension of the format, e.g. ".tar.gz"
+func (f ArchiveFormat) Extension() string {
+	switch f {
+	case ArchiveTar:
+		return ".tar"
+	case ArchiveTarGz:
+		return ".tar.gz"
+	}
+	return ".zip"
+}
+
+// ContentType returns the MIME type of the format, for serving archives over HTTP
+func (f ArchiveFormat) ContentType() string {
+	switch f {
+	case ArchiveTar:
+		return "application/x-tar"
+	case ArchiveTarGz:
+		return "application/gzip"
+	}
+	return "application/zip"
+}
+
+// ArchiveOptions controls WriteArchive
+type ArchiveOptions struct {
+	Format ArchiveFormat
+	// Crypto archives a folder of the encrypted collection, decrypting its files
+	Crypto bool
+	// Prefix is prepended to every path in the archive, e.g. the folder's name
+	Prefix string
+	// Concurrency is the number of file streams opened ahead of the one being
+	// written, at most and by default the pool size
+	Concurrency int
+	// Progress, if set, is called after each file is written
+	Progress func(archivePath string, size int64)
+}
+
+// archiveEntry is a file or folder to be written to an archive
+type archiveEntry struct {
+	path  string
+	item  api.Item
+	ready chan archiveStream // files only
+}
+
+// archiveStream is the opened content of a file and the name it is stored
+// under. Tar needs the exact size up front; it is -1 if only known after reading.
+type archiveStream struct {
+	rc   io.ReadCloser
+	name string
+	size int64
+	err  error
+}
+
+// ErrCompressedInTar is returned by WriteArchive for tar archives of folders
+// holding compressed uploads, see WriteArchive
+var ErrCompressedInTar = errors.New("tar archives cannot hold compressed uploads, their decompressed size is unknown; use zip")
+
+// WriteArchive streams the contents of folderID into w as a zip or tar
+// archive. Paths are relative to folderID and keep the folder structure;
+// modification times come from the items. Encrypted files are decrypted and
+// compressed ones decompressed. The next files' downloads are opened while the
+// current one is written, so the archive is produced at close to the speed of
+// the connection, without temporary files.
+//
+// The folder is listed before anything is written, and nothing is written if
+// a name listed by the server is not a plain file name (see cleanEntryPath),
+// as its entry could escape the target folder of an extractor. Tar headers
+// need the size of each file, which the server does not know for the
+// decompressed content of compressed uploads, so tar archives of folders
+// holding any fail with ErrCompressedInTar.
+func (c *Client) WriteArchive(w io.Writer, folderID uint64, opts ArchiveOptions) error {
+	if err := c.defaultAuthChecks(opts.Crypto); err != nil {
+		return err
+	}
+	if opts.Prefix != "" {
+		prefix, ok := cleanEntryPath(opts.Prefix)
+		if !ok {
+			return fmt.Errorf("unsafe archive prefix %q", opts.Prefix)
+		}
+		opts.Prefix = prefix
+	}
+	if opts.Concurrency <= 0 || opts.Concurrency > c.PoolSize() {
+		// Open streams hold a pooled connection each until they are written
+		opts.Concurrency = c.PoolSize()
+	}
+
+	aw, err := newArchiveWriter(w, opts.Format)
+	if err != nil {
+		return err
+	}
+
+	var entries []*archiveEntry
+	err = c.Walk(folderID, opts.Crypto, func(itemPath string, item api.Item) error {
+		if !archiveNameSafe(item.Filename) || !archiveNameSafe(DecompressedName(item)) {
+			return fmt.Errorf("unsafe name %q in %q", item.Filename, "/"+path.Dir(itemPath))
+		}
+		if IsCompressed(item) && opts.Format != ArchiveZip {
+			return fmt.Errorf("/%s: %w", itemPath, ErrCompressedInTar)
+		}
+		e := &archiveEntry{item: item, path: path.Join(opts.Prefix, itemPath)}
+		if item.IsFolder != 1 {
+			e.ready = make(chan archiveStream, 1)
+		}
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Open file streams ahead of the writer, at most Concurrency at a time
+	done := make(chan struct{})
+	slots := make(chan struct{}, opts.Concurrency)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for _, e := range entries {
+			if e.ready == nil {
+				continue
+			}
+			select {
+			case slots <- struct{}{}:
+			case <-done:
+				return
+			}
+			wg.Add(1)
+			go func(e *archiveEntry) {
+				defer wg.Done()
+				e.ready <- c.openArchiveStream(e.item, opts.Format != ArchiveZip)
+			}(e)
+		}
+	}()
+
+	err = c.writeArchiveEntries(aw, entries, slots, opts.Progress)
+	close(done)
+	wg.Wait()
+	if err != nil {
+		// Close the streams opened for files that were not written
+		for _, e := range entries {
+			if e.ready == nil {
+				continue
+			}
+			select {
+			case s := <-e.ready:
+				if s.rc != nil {
+					s.rc.Close()
+				}
+			default:
+			}
+		}
+		return err
+	}
+	return aw.Close()
+}
+
+func (c *Client) writeArchiveEntries(aw archiveWriter, entries []*archiveEntry, slots chan struct{}, progress func(string, int64)) error {
+	for _, e := range entries {
+		mtime := time.Unix(int64(e.item.Moddate), 0)
+		if e.ready == nil {
+			if err := aw.Dir(e.path, mtime); err != nil {
+				return err
+			}
+			continue
+		}
+		s := <-e.ready
+		if s.err != nil {
+			<-slots
+			return fmt.Errorf("%s: %w", e.path, s.err)
+		}
+		name := path.Join(path.Dir(e.path), s.name)
+		n, err := aw.File(name, mtime, s.size, s.rc)
+		s.rc.Close()
+		<-slots
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if progress != nil {
+			progress(name, n)
+		}
+	}
+	return nil
+}
+
+// openArchiveStream opens the decrypted and decompressed content of item. With
+// needSize encrypted files ask the server for their plain size; compressed
+// files are only archived as zip, where the size is not needed.
+func (c *Client) openArchiveStream(item api.Item, needSize bool) archiveStream {
+	size := int64(item.Filesize)
+	if needSize && item.Crypto == 1 {
+		plain, err := c.GetPlainSize(item)
+		if err != nil {
+			return archiveStream{err: err}
+		}
+		size = plain
+	}
+	var rc io.ReadCloser
+	var err error
+	if item.Crypto == 1 {
+		rc, err = c.DownloadFileEncryptedStream(item)
+	} else {
+		rc, err = c.DownloadFileStream(item)
+	}
+	if err != nil {
+		return archiveStream{err: err}
+	}
+	if IsCompressed(item) {
+		return archiveStream{rc: rc, name: localName(item, rc), size: -1}
+	}
+	return archiveStream{rc: rc, name: item.Filename, size: size}
+}
+
+// archiveNameSafe reports whether name, as listed by the server, is a single
+// path element that cleanEntryPath accepts unchanged. Backslashes are refused
+// too, since extractors on Windows treat them as separators.
+func archiveNameSafe(name string) bool {
+	p, ok := cleanEntryPath(name)
+	return ok && p == name && !strings.ContainsAny(name, `/\`)
+}
+
+// archiveWriter hides the differences between the zip and tar writers
+type archiveWriter interface {
+	Dir(name string, mtime time.Time) error
+	File(name string, mtime time.Time, size int64, r io.Reader) (int64, error)
+	Close() error
+}
+
+func newArchiveWriter(w io.Writer, format ArchiveFormat) (archiveWriter, error) {
+	switch format {
+	case ArchiveZip:
+		return &zipArchive{zw: zip.NewWriter(w)}, nil
+	case ArchiveTar:
+		return &tarArchive{tw: tar.NewWriter(w)}, nil
+	case ArchiveTarGz:
+		gz := gzip.NewWriter(w)
+		return &tarArchive{tw: tar.NewWriter(gz), gz: gz}, nil
+	}
+	return nil, fmt.Errorf("unknown archive format %d", format)
+}
+
+type zipArchive struct {
+	zw *zip.Writer
+}
+
+func (a *zipArchive) Dir(name string, mtime time.Time) error {
+	_, err := a.zw.CreateHeader(&zip.FileHeader{Name: name + "/", Modified: mtime})
+	return err
+}
+
+func (a *zipArchive) File(name string, mtime time.Time, size int64, r io.Reader) (int64, error) {
+	method := zip.Deflate
+	if incompressibleExtensions[strings.ToLower(path.Ext(name))] {
+		method = zip.Store
+	}
+	fw, err := a.zw.CreateHeader(&zip.FileHeader{Name: name, Modified: mtime, Method: method})
+	if err != nil {
+		return 0, err
+	}
+	return io.Copy(fw, r)
+}
+
+func (a *zipArchive) Close() error {
+	return a.zw.Close()
+}
+
+type tarArchive struct {
+	tw *tar.Writer
+	gz *gzip.Writer
+}
+
+func (a *tarArchive) Dir(name string, mtime time.Time) error {
+	return a.tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: name + "/", Mode: 0o755, ModTime: mtime})
+}
+
+func (a *tarArchive) File(name string, mtime time.Time, size int64, r io.Reader) (int64, error) {
+	hdr := &tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0o644, Size: size, ModTime: mtime}
+	if err := a.tw.WriteHeader(hdr); err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(a.tw, r)
+	if err == nil && n != size {
+		err = fmt.Errorf("expected %d bytes, got %d", size, n)
+	}
+	return n, err
+}
+
+func (a *tarArchive) Close() error {
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	if a.gz != nil {
+		return a.gz.Close()
+	}
+	return nil
+}
@@ -1,13 +1,28 @@
 package client
 
 import (
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/StarHack/go-icedrive/api"
 )
 
+const (
+	// defaultTokenRefreshMargin is how long before expiry the token is refreshed in the background
+	defaultTokenRefreshMargin = time.Hour
+	// defaultUploadTokenMargin is the minimum remaining token lifetime required to start an upload
+	defaultUploadTokenMargin = 30 * time.Minute
+)
+
+// ErrTokenExpiring is returned when an upload would start with a token about to expire
+var ErrTokenExpiring = errors.New("bearer token expires too soon to start upload")
+
 // pooledWriter wraps an io.WriteCloser and releases the HTTPClient back to the pool on Close
 type pooledWriter struct {
 	writer io.WriteCloser
@@ -43,17 +58,39 @@ func (pr *pooledReader) Close() error {
 }
 
 type Client struct {
-	pool           *api.HTTPClientPool
-	hmacKeyHex     string
-	user           *api.User
-	cryptoPassword string
-	CryptoSalt     string
-	CryptoHexKey   string
-
-	// Store credentials for automatic re-login
-	email    string
-	password string
-}
+	pool             *api.HTTPClientPool
+	hmacKeyHex       string
+	user             *api.User
+	cryptoStoredHash string
+	cryptoVerified   bool
+	CryptoSalt       string
+	CryptoHexKey     string
+
+	// Accept crypto keys that do not match the stored hash, see SkipCryptoHashCheck
+	skipCryptoHashCheck bool
+
+	// Credential source for automatic re-login
+	credentials CredentialProvider
+
+	uploadTokenMargin time.Duration
+
+	// Opt-in gzip compression of uploads, see EnableCompression
+	compression      bool
+	compressionLevel int
+
+	// Default conflict policy of uploads, see SetUploadConflictPolicy
+	uploadConflict api.UploadConflictPolicy
+	// Checks after each upload, see SetUploadVerification
+	verifyUploads VerifyMode
+	// Hashes of remote files, see SetHashCache
+	hashCache *HashCache
+}
+
+// Endpoint and headers of the Icedrive mobile API used by all clients
+const (
+	defaultAPIBase = "https://apis.icedrive.net/v3/mobile"
+	defaultHeaders = "User-Agent: icedrive-ios/2.3.1"
+)
 
 func NewClient() *Client {
 	defaultConcurrentConnections := 3
@@ -64,12 +101,13 @@ func NewClient() *Client {
 func NewClientWithPoolSize(poolSize int, requestsPerMinute float64) *Client {
 	pool := api.NewHTTPClientPool(poolSize, requestsPerMinute)
 	client := &Client{
-		pool:       pool,
-		hmacKeyHex: "436f6e67726174756c6174696f6e7320494620796f7520676f742054484953206661722121203b2921203a29",
+		pool:              pool,
+		hmacKeyHex:        "436f6e67726174756c6174696f6e7320494620796f7520676f742054484953206661722121203b2921203a29",
+		uploadTokenMargin: defaultUploadTokenMargin,
 	}
 	client.SetDebug(false)
-	pool.SetApiBase("https://apis.icedrive.net/v3/mobile")
-	pool.SetHeaders("User-Agent: icedrive-ios/2.3.1")
+	pool.SetApiBase(defaultAPIBase)
+	pool.SetHeaders(defaultHeaders)
 	return client
 }
 
@@ -81,7 +119,7 @@ func (c *Client) defaultAuthChecks(crypto bool) error {
 		return nil
 	}
 
-	if c.email != "" && c.password != "" {
+	if c.credentials != nil {
 		if crypto && c.CryptoHexKey == "" {
 			return errors.New("set crypto password first")
 		}
@@ -95,34 +133,111 @@ func (c *Client) SetDebug(debug bool) {
 	c.pool.SetDebug(debug)
 }
 
-func (c *Client) SetCryptoPassword(cryptoPassword string) {
-	c.cryptoPassword = cryptoPassword
-	if c.CryptoSalt == "" {
+// PoolSize returns the number of concurrent connections of the client
+func (c *Client) PoolSize() int {
+	return c.pool.Size()
+}
+
+// SetCryptoPassword derives the crypto key from the password and the account's
+// salt and checks it against the hash stored on the server, see SetCryptoPasswordWithSalt
+func (c *Client) SetCryptoPassword(cryptoPassword string) error {
+	if c.CryptoSalt == "" || c.cryptoStoredHash == "" {
 		// Acquire a client to fetch crypto salt
 		err := c.pool.WithClient(func(h *api.HTTPClient) error {
-			_, salt, err := api.FetchCryptoSaltAndStoredHash(h)
+			storedHex, salt, err := api.FetchCryptoSaltAndStoredHash(h)
+			if err != nil {
+				return err
+			}
 			c.CryptoSalt = salt
-			return err
+			c.cryptoStoredHash = storedHex
+			return nil
 		})
 		if err != nil {
-			// Handle error silently for backward compatibility
-			_ = err
+			return fmt.Errorf("failed to fetch crypto salt: %w", err)
+		}
+	}
+	return c.SetCryptoPasswordWithSalt(cryptoPassword, c.CryptoSalt, c.cryptoStoredHash)
+}
+
+// SetCryptoPasswordWithSalt derives the crypto key without contacting the server.
+// If storedHex is not empty the derived key is checked against it and a
+// mismatch returns api.ErrWrongCryptoPassword without changing the key, unless
+// the check was turned off with SkipCryptoHashCheck.
+func (c *Client) SetCryptoPasswordWithSalt(cryptoPassword, salt, storedHex string) error {
+	if salt == "" {
+		return errors.New("missing crypto salt")
+	}
+	keyHex, err := api.DeriveCryptoKey(cryptoPassword, salt)
+	if err != nil {
+		return err
+	}
+	verified := false
+	if storedHex != "" {
+		err := api.VerifyCryptoKey(keyHex, storedHex)
+		switch {
+		case err == nil:
+			verified = true
+		case errors.Is(err, api.ErrWrongCryptoPassword) && c.skipCryptoHashCheck:
+		default:
+			return err
 		}
 	}
-	c.CryptoHexKey, _ = api.DeriveCryptoKey(cryptoPassword, c.CryptoSalt)
+	c.cryptoVerified = verified
+	c.CryptoSalt = salt
+	c.cryptoStoredHash = storedHex
+	c.CryptoHexKey = keyHex
+	c.pool.SetCryptoKeyHex(keyHex)
+	return nil
+}
+
+// SkipCryptoHashCheck makes SetCryptoPassword accept passwords that do not
+// match the stored hash. The hash scheme is inferred (see api.VerifyCryptoKey),
+// so this is the way out for an account whose hash uses another one; such
+// keys are reported as unverified by CryptoKeyVerified.
+func (c *Client) SkipCryptoHashCheck(skip bool) {
+	c.skipCryptoHashCheck = skip
+}
+
+// CryptoKeyVerified reports whether the current crypto key matched the hash stored on the server
+func (c *Client) CryptoKeyVerified() bool {
+	return c.cryptoVerified
+}
+
+// SetCryptoKeyHex uses an already derived 64 hex char crypto key, e.g. ICEDRIVE_CRYPTO_KEY
+func (c *Client) SetCryptoKeyHex(keyHex string) error {
+	key, err := hex.DecodeString(strings.TrimSpace(keyHex))
+	if err != nil {
+		return fmt.Errorf("invalid crypto key: %w", err)
+	}
+	if len(key) != 32 {
+		return errors.New("crypto key must decode to 32 bytes (64 hex chars)")
+	}
+	c.CryptoHexKey = hex.EncodeToString(key)
+	c.cryptoVerified = false
 	c.pool.SetCryptoKeyHex(c.CryptoHexKey)
+	return nil
 }
 
 func (c *Client) LoginWithUsernameAndPassword(email, password string) error {
+	return c.LoginWithCredentialProvider(NewStaticCredentials(email, password))
+}
+
+// LoginWithCredentialProvider logs in with credentials fetched from provider.
+// The provider is kept and asked again whenever a re-login is needed.
+func (c *Client) LoginWithCredentialProvider(provider CredentialProvider) error {
 	debugFlag := c.pool.GetDebug()
 	if debugFlag {
 		fmt.Printf(">>> 🔑 Logging in with username and password...\n")
 	}
 
+	creds, err := provider.Credentials()
+	if err != nil {
+		return err
+	}
 	var user *api.User
-	err := c.pool.WithClient(func(h *api.HTTPClient) error {
+	err = c.pool.WithClient(func(h *api.HTTPClient) error {
 		var loginErr error
-		user, loginErr = api.LoginWithUsernameAndPassword(h, email, password, c.hmacKeyHex)
+		user, loginErr = api.LoginWithUsernameAndPassword(h, creds.Email, creds.Password, c.hmacKeyHex)
 		return loginErr
 	})
 	if err != nil {
@@ -130,10 +245,10 @@ func (c *Client) LoginWithUsernameAndPassword(email, password string) error {
 	}
 	c.user = user
 
-	c.email = email
-	c.password = password
+	c.credentials = provider
 
 	c.pool.SetReloginFunc(c.relogin)
+	c.pool.StartTokenRefresh(defaultTokenRefreshMargin)
 
 	if debugFlag {
 		fmt.Printf(">>> ✅ Login successful!\n")
@@ -141,6 +256,11 @@ func (c *Client) LoginWithUsernameAndPassword(email, password string) error {
 	return nil
 }
 
+// SetAPIBase points the client at another API endpoint, e.g. a proxy or a test server
+func (c *Client) SetAPIBase(apiBase string) {
+	c.pool.SetApiBase(apiBase)
+}
+
 func (c *Client) LoginWithBearerToken(token string) error {
 	if c.pool.GetDebug() {
 		fmt.Println(">>> 🔑 Logging in with bearer token...")
@@ -157,8 +277,9 @@ func (c *Client) LoginWithBearerToken(token string) error {
 	c.user = user
 
 	// If we have credentials, set up re-login
-	if c.email != "" && c.password != "" {
+	if c.credentials != nil {
 		c.pool.SetReloginFunc(c.relogin)
+		c.pool.StartTokenRefresh(defaultTokenRefreshMargin)
 	}
 
 	if c.pool.GetDebug() {
@@ -170,17 +291,77 @@ func (c *Client) LoginWithBearerToken(token string) error {
 // SetCredentials stores login credentials for automatic re-login
 // This should be called after LoginWithBearerToken if you want automatic re-login capability
 func (c *Client) SetCredentials(email, password string) {
-	c.email = email
-	c.password = password
+	c.SetCredentialProvider(NewStaticCredentials(email, password))
+}
+
+// SetCredentialProvider sets the credential source used for automatic re-login
+func (c *Client) SetCredentialProvider(provider CredentialProvider) {
+	c.credentials = provider
 	if c.user != nil {
 		c.pool.SetReloginFunc(c.relogin)
+		c.pool.StartTokenRefresh(defaultTokenRefreshMargin)
+	}
+}
+
+// Close stops background work such as the token refresher and drops the
+// secrets held by the client: it calls Wipe on the credential provider (if it
+// implements Wiper) and clears the bearer token and the crypto key. Go strings
+// cannot be overwritten, so copies of the token and key stay in memory until
+// they are garbage collected; only byte buffers such as the password of
+// StaticCredentials are zeroed. The client must be logged in again before further use.
+func (c *Client) Close() error {
+	c.pool.StopTokenRefresh()
+	c.pool.SetReloginFunc(nil)
+	if w, ok := c.credentials.(Wiper); ok {
+		w.Wipe()
+	}
+	c.credentials = nil
+	c.CryptoHexKey = ""
+	c.pool.SetCryptoKeyHex("")
+	c.pool.SetBearerToken("")
+	c.user = nil
+	return nil
+}
+
+// TokenExpiry returns when the current bearer token is expected to expire
+func (c *Client) TokenExpiry() time.Time {
+	return c.pool.TokenExpiry()
+}
+
+// SetTokenLifetime overrides the assumed lifetime of bearer tokens
+func (c *Client) SetTokenLifetime(d time.Duration) {
+	c.pool.SetTokenLifetime(d)
+}
+
+// SetUploadTokenMargin sets the minimum remaining token lifetime required to start an upload.
+// Uploads are streamed and cannot be replayed after a re-login, so they must not outlive the token.
+func (c *Client) SetUploadTokenMargin(d time.Duration) {
+	c.uploadTokenMargin = d
+}
+
+// ensureTokenFresh refreshes the token if it expires within margin and
+// fails with ErrTokenExpiring if that is not possible
+func (c *Client) ensureTokenFresh(margin time.Duration) error {
+	if !c.pool.TokenExpiresWithin(margin) {
+		return nil
 	}
+	if err := c.pool.RefreshToken(); err != nil {
+		return fmt.Errorf("%w: refresh failed: %v", ErrTokenExpiring, err)
+	}
+	if c.pool.TokenExpiresWithin(margin) {
+		return ErrTokenExpiring
+	}
+	return nil
 }
 
 func (c *Client) relogin() error {
-	if c.email == "" || c.password == "" {
+	if c.credentials == nil {
 		return fmt.Errorf("no credentials available for re-login")
 	}
+	creds, err := c.credentials.Credentials()
+	if err != nil {
+		return fmt.Errorf("no credentials available for re-login: %w", err)
+	}
 
 	if c.pool.GetDebug() {
 		fmt.Println(">>> 🔄 Starting re-login process...")
@@ -196,7 +377,7 @@ func (c *Client) relogin() error {
 
 	var newUser *api.User
 	var loginErr error
-	newUser, loginErr = api.LoginWithUsernameAndPassword(h, c.email, c.password, c.hmacKeyHex)
+	newUser, loginErr = api.LoginWithUsernameAndPassword(h, creds.Email, creds.Password, c.hmacKeyHex)
 	if loginErr != nil {
 		fmt.Printf(">>> ❌ Re-login failed: %v\n", loginErr)
 		return loginErr
@@ -310,30 +491,89 @@ func (c *Client) UploadFile(folderID uint64, fileName string) error {
 	if err := c.defaultAuthChecks(false); err != nil {
 		return err
 	}
-	return c.pool.WithClient(func(h *api.HTTPClient) error {
-		_, err := api.UploadFile(h, folderID, fileName)
+	if err := c.ensureTokenFresh(c.uploadTokenMargin); err != nil {
 		return err
-	})
+	}
+	_, err := c.uploadLocalFile(folderID, fileName, false, c.uploadConflict)
+	return err
 }
 
 func (c *Client) UploadFileEncrypted(folderID uint64, fileName string) error {
 	if err := c.defaultAuthChecks(true); err != nil {
 		return err
 	}
-	return c.pool.WithClient(func(h *api.HTTPClient) error {
-		_, err := api.UploadEncryptedFile(h, folderID, fileName, c.CryptoHexKey)
+	if err := c.ensureTokenFresh(c.uploadTokenMargin); err != nil {
+		return err
+	}
+	_, err := c.uploadLocalFile(folderID, fileName, true, c.uploadConflict)
+	return err
+}
+
+// SetUploadConflictPolicy sets what UploadFile, UploadFileEncrypted and the
+// upload writers do if the target folder already holds a file of the same
+// name. The default api.UploadOverwrite leaves it to the server, which keeps
+// the old content as a version. Other policies list the folder before each upload.
+// Skipped uploads return api.ErrUploadSkipped, refused ones an *api.FileExistsError.
+func (c *Client) SetUploadConflictPolicy(policy api.UploadConflictPolicy) {
+	c.uploadConflict = policy
+}
+
+// uploadLocalFile uploads a local file, compressed if enabled and worthwhile
+func (c *Client) uploadLocalFile(folderID uint64, localPath string, crypto bool, conflict api.UploadConflictPolicy) (*api.UploadResponse, error) {
+	if c.shouldCompress(localPath) {
+		if resp, err := c.uploadCompressed(folderID, localPath, crypto, conflict); resp != nil || err != nil {
+			return resp, err
+		}
+	}
+	if conflict != api.UploadOverwrite || c.verifyUploads != VerifyNone {
+		f, err := os.Open(localPath)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		opts := api.UploadOptions{Moddate: fi.ModTime(), Crypto: crypto, Size: fi.Size(), Conflict: conflict}
+		if crypto {
+			opts.HexKey = c.CryptoHexKey
+		}
+		return c.uploadVerified(folderID, filepath.Base(localPath), f, opts)
+	}
+	var resp *api.UploadResponse
+	err := c.pool.WithClient(func(h *api.HTTPClient) error {
+		var err error
+		if crypto {
+			resp, err = api.UploadEncryptedFile(h, folderID, localPath, c.CryptoHexKey)
+		} else {
+			resp, err = api.UploadFile(h, folderID, localPath)
+		}
 		return err
 	})
+	return resp, err
 }
 
 func (c *Client) UploadFileWriter(folderID uint64, fileName string) (io.WriteCloser, error) {
 	if err := c.defaultAuthChecks(false); err != nil {
 		return nil, err
 	}
+	if err := c.ensureTokenFresh(c.uploadTokenMargin); err != nil {
+		return nil, err
+	}
 	// Note: Writers require a dedicated client that won't be released until Close()
 	client := c.pool.Acquire()
 
-	writer, err := api.NewUploadFileWriter(client, folderID, fileName)
+	opts := api.UploadOptions{Size: -1, Conflict: c.uploadConflict}
+	if c.shouldCompress(fileName) {
+		writer, err := api.NewUploadWriter(client, folderID, fileName+CompressedSuffix, opts)
+		if err != nil {
+			c.pool.Release(client)
+			return nil, err
+		}
+		return c.newCompressWriter(&pooledWriter{writer: writer, pool: c.pool, client: client}), nil
+	}
+	writer, err := api.NewUploadWriter(client, folderID, fileName, opts)
 	if err != nil {
 		c.pool.Release(client)
 		return nil, err
@@ -342,14 +582,30 @@ func (c *Client) UploadFileWriter(folderID uint64, fileName string) (io.WriteClo
 	return &pooledWriter{writer: writer, pool: c.pool, client: client}, nil
 }
 
+// UploadFileEncryptedWriter returns a writer that encrypts and uploads
+// fileName into folderID. The size is unknown, so the encrypted file is spooled
+// to a temporary file and only sent on Close; use UploadReader with opts.Size
+// to stream content of known size.
 func (c *Client) UploadFileEncryptedWriter(folderID uint64, fileName string) (io.WriteCloser, error) {
 	if err := c.defaultAuthChecks(true); err != nil {
 		return nil, err
 	}
+	if err := c.ensureTokenFresh(c.uploadTokenMargin); err != nil {
+		return nil, err
+	}
 	// Note: Writers require a dedicated client that won't be released until Close()
 	client := c.pool.Acquire()
 
-	writer, err := api.NewUploadFileEncryptedWriter(client, folderID, fileName, c.CryptoHexKey)
+	opts := api.UploadOptions{Crypto: true, HexKey: c.CryptoHexKey, Size: -1, Conflict: c.uploadConflict}
+	if c.shouldCompress(fileName) {
+		writer, err := api.NewUploadWriter(client, folderID, fileName+CompressedSuffix, opts)
+		if err != nil {
+			c.pool.Release(client)
+			return nil, err
+		}
+		return c.newCompressWriter(&pooledWriter{writer: writer, pool: c.pool, client: client}), nil
+	}
+	writer, err := api.NewUploadWriter(client, folderID, fileName, opts)
 	if err != nil {
 		c.pool.Release(client)
 		return nil, err
@@ -358,10 +614,53 @@ func (c *Client) UploadFileEncryptedWriter(folderID uint64, fileName string) (io
 	return &pooledWriter{writer: writer, pool: c.pool, client: client}, nil
 }
 
+// UploadReader uploads size bytes read from r as name into folderID. The
+// crypto key is filled in for opts.Crypto if opts.HexKey is empty. Unlike
+// UploadFile, the content is uploaded as-is, without compression.
+func (c *Client) UploadReader(folderID uint64, name string, r io.Reader, opts api.UploadOptions) (*api.UploadResponse, error) {
+	if err := c.defaultAuthChecks(opts.Crypto); err != nil {
+		return nil, err
+	}
+	if err := c.ensureTokenFresh(c.uploadTokenMargin); err != nil {
+		return nil, err
+	}
+	if opts.Crypto && opts.HexKey == "" {
+		opts.HexKey = c.CryptoHexKey
+	}
+	return c.uploadVerified(folderID, name, r, opts)
+}
+
+// uploadFrom streams r through an upload writer on a pooled client
+func (c *Client) uploadFrom(folderID uint64, name string, r io.Reader, opts api.UploadOptions) (*api.UploadResponse, error) {
+	var resp *api.UploadResponse
+	err := c.pool.WithClient(func(h *api.HTTPClient) error {
+		w, err := api.NewUploadWriter(h, folderID, name, opts)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(w, r); err != nil {
+			_ = w.Close()
+			return err
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+		resp = w.Response()
+		return nil
+	})
+	if err == nil && resp == nil {
+		err = errors.New("upload response missing")
+	}
+	return resp, err
+}
+
 func (c *Client) DownloadFile(item api.Item, destPath string) error {
 	if err := c.defaultAuthChecks(false); err != nil {
 		return err
 	}
+	if IsCompressed(item) {
+		return c.downloadDecompressed(item, destPath, false)
+	}
 	return c.pool.WithClient(func(h *api.HTTPClient) error {
 		return api.DownloadFile(h, item, destPath, false)
 	})
@@ -378,13 +677,20 @@ func (c *Client) DownloadFileStream(item api.Item) (io.ReadCloser, error) {
 		c.pool.Release(client)
 		return nil, err
 	}
-	return &pooledReader{reader: reader, pool: c.pool, client: client}, nil
+	pr := &pooledReader{reader: reader, pool: c.pool, client: client}
+	if IsCompressed(item) {
+		return newDecompressReader(pr)
+	}
+	return pr, nil
 }
 
 func (c *Client) DownloadFileEncrypted(item api.Item, destPath string) error {
 	if err := c.defaultAuthChecks(true); err != nil {
 		return err
 	}
+	if IsCompressed(item) {
+		return c.downloadDecompressed(item, destPath, true)
+	}
 	return c.pool.WithClient(func(h *api.HTTPClient) error {
 		return api.DownloadFile(h, item, destPath, true)
 	})
@@ -401,7 +707,11 @@ func (c *Client) DownloadFileEncryptedStream(item api.Item) (io.ReadCloser, erro
 		c.pool.Release(client)
 		return nil, err
 	}
-	return &pooledReader{reader: reader, pool: c.pool, client: client}, nil
+	pr := &pooledReader{reader: reader, pool: c.pool, client: client}
+	if IsCompressed(item) {
+		return newDecompressReader(pr)
+	}
+	return pr, nil
 }
 
 func (c *Client) TrashItem(item api.Item) error {
@@ -0,0 +1,241 @@
+package client
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/StarHack/go-icedrive/api"
+)
+
+// CompressedSuffix marks files uploaded with compression enabled. The content
+// is plain gzip, so such files can also be restored with `gunzip -S .icz`.
+const CompressedSuffix = ".icz"
+
+// incompressibleExtensions are skipped by compression since they are compressed already
+var incompressibleExtensions = map[string]bool{
+	".7z": true, ".avi": true, ".br": true, ".bz2": true, ".docx": true, ".gif": true,
+	".gz": true, ".heic": true, ".jpeg": true, ".jpg": true, ".m4a": true, ".mkv": true,
+	".mov": true, ".mp3": true, ".mp4": true, ".ogg": true, ".pdf": true, ".png": true,
+	".pptx": true, ".rar": true, ".tgz": true, ".webm": true, ".webp": true, ".xlsx": true,
+	".xz": true, ".zip": true, ".zst": true, CompressedSuffix: true,
+}
+
+// EnableCompression gzips uploads at the given level (see compress/gzip) and
+// appends CompressedSuffix to their names. Files with already compressed
+// formats are uploaded as-is, as are files that would not get smaller.
+func (c *Client) EnableCompression(level int) error {
+	if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+		return fmt.Errorf("invalid compression level: %d", level)
+	}
+	c.compression = true
+	c.compressionLevel = level
+	return nil
+}
+
+// DisableCompression turns off compression of uploads. Compressed files are
+// still decompressed on download.
+func (c *Client) DisableCompression() {
+	c.compression = false
+}
+
+// IsCompressed reports whether an item's name marks it as uploaded with
+// compression. Names can collide with ordinary files, so downloads check for a
+// gzip header and pass other content through unchanged, see Decompressing.
+func IsCompressed(item api.Item) bool {
+	return item.IsFolder != 1 && strings.HasSuffix(item.Filename, CompressedSuffix)
+}
+
+// DecompressedName returns the item's name without CompressedSuffix
+func DecompressedName(item api.Item) string {
+	if !IsCompressed(item) {
+		return item.Filename
+	}
+	return strings.TrimSuffix(item.Filename, CompressedSuffix)
+}
+
+func (c *Client) shouldCompress(name string) bool {
+	return c.compression && !incompressibleExtensions[strings.ToLower(filepath.Ext(name))]
+}
+
+// compressToTemp gzips src into a temporary file, which the caller must remove
+func (c *Client) compressToTemp(src io.Reader) (*os.File, int64, error) {
+	tmp, err := os.CreateTemp("", "icedrive-upload-*"+CompressedSuffix)
+	if err != nil {
+		return nil, 0, err
+	}
+	zw, err := gzip.NewWriterLevel(tmp, c.compressionLevel)
+	if err == nil {
+		_, err = io.Copy(zw, src)
+		if cerr := zw.Close(); err == nil {
+			err = cerr
+		}
+	}
+	var size int64
+	if err == nil {
+		size, err = tmp.Seek(0, io.SeekCurrent)
+	}
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, 0, err
+	}
+	return tmp, size, nil
+}
+
+// uploadCompressed uploads localPath gzipped. It returns a nil response without
+// uploading if compression does not make the file smaller.
+func (c *Client) uploadCompressed(folderID uint64, localPath string, crypto bool, conflict api.UploadConflictPolicy) (*api.UploadResponse, error) {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	tmp, size, err := c.compressToTemp(f)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if size >= fi.Size() {
+		return nil, nil
+	}
+
+	opts := api.UploadOptions{Moddate: fi.ModTime(), Crypto: crypto, Size: size, Conflict: conflict}
+	if crypto {
+		opts.HexKey = c.CryptoHexKey
+	}
+	return c.uploadVerified(folderID, filepath.Base(localPath)+CompressedSuffix, tmp, opts)
+}
+
+// compressWriter gzips into an upload writer and closes both on Close
+type compressWriter struct {
+	zw *gzip.Writer
+	w  io.WriteCloser
+}
+
+// newCompressWriter wraps w; the level was validated by EnableCompression
+func (c *Client) newCompressWriter(w io.WriteCloser) *compressWriter {
+	zw, err := gzip.NewWriterLevel(w, c.compressionLevel)
+	if err != nil {
+		zw = gzip.NewWriter(w)
+	}
+	return &compressWriter{zw: zw, w: w}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	return cw.zw.Write(p)
+}
+
+func (cw *compressWriter) Close() error {
+	err := cw.zw.Close()
+	if cerr := cw.w.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// decompressReader gunzips a download stream and closes it on Close
+type decompressReader struct {
+	zr *gzip.Reader
+	rc io.ReadCloser
+}
+
+// rawReader passes through a download stream that carries CompressedSuffix but no gzip header
+type rawReader struct {
+	io.Reader
+	io.Closer
+}
+
+// newDecompressReader gunzips rc if it starts with a gzip header. Anything
+// else, e.g. a user's own file that happens to end in CompressedSuffix, is
+// passed through unchanged.
+func newDecompressReader(rc io.ReadCloser) (io.ReadCloser, error) {
+	br := bufio.NewReader(rc)
+	if !isGzipHeader(br) {
+		return &rawReader{Reader: br, Closer: rc}, nil
+	}
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		rc.Close()
+		return nil, fmt.Errorf("decompress: %w", err)
+	}
+	return &decompressReader{zr: zr, rc: rc}, nil
+}
+
+// isGzipHeader reports whether br starts with the magic, deflate method and
+// valid flags of a gzip member header
+func isGzipHeader(br *bufio.Reader) bool {
+	hdr, err := br.Peek(4)
+	return err == nil && hdr[0] == 0x1f && hdr[1] == 0x8b && hdr[2] == 8 && hdr[3]&0xe0 == 0
+}
+
+// Decompressing reports whether a download stream of a compressed item is
+// actually being decompressed, false if its content had no gzip header
+func Decompressing(rc io.ReadCloser) bool {
+	_, ok := rc.(*decompressReader)
+	return ok
+}
+
+// localName returns the name under which a download stream of item is stored
+func localName(item api.Item, rc io.ReadCloser) string {
+	if Decompressing(rc) {
+		return DecompressedName(item)
+	}
+	return item.Filename
+}
+
+func (dr *decompressReader) Read(p []byte) (int, error) {
+	return dr.zr.Read(p)
+}
+
+func (dr *decompressReader) Close() error {
+	_ = dr.zr.Close()
+	return dr.rc.Close()
+}
+
+// downloadDecompressed downloads a compressed item into destPath under its original name
+func (c *Client) downloadDecompressed(item api.Item, destPath string, crypto bool) error {
+	var rc io.ReadCloser
+	var err error
+	if crypto {
+		rc, err = c.DownloadFileEncryptedStream(item)
+	} else {
+		rc, err = c.DownloadFileStream(item)
+	}
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	if err := os.MkdirAll(destPath, 0o755); err != nil {
+		return err
+	}
+	dest := filepath.Join(destPath, localName(item, rc))
+	tmp := dest + ".part"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, rc); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dest)
+}
@@ -0,0 +1,217 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Credentials holds the email and password used for a username/password login
+type Credentials struct {
+	Email    string
+	Password string
+}
+
+// CredentialProvider supplies login credentials. It is called only when the
+// client needs to log in, so implementations need not keep secrets around.
+type CredentialProvider interface {
+	Credentials() (Credentials, error)
+}
+
+// Wiper is implemented by credential providers that cache secrets in memory.
+// Client.Close calls Wipe so the cached secrets are zeroed and dropped.
+type Wiper interface {
+	Wipe()
+}
+
+// CredentialProviderFunc adapts a function to the CredentialProvider interface
+type CredentialProviderFunc func() (Credentials, error)
+
+func (f CredentialProviderFunc) Credentials() (Credentials, error) {
+	return f()
+}
+
+// StaticCredentials returns fixed credentials. The password is kept in a
+// byte slice that Wipe zeroes; the string returned by each Credentials call is
+// a copy that cannot be wiped and should not be retained by callers.
+type StaticCredentials struct {
+	mu       sync.Mutex
+	email    string
+	password []byte
+}
+
+func NewStaticCredentials(email, password string) *StaticCredentials {
+	return &StaticCredentials{email: email, password: []byte(password)}
+}
+
+func (s *StaticCredentials) Credentials() (Credentials, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.email == "" || len(s.password) == 0 {
+		return Credentials{}, errors.New("no credentials available")
+	}
+	return Credentials{Email: s.email, Password: string(s.password)}, nil
+}
+
+func (s *StaticCredentials) Wipe() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.password {
+		s.password[i] = 0
+	}
+	s.password = nil
+	s.email = ""
+}
+
+// EnvCredentials reads credentials from the given environment variables on every call.
+// Empty names default to ICEDRIVE_EMAIL and ICEDRIVE_PASSWORD.
+func EnvCredentials(emailVar, passwordVar string) CredentialProvider {
+	if emailVar == "" {
+		emailVar = "ICEDRIVE_EMAIL"
+	}
+	if passwordVar == "" {
+		passwordVar = "ICEDRIVE_PASSWORD"
+	}
+	return CredentialProviderFunc(func() (Credentials, error) {
+		creds := Credentials{Email: os.Getenv(emailVar), Password: os.Getenv(passwordVar)}
+		if creds.Email == "" || creds.Password == "" {
+			return Credentials{}, fmt.Errorf("%s and %s must be set", emailVar, passwordVar)
+		}
+		return creds, nil
+	})
+}
+
+// FileCredentials reads credentials from a file in .env format
+// (ICEDRIVE_EMAIL=... and ICEDRIVE_PASSWORD=... lines) on every call. The file
+// contents are zeroed after parsing; the returned strings are copies.
+func FileCredentials(path string) CredentialProvider {
+	return CredentialProviderFunc(func() (Credentials, error) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return Credentials{}, err
+		}
+		defer wipeBytes(data)
+
+		var creds Credentials
+		sc := bufio.NewScanner(bytes.NewReader(data))
+		for sc.Scan() {
+			line := strings.TrimSpace(sc.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			key, value, ok := strings.Cut(line, "=")
+			if !ok {
+				continue
+			}
+			value = strings.Trim(strings.TrimSpace(value), `"'`)
+			switch strings.TrimSpace(key) {
+			case "ICEDRIVE_EMAIL":
+				creds.Email = value
+			case "ICEDRIVE_PASSWORD":
+				creds.Password = value
+			}
+		}
+		if creds.Email == "" || creds.Password == "" {
+			return Credentials{}, fmt.Errorf("%s: missing ICEDRIVE_EMAIL or ICEDRIVE_PASSWORD", path)
+		}
+		return creds, nil
+	})
+}
+
+// CommandCredentials runs an external command (e.g. a password manager CLI) on every call.
+// The command must print the email on the first line and the password on the second.
+func CommandCredentials(name string, args ...string) CredentialProvider {
+	return CredentialProviderFunc(func() (Credentials, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, name, args...)
+		cmd.Stderr = os.Stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return Credentials{}, fmt.Errorf("credential command failed: %w", err)
+		}
+		defer wipeBytes(out)
+
+		lines := strings.SplitN(strings.ReplaceAll(string(out), "\r\n", "\n"), "\n", 3)
+		if len(lines) < 2 || strings.TrimSpace(lines[0]) == "" || lines[1] == "" {
+			return Credentials{}, errors.New("credential command must print email and password on separate lines")
+		}
+		return Credentials{Email: strings.TrimSpace(lines[0]), Password: lines[1]}, nil
+	})
+}
+
+// PromptCredentials asks for the email and password on out and reads the answers from in.
+// Combine with CacheCredentials to avoid prompting on every re-login.
+func PromptCredentials(in io.Reader, out io.Writer) CredentialProvider {
+	r := bufio.NewReader(in)
+	return CredentialProviderFunc(func() (Credentials, error) {
+		fmt.Fprint(out, "Icedrive email: ")
+		email, err := r.ReadString('\n')
+		if err != nil && email == "" {
+			return Credentials{}, err
+		}
+		fmt.Fprint(out, "Icedrive password: ")
+		password, err := r.ReadString('\n')
+		if err != nil && password == "" {
+			return Credentials{}, err
+		}
+		creds := Credentials{Email: strings.TrimSpace(email), Password: strings.TrimRight(password, "\r\n")}
+		if creds.Email == "" || creds.Password == "" {
+			return Credentials{}, errors.New("email and password are required")
+		}
+		return creds, nil
+	})
+}
+
+// cachedCredentials remembers the first successful result of another provider
+type cachedCredentials struct {
+	provider CredentialProvider
+	cache    *StaticCredentials
+	mu       sync.Mutex
+}
+
+// CacheCredentials wraps a provider so it is only asked once. The cached
+// password is wiped by Client.Close.
+func CacheCredentials(provider CredentialProvider) CredentialProvider {
+	return &cachedCredentials{provider: provider}
+}
+
+func (c *cachedCredentials) Credentials() (Credentials, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cache != nil {
+		return c.cache.Credentials()
+	}
+	creds, err := c.provider.Credentials()
+	if err != nil {
+		return Credentials{}, err
+	}
+	c.cache = NewStaticCredentials(creds.Email, creds.Password)
+	return creds, nil
+}
+
+func (c *cachedCredentials) Wipe() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cache != nil {
+		c.cache.Wipe()
+		c.cache = nil
+	}
+	if w, ok := c.provider.(Wiper); ok {
+		w.Wipe()
+	}
+}
+
+func wipeBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,339 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/StarHack/go-icedrive/api"
+)
+
+// defaultDownloadBatchSize is the number of UIDs resolved per /download-multi call
+const defaultDownloadBatchSize = 100
+
+// DownloadDirOptions configures DownloadDir
+type DownloadDirOptions struct {
+	// Crypto downloads from the encrypted collection and decrypts names and contents
+	Crypto bool
+	// Concurrency is the number of parallel downloads, the pool size if <= 0
+	Concurrency int
+	// BatchSize is the number of download URLs requested at once, 100 if <= 0
+	BatchSize int
+	// SkipIdentical leaves local files alone whose size and modification time match the remote file
+	SkipIdentical bool
+	// Progress, if set, is called after each file, possibly from several goroutines at once
+	Progress func(DownloadResult)
+}
+
+// DownloadStatus is the outcome of a single file in DownloadDir
+type DownloadStatus string
+
+const (
+	DownloadStatusDownloaded DownloadStatus = "downloaded"
+	DownloadStatusSkipped    DownloadStatus = "skipped"
+	DownloadStatusFailed     DownloadStatus = "failed"
+)
+
+// DownloadResult describes what happened to one remote file
+type DownloadResult struct {
+	RemotePath string // slash-separated, relative to the downloaded folder
+	LocalPath  string
+	Size       int64 // bytes written locally
+	Status     DownloadStatus
+	Err        error
+}
+
+// DownloadDirSummary collects the per-file results of DownloadDir, sorted by RemotePath
+type DownloadDirSummary struct {
+	Results    []DownloadResult
+	Downloaded int
+	Skipped    int
+	Failed     int
+	Bytes      int64 // total size of downloaded files
+}
+
+// Err joins the errors of all failed results, nil if nothing failed
+func (s *DownloadDirSummary) Err() error {
+	var errs []error
+	for _, r := range s.Results {
+		if r.Status == DownloadStatusFailed {
+			errs = append(errs, fmt.Errorf("%s: %w", r.RemotePath, r.Err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *DownloadDirSummary) add(r DownloadResult) {
+	s.Results = append(s.Results, r)
+	switch r.Status {
+	case DownloadStatusDownloaded:
+		s.Downloaded++
+		s.Bytes += r.Size
+	case DownloadStatusSkipped:
+		s.Skipped++
+	case DownloadStatusFailed:
+		s.Failed++
+	}
+}
+
+type downloadDirJob struct {
+	item      api.Item
+	url       string
+	relPath   string
+	localPath string
+}
+
+// DownloadDir recursively downloads the remote folder folderID into localDir,
+// recreating its structure and setting local modification times from the
+// remote moddate. Download URLs are looked up in batches through a single
+// /download-multi call each, and files are fetched concurrently through the
+// pool. Compressed files (see EnableCompression) are stored decompressed.
+//
+// With SkipIdentical, a local file is kept if its modification time equals the
+// remote moddate and its size matches the remote file size. Compressed files
+// are compared by modification time only since their original size is unknown.
+//
+// Failures of single files don't stop the download; they are reported in the
+// summary. The returned error is set if listing the remote tree failed.
+func (c *Client) DownloadDir(folderID uint64, localDir string, opts DownloadDirOptions) (*DownloadDirSummary, error) {
+	if err := c.defaultAuthChecks(opts.Crypto); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(localDir, 0o755); err != nil {
+		return nil, err
+	}
+	workers := opts.Concurrency
+	if workers <= 0 {
+		workers = c.pool.Size()
+	}
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultDownloadBatchSize
+	}
+	hexkey := ""
+	if opts.Crypto {
+		hexkey = c.CryptoHexKey
+	}
+
+	summary := &DownloadDirSummary{}
+	var mu sync.Mutex
+	record := func(r DownloadResult) {
+		mu.Lock()
+		summary.add(r)
+		mu.Unlock()
+		if opts.Progress != nil {
+			opts.Progress(r)
+		}
+	}
+
+	jobs := make(chan downloadDirJob)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				record(c.downloadDirFile(job, hexkey))
+			}
+		}()
+	}
+
+	var batch []downloadDirJob
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		urls, err := c.downloadURLs(batch, opts.Crypto)
+		for i, job := range batch {
+			jobErr := err
+			if jobErr == nil && urls[i] == "" {
+				jobErr = fmt.Errorf("no download URL for %s", job.item.UID)
+			}
+			if jobErr != nil {
+				record(DownloadResult{RemotePath: job.relPath, LocalPath: job.localPath, Status: DownloadStatusFailed, Err: jobErr})
+				continue
+			}
+			job.url = urls[i]
+			jobs <- job
+		}
+		batch = nil
+	}
+
+	type localFolder struct {
+		path    string
+		moddate uint64
+	}
+	var folders []localFolder
+	walkErr := c.Walk(folderID, opts.Crypto, func(itemPath string, item api.Item) error {
+		localRel := filepath.FromSlash(itemPath)
+		if item.IsFolder != 1 {
+			localRel = filepath.Join(filepath.Dir(localRel), DecompressedName(item))
+		}
+		if !filepath.IsLocal(localRel) {
+			record(DownloadResult{RemotePath: itemPath, Status: DownloadStatusFailed, Err: fmt.Errorf("unsafe name %q", item.Filename)})
+			if item.IsFolder == 1 {
+				return SkipDir
+			}
+			return nil
+		}
+		localPath := filepath.Join(localDir, localRel)
+
+		if item.IsFolder == 1 {
+			if err := os.MkdirAll(localPath, 0o755); err != nil {
+				record(DownloadResult{RemotePath: itemPath, LocalPath: localPath, Status: DownloadStatusFailed, Err: err})
+				return SkipDir
+			}
+			folders = append(folders, localFolder{path: localPath, moddate: item.Moddate})
+			return nil
+		}
+		if opts.SkipIdentical && localIdentical(localPath, item) {
+			record(DownloadResult{RemotePath: itemPath, LocalPath: localPath, Status: DownloadStatusSkipped})
+			return nil
+		}
+		batch = append(batch, downloadDirJob{item: item, relPath: itemPath, localPath: localPath})
+		if len(batch) >= batchSize {
+			flush()
+		}
+		return nil
+	})
+	flush()
+	close(jobs)
+	wg.Wait()
+
+	// Writing files updates the folder mtimes, so restore them last, deepest first
+	for i := len(folders) - 1; i >= 0; i-- {
+		if folders[i].moddate > 0 {
+			t := time.Unix(int64(folders[i].moddate), 0)
+			_ = os.Chtimes(folders[i].path, t, t)
+		}
+	}
+
+	sort.Slice(summary.Results, func(i, j int) bool {
+		return summary.Results[i].RemotePath < summary.Results[j].RemotePath
+	})
+	return summary, walkErr
+}
+
+// downloadURLs resolves the URLs of a batch with one /download-multi call, in batch order
+func (c *Client) downloadURLs(batch []downloadDirJob, crypto bool) ([]string, error) {
+	uids := make([]string, len(batch))
+	for i, job := range batch {
+		uids[i] = job.item.UID
+	}
+	var entries []api.DownloadURLEntry
+	err := c.pool.WithClient(func(h *api.HTTPClient) error {
+		var err error
+		entries, err = api.GetDownloadURLs(h, uids, crypto)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[uint64]string, len(entries))
+	for _, e := range entries {
+		byID[e.ID] = e.URL
+	}
+	urls := make([]string, len(batch))
+	for i, job := range batch {
+		if u, ok := byID[fileID(job.item)]; ok {
+			urls[i] = u
+		} else if len(entries) == len(batch) {
+			// Fall back to the request order if the response lacks IDs
+			urls[i] = entries[i].URL
+		}
+	}
+	return urls, nil
+}
+
+// fileID returns the numeric ID of a file item, parsed from its UID if needed
+func fileID(item api.Item) uint64 {
+	if item.ID != 0 {
+		return item.ID
+	}
+	var id uint64
+	fmt.Sscanf(item.UID, "file-%d", &id)
+	return id
+}
+
+func (c *Client) downloadDirFile(job downloadDirJob, hexkey string) DownloadResult {
+	result := DownloadResult{RemotePath: job.relPath, LocalPath: job.localPath, Status: DownloadStatusFailed}
+
+	h := c.pool.Acquire()
+	defer c.pool.Release(h)
+	rc, err := api.OpenDownloadURL(h, job.url, hexkey)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	if IsCompressed(job.item) {
+		if rc, err = newDecompressReader(rc); err != nil {
+			result.Err = err
+			return result
+		}
+		if !Decompressing(rc) {
+			job.localPath = filepath.Join(filepath.Dir(job.localPath), job.item.Filename)
+			result.LocalPath = job.localPath
+		}
+	}
+	defer rc.Close()
+
+	n, err := writeLocalFile(job.localPath, rc, job.item)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	result.Size = n
+	result.Status = DownloadStatusDownloaded
+	return result
+}
+
+// writeLocalFile stores r at localPath through a .part file and sets the
+// modification time from the remote item
+func writeLocalFile(localPath string, r io.Reader, item api.Item) (int64, error) {
+	tmp := localPath + ".part"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(out, r)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, localPath)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	if item.Moddate > 0 {
+		t := time.Unix(int64(item.Moddate), 0)
+		_ = os.Chtimes(localPath, t, t)
+	}
+	return n, nil
+}
+
+// localIdentical reports whether the local file matches item by modification time and size
+func localIdentical(localPath string, item api.Item) bool {
+	fi, err := os.Stat(localPath)
+	if err != nil || !fi.Mode().IsRegular() {
+		return false
+	}
+	return MatchesRemote(fi.Size(), fi.ModTime(), item)
+}
+
+// MatchesRemote reports whether a local file of the given size and modification
+// time matches the remote file item, at one-second precision. Compressed items
+// are compared by modification time only.
+func MatchesRemote(size int64, modTime time.Time, item api.Item) bool {
+	if IsCompressed(item) {
+		return modTime.Unix() == int64(item.Moddate)
+	}
+	return api.ItemMatches(item, size, modTime)
+}
@@ -0,0 +1,244 @@
+package client
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/StarHack/go-icedrive/api"
+)
+
+// DuplicateOptions selects what FindDuplicates examines
+type DuplicateOptions struct {
+	// Cloud and Crypto select the plain and the encrypted collection; both may be set
+	Cloud  bool
+	Crypto bool
+	// FolderID limits the search to a folder and its subfolders, 0 is the root
+	FolderID uint64
+	// MinSize ignores files smaller than this; empty files are always ignored
+	MinSize int64
+	// Concurrency is the number of files hashed in parallel, the pool size if <= 0
+	Concurrency int
+	// Index, if set, is walked instead of the API for the collection it covers
+	Index *Index
+}
+
+// DuplicateFile is one copy of a duplicated file
+type DuplicateFile struct {
+	Collection api.CollectionType `json:"collection"`
+	Path       string             `json:"path"`
+	UID        string             `json:"uid"`
+	Moddate    uint64             `json:"moddate"`
+	Item       api.Item           `json:"-"`
+}
+
+// DuplicateGroup lists files with identical content. Files are ordered by
+// modification time, the first is regarded as the original and kept by TrashDuplicates.
+type DuplicateGroup struct {
+	SHA256 string          `json:"sha256"`
+	Size   uint64          `json:"size"`
+	Files  []DuplicateFile `json:"files"`
+}
+
+// DuplicateReport is the result of FindDuplicates
+type DuplicateReport struct {
+	Groups []DuplicateGroup `json:"groups"`
+	// Files is the number of files examined, Hashed how many of them had to be compared by hash
+	Files  int `json:"files"`
+	Hashed int `json:"hashed"`
+	// Wasted is the listed size of all copies but the first of each group
+	Wasted uint64 `json:"wasted"`
+
+	errs []error
+}
+
+// Err joins the errors of files that could not be hashed, nil if there were none
+func (r *DuplicateReport) Err() error {
+	return errors.Join(r.errs...)
+}
+
+// Duplicates returns the files TrashDuplicates would remove
+func (r *DuplicateReport) Duplicates() []DuplicateFile {
+	var files []DuplicateFile
+	for _, g := range r.Groups {
+		files = append(files, g.Files[1:]...)
+	}
+	return files
+}
+
+// WriteJSON writes the report as indented JSON
+func (r *DuplicateReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteCSV writes one row per file, with the group number and whether the file is kept
+func (r *DuplicateReport) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"group", "sha256", "size", "collection", "path", "uid", "moddate", "keep"})
+	for i, g := range r.Groups {
+		for j, f := range g.Files {
+			_ = cw.Write([]string{
+				strconv.Itoa(i + 1),
+				g.SHA256,
+				strconv.FormatUint(g.Size, 10),
+				string(f.Collection),
+				f.Path,
+				f.UID,
+				strconv.FormatUint(f.Moddate, 10),
+				strconv.FormatBool(j == 0),
+			})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// FindDuplicates walks the selected collections, groups files by their listed
+// size and confirms candidates by the SHA-256 of their content. Hashes come
+// from the hash cache where possible; other candidates are downloaded, so
+// setting a persistent cache with SetHashCache makes repeated runs cheap.
+// Sizes of encrypted files are compared as listed, so copies in different
+// collections are only matched if the server lists both with the same size.
+// Files that could not be hashed are left out and reported by Err.
+func (c *Client) FindDuplicates(opts DuplicateOptions) (*DuplicateReport, error) {
+	if !opts.Cloud && !opts.Crypto {
+		return nil, errors.New("select at least one collection")
+	}
+	if err := c.defaultAuthChecks(opts.Crypto); err != nil {
+		return nil, err
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = c.PoolSize()
+	}
+
+	report := &DuplicateReport{}
+	bySize := map[uint64][]DuplicateFile{}
+	collect := func(cType api.CollectionType, crypto bool) error {
+		return c.walkTree(opts.Index, opts.FolderID, crypto, func(itemPath string, item api.Item) error {
+			if item.IsFolder == 1 || item.Filesize == 0 || int64(item.Filesize) < opts.MinSize {
+				return nil
+			}
+			report.Files++
+			bySize[item.Filesize] = append(bySize[item.Filesize], DuplicateFile{
+				Collection: cType,
+				Path:       "/" + itemPath,
+				UID:        item.UID,
+				Moddate:    item.Moddate,
+				Item:       item,
+			})
+			return nil
+		})
+	}
+	if opts.Cloud {
+		if err := collect(api.CollectionCloud, false); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Crypto {
+		if err := collect(api.CollectionCrypto, true); err != nil {
+			return nil, err
+		}
+	}
+
+	var candidates []DuplicateFile
+	for _, files := range bySize {
+		if len(files) > 1 {
+			candidates = append(candidates, files...)
+		}
+	}
+	report.Hashed = len(candidates)
+
+	sums := make([]string, len(candidates))
+	var mu sync.Mutex
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				sum, err := c.HashRemote(candidates[idx].Item)
+				if err != nil {
+					mu.Lock()
+					report.errs = append(report.errs, fmt.Errorf("%s:%s: %w", candidates[idx].Collection, candidates[idx].Path, err))
+					mu.Unlock()
+					continue
+				}
+				sums[idx] = sum
+			}
+		}()
+	}
+	for idx := range candidates {
+		jobs <- idx
+	}
+	close(jobs)
+	wg.Wait()
+
+	type groupKey struct {
+		size uint64
+		sum  string
+	}
+	groups := map[groupKey][]DuplicateFile{}
+	for idx, f := range candidates {
+		if sums[idx] == "" {
+			continue
+		}
+		k := groupKey{f.Item.Filesize, sums[idx]}
+		groups[k] = append(groups[k], f)
+	}
+	for k, files := range groups {
+		if len(files) < 2 {
+			continue
+		}
+		sort.Slice(files, func(i, j int) bool {
+			if files[i].Moddate != files[j].Moddate {
+				return files[i].Moddate < files[j].Moddate
+			}
+			return files[i].Path < files[j].Path
+		})
+		report.Groups = append(report.Groups, DuplicateGroup{SHA256: k.sum, Size: k.size, Files: files})
+		report.Wasted += k.size * uint64(len(files)-1)
+	}
+	sort.Slice(report.Groups, func(i, j int) bool {
+		a, b := report.Groups[i], report.Groups[j]
+		if a.Size != b.Size {
+			return a.Size > b.Size
+		}
+		return a.Files[0].Path < b.Files[0].Path
+	})
+	return report, nil
+}
+
+// TrashDuplicates moves every file of the report except the first of each
+// group to the trash in a single trash-add request and returns the number of
+// files trashed. trash-add carries no crypto flag and identifies files by UID,
+// so plain and encrypted files go out together.
+func (c *Client) TrashDuplicates(report *DuplicateReport) (int, error) {
+	duplicates := report.Duplicates()
+	if len(duplicates) == 0 {
+		return 0, nil
+	}
+	items := make([]api.Item, len(duplicates))
+	for i, f := range duplicates {
+		items[i] = f.Item
+	}
+	err := c.pool.WithClient(func(h *api.HTTPClient) error {
+		return api.TrashAdd(h, items...)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if c.hashCache != nil {
+		for _, item := range items {
+			c.hashCache.Forget(item.UID)
+		}
+	}
+	return len(items), nil
+}
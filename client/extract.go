@@ -0,0 +1,231 @@
+package client
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/StarHack/go-icedrive/api"
+)
+
+// DetectArchiveFormat returns the format of an archive from its file name
+func DetectArchiveFormat(name string) (ArchiveFormat, error) {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return ArchiveZip, nil
+	case strings.HasSuffix(lower, ".tar"):
+		return ArchiveTar, nil
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return ArchiveTarGz, nil
+	}
+	return 0, fmt.Errorf("unknown archive type: %s", name)
+}
+
+// ExtractOptions controls ExtractArchive. Zip archives need random access:
+// they are read in place if the reader is an *os.File, any other reader is
+// read into memory whole first, so pass large zip archives as files.
+type ExtractOptions struct {
+	Format ArchiveFormat
+	// Crypto extracts into the encrypted collection
+	Crypto bool
+	// Conflict decides what happens to files that already exist remotely
+	Conflict api.UploadConflictPolicy
+	// Progress, if set, is called after each file
+	Progress func(UploadResult)
+}
+
+// ExtractArchive reads a zip, tar or tar.gz archive from r and recreates its
+// contents below folderID, creating folders as needed and uploading files
+// with their modification times. Nothing is written to local disk: tar
+// entries are uploaded as they are read, zip archives are read in place if r
+// is an *os.File and buffered in memory otherwise. Entries with absolute or
+// escaping paths, links and special files are skipped. Like UploadDir, single
+// failures don't stop the extraction and are reported in the summary; the
+// returned error is set if the archive could not be read.
+func (c *Client) ExtractArchive(r io.Reader, folderID uint64, opts ExtractOptions) (*UploadDirSummary, error) {
+	if err := c.defaultAuthChecks(opts.Crypto); err != nil {
+		return nil, err
+	}
+	x := &extractor{
+		c:       c,
+		opts:    opts,
+		folders: newRemoteFolders(c, opts.Crypto),
+		ids:     map[string]uint64{".": folderID},
+		summary: &UploadDirSummary{},
+	}
+	var err error
+	switch opts.Format {
+	case ArchiveTar:
+		err = x.tar(r)
+	case ArchiveTarGz:
+		var gz *gzip.Reader
+		if gz, err = gzip.NewReader(r); err == nil {
+			err = x.tar(gz)
+			gz.Close()
+		}
+	case ArchiveZip:
+		err = x.zip(r)
+	default:
+		err = fmt.Errorf("unknown archive format %d", opts.Format)
+	}
+	return x.summary, err
+}
+
+// extractor uploads the entries of one archive
+type extractor struct {
+	c       *Client
+	opts    ExtractOptions
+	folders *remoteFolders
+	ids     map[string]uint64 // folder IDs by cleaned archive path
+	summary *UploadDirSummary
+}
+
+func (x *extractor) tar(r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			x.dir(hdr.Name)
+		case tar.TypeReg:
+			x.file(hdr.Name, hdr.ModTime, hdr.Size, tr)
+		default:
+			x.record(UploadResult{LocalPath: hdr.Name, Status: UploadStatusSkipped, Err: errors.New("not a regular file")})
+		}
+	}
+}
+
+func (x *extractor) zip(r io.Reader) error {
+	var ra io.ReaderAt
+	var size int64
+	if f, ok := r.(*os.File); ok {
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		ra, size = f, fi.Size()
+	} else {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		ra, size = bytes.NewReader(data), int64(len(data))
+	}
+	zr, err := zip.NewReader(ra, size)
+	if err != nil {
+		return err
+	}
+	for _, f := range zr.File {
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			x.dir(f.Name)
+		case mode.IsRegular():
+			rc, err := f.Open()
+			if err != nil {
+				x.record(UploadResult{LocalPath: f.Name, Status: UploadStatusFailed, Err: err})
+				continue
+			}
+			x.file(f.Name, f.Modified, int64(f.UncompressedSize64), rc)
+			rc.Close()
+		default:
+			x.record(UploadResult{LocalPath: f.Name, Status: UploadStatusSkipped, Err: errors.New("not a regular file")})
+		}
+	}
+	return nil
+}
+
+func (x *extractor) record(r UploadResult) {
+	x.summary.add(r)
+	if x.opts.Progress != nil {
+		x.opts.Progress(r)
+	}
+}
+
+// cleanEntryPath returns the slash-separated path of an archive entry, false if it would escape the target
+func cleanEntryPath(name string) (string, bool) {
+	p := path.Clean(strings.TrimPrefix(name, "./"))
+	return p, p != "." && fs.ValidPath(p)
+}
+
+// folder returns the ID of the remote folder for the cleaned archive path dir
+func (x *extractor) folder(dir string) (uint64, error) {
+	if id, ok := x.ids[dir]; ok {
+		return id, nil
+	}
+	parentID, err := x.folder(path.Dir(dir))
+	if err != nil {
+		return 0, err
+	}
+	id, err := x.folders.ensure(parentID, path.Base(dir))
+	if err != nil {
+		return 0, err
+	}
+	x.ids[dir] = id
+	return id, nil
+}
+
+func (x *extractor) dir(name string) {
+	p, ok := cleanEntryPath(name)
+	if !ok {
+		if p != "." {
+			x.record(UploadResult{LocalPath: name, Status: UploadStatusSkipped, Err: errors.New("unsafe path")})
+		}
+		return
+	}
+	if _, err := x.folder(p); err != nil {
+		x.record(UploadResult{LocalPath: name, RemotePath: p, Status: UploadStatusFailed, Err: err})
+	}
+}
+
+func (x *extractor) file(name string, mtime time.Time, size int64, r io.Reader) {
+	result := UploadResult{LocalPath: name, Size: size, Status: UploadStatusFailed}
+	p, ok := cleanEntryPath(name)
+	if !ok {
+		result.Status, result.Err = UploadStatusSkipped, errors.New("unsafe path")
+		x.record(result)
+		return
+	}
+	result.RemotePath = p
+	folderID, err := x.folder(path.Dir(p))
+	if err == nil {
+		err = x.c.ensureTokenFresh(x.c.uploadTokenMargin)
+	}
+	if err != nil {
+		result.Err = err
+		x.record(result)
+		return
+	}
+
+	opts := api.UploadOptions{Moddate: mtime, Crypto: x.opts.Crypto, Size: size, Conflict: x.opts.Conflict}
+	if x.opts.Crypto {
+		opts.HexKey = x.c.CryptoHexKey
+	}
+	resp, err := x.c.uploadVerified(folderID, path.Base(p), r, opts)
+	switch {
+	case errors.Is(err, api.ErrUploadSkipped):
+		result.Status, result.Err = UploadStatusSkipped, err
+	case err != nil:
+		result.Err = err
+	default:
+		result.Status = UploadStatusUploaded
+		result.UID = UploadedUID(resp)
+	}
+	x.record(result)
+}
@@ -0,0 +1,58 @@
+package client
+
+import (
+	"github.com/StarHack/go-icedrive/api"
+)
+
+// AddFavorites marks files and folders as favorites, plain and encrypted ones alike
+func (c *Client) AddFavorites(items ...api.Item) error {
+	return c.itemsByCollection(items, api.FaveAdd)
+}
+
+// RemoveFavorites removes files and folders from the favorites
+func (c *Client) RemoveFavorites(items ...api.Item) error {
+	return c.itemsByCollection(items, api.FaveRemove)
+}
+
+// SetFolderColor sets the color label of folders, as chosen from the palette
+// of the Icedrive apps; an empty color removes the label
+func (c *Client) SetFolderColor(color string, folders ...api.Item) error {
+	return c.itemsByCollection(folders, func(h *api.HTTPClient, items ...api.Item) error {
+		return api.SetFolderColor(h, color, items...)
+	})
+}
+
+// ListFavorites returns the files and folders marked as favorites below
+// opts.FolderID. No favorites listing is known, so like Search it walks the
+// whole tree below the folder, one listing request per folder, unless
+// opts.Index is set.
+func (c *Client) ListFavorites(opts SearchOptions) ([]SearchResult, error) {
+	return c.Search(SearchQuery{Favorites: true, Folders: true}, opts)
+}
+
+// itemsByCollection applies a bulk request to items, sending one request per collection
+func (c *Client) itemsByCollection(items []api.Item, fn func(h *api.HTTPClient, items ...api.Item) error) error {
+	var plain, crypto []api.Item
+	for _, item := range items {
+		if item.Crypto == 1 {
+			crypto = append(crypto, item)
+		} else {
+			plain = append(plain, item)
+		}
+	}
+	if err := c.defaultAuthChecks(false); err != nil {
+		return err
+	}
+	for _, batch := range [][]api.Item{plain, crypto} {
+		if len(batch) == 0 {
+			continue
+		}
+		err := c.pool.WithClient(func(h *api.HTTPClient) error {
+			return fn(h, batch...)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/StarHack/go-icedrive/api"
+)
+
+// hashCacheVersion is bumped when the hash cache format changes incompatibly
+const hashCacheVersion = 2
+
+// HashAlgorithm names the hash function of a cached hash
+type HashAlgorithm string
+
+// HashSHA256 is the only algorithm HashRemote computes; it matches the local
+// hashes of the syncer and upload verification
+const HashSHA256 HashAlgorithm = "sha256"
+
+// hashCacheEntry is the hash of a remote file as it was when last hashed
+type hashCacheEntry struct {
+	Size      uint64        `json:"size"`
+	Moddate   uint64        `json:"moddate"`
+	Algorithm HashAlgorithm `json:"algorithm"`
+	Sum       string        `json:"sum"`
+}
+
+// HashCache remembers the SHA-256 of remote files so each is only downloaded
+// for hashing once. Entries are keyed by UID and only valid while the file's
+// listed size and moddate are unchanged. Each entry records its algorithm, so
+// hashes of other algorithms are never mistaken for SHA-256 ones. It is safe
+// for concurrent use.
+type HashCache struct {
+	Version int                        `json:"version"`
+	Files   map[string]*hashCacheEntry `json:"files"`
+
+	path  string
+	mu    sync.Mutex
+	dirty bool
+}
+
+// NewHashCache returns an empty cache kept in memory only
+func NewHashCache() *HashCache {
+	return &HashCache{Version: hashCacheVersion, Files: map[string]*hashCacheEntry{}}
+}
+
+// LoadHashCache reads the cache file at path, or starts an empty cache that
+// Save writes there. A cache of an older version is discarded.
+func LoadHashCache(path string) (*HashCache, error) {
+	hc := NewHashCache()
+	hc.path = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return hc, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, hc); err != nil {
+		return nil, fmt.Errorf("corrupt hash cache %s: %w", path, err)
+	}
+	if hc.Version != hashCacheVersion || hc.Files == nil {
+		hc.Version, hc.Files = hashCacheVersion, map[string]*hashCacheEntry{}
+	}
+	return hc, nil
+}
+
+// DefaultHashCachePath returns the per-user location of the hash cache
+func DefaultHashCachePath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "icedrive", "hashes.json"), nil
+}
+
+// Get returns the cached hash of item if it is still current
+func (hc *HashCache) Get(item api.Item) (string, bool) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	e, ok := hc.Files[item.UID]
+	if !ok || e.Algorithm != HashSHA256 || e.Size != item.Filesize || e.Moddate != item.Moddate {
+		return "", false
+	}
+	return e.Sum, true
+}
+
+// Put records the hex SHA-256 of item's content, replacing older hashes of the same file
+func (hc *HashCache) Put(item api.Item, sum string) {
+	if item.UID == "" {
+		return
+	}
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	hc.Files[item.UID] = &hashCacheEntry{Size: item.Filesize, Moddate: item.Moddate, Algorithm: HashSHA256, Sum: sum}
+	hc.dirty = true
+}
+
+// Forget drops the hash of the file with the given UID, e.g. after it was deleted
+func (hc *HashCache) Forget(uid string) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	if _, ok := hc.Files[uid]; ok {
+		delete(hc.Files, uid)
+		hc.dirty = true
+	}
+}
+
+// Save writes the cache file atomically if it changed. In-memory caches are not saved.
+func (hc *HashCache) Save() error {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	if hc.path == "" || !hc.dirty {
+		return nil
+	}
+	data, err := json.Marshal(hc)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(hc.path), 0o755); err != nil {
+		return err
+	}
+	tmp := hc.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, hc.path); err != nil {
+		return err
+	}
+	hc.dirty = false
+	return nil
+}
+
+// SetHashCache makes HashRemote, upload verification and the syncer share
+// hashes through hc. Nil disables caching.
+func (c *Client) SetHashCache(hc *HashCache) {
+	c.hashCache = hc
+}
+
+// HashCache returns the cache set by SetHashCache, nil if none
+func (c *Client) HashCache() *HashCache {
+	return c.hashCache
+}
+
+// CachedRemoteHash returns the hash of item if the cache knows it, without downloading anything
+func (c *Client) CachedRemoteHash(item api.Item) (string, bool) {
+	if c.hashCache == nil {
+		return "", false
+	}
+	return c.hashCache.Get(item)
+}
+
+// HashRemote returns the hex SHA-256 of the content of the remote file item.
+// Encrypted files are hashed after decryption and compressed ones after
+// decompression, so the hash equals that of the downloaded file. The file is
+// only streamed if the hash cache does not know it yet.
+func (c *Client) HashRemote(item api.Item) (string, error) {
+	if item.IsFolder == 1 {
+		return "", fmt.Errorf("%s is a folder", item.Filename)
+	}
+	if sum, ok := c.CachedRemoteHash(item); ok {
+		return sum, nil
+	}
+	var rc io.ReadCloser
+	var err error
+	if item.Crypto == 1 {
+		rc, err = c.DownloadFileEncryptedStream(item)
+	} else {
+		rc, err = c.DownloadFileStream(item)
+	}
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, rc); err != nil {
+		return "", fmt.Errorf("hash %s: %w", item.Filename, err)
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	if c.hashCache != nil {
+		c.hashCache.Put(item, sum)
+	}
+	return sum, nil
+}
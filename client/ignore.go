@@ -0,0 +1,115 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// IgnoreFileName is read from every local directory by UploadDir. Each line is
+// a glob (see path.Match) relative to the directory holding the file:
+//
+//	# comment
+//	*.tmp       matches a name at any depth
+//	/build      leading slash anchors to this directory
+//	cache/      trailing slash matches directories only
+//	!keep.tmp   negation re-includes an earlier match
+//
+// Later lines win over earlier ones, and files in subdirectories add to the
+// rules of their parents.
+const IgnoreFileName = ".icedriveignore"
+
+type ignoreRule struct {
+	base     string // slash-separated directory of the ignore file, "" for the root
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// ignoreRules is an ordered list of rules where the last match wins
+type ignoreRules []ignoreRule
+
+// loadIgnoreFile appends the rules found in dir's ignore file, if any. relDir is dir relative to the walk root.
+func (r ignoreRules) loadIgnoreFile(dir, relDir string) (ignoreRules, error) {
+	f, err := os.Open(filepath.Join(dir, IgnoreFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// Copy so that sibling directories don't share appended rules
+	rules := append(ignoreRules(nil), r...)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule := ignoreRule{base: relDir}
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if strings.HasPrefix(line, "/") {
+			rule.anchored = true
+			line = strings.TrimLeft(line, "/")
+		} else if strings.Contains(line, "/") {
+			rule.anchored = true
+		}
+		if _, err := path.Match(line, ""); err != nil || line == "" {
+			continue
+		}
+		rule.pattern = line
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// ignored reports whether relPath (slash-separated, relative to the walk root) is excluded
+func (r ignoreRules) ignored(relPath string, isDir bool) bool {
+	ignored := false
+	for _, rule := range r {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		rel := relPath
+		if rule.base != "" {
+			if !strings.HasPrefix(relPath, rule.base+"/") {
+				continue
+			}
+			rel = strings.TrimPrefix(relPath, rule.base+"/")
+		}
+		subject := rel
+		if !rule.anchored {
+			subject = path.Base(rel)
+		}
+		if ok, _ := path.Match(rule.pattern, subject); ok {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// matchAnyGlob reports whether relPath or its base name matches one of the globs
+func matchAnyGlob(globs []string, relPath string) bool {
+	for _, g := range globs {
+		if ok, _ := path.Match(g, relPath); ok {
+			return true
+		}
+		if ok, _ := path.Match(g, path.Base(relPath)); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,353 @@
+package client
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/StarHack/go-icedrive/api"
+)
+
+// indexVersion is bumped when the index file format changes incompatibly
+const indexVersion = 2
+
+// indexFolder is the listing of one folder as it was last refreshed
+type indexFolder struct {
+	Path string `json:"path"`
+	// Moddate is the folder's moddate as listed in its parent when it was listed
+	Moddate uint64     `json:"moddate"`
+	Items   []api.Item `json:"items"`
+}
+
+type indexFile struct {
+	Version    int                     `json:"version"`
+	RootID     uint64                  `json:"root_id"`
+	Crypto     bool                    `json:"crypto"`
+	Refreshed  time.Time               `json:"refreshed"`
+	Unverified int                     `json:"unverified"`
+	Folders    map[uint64]*indexFolder `json:"folders"`
+}
+
+// Index is a local copy of the metadata of a remote tree, stored as gzipped
+// JSON. Once refreshed it answers listings, walks, path lookups and searches
+// without API requests, and can stand in for the API in Search, DiskUsage and
+// the syncer. It is safe for concurrent use.
+type Index struct {
+	path string
+	mu   sync.RWMutex
+	data indexFile
+}
+
+// IndexRefresh counts the folders handled by a refresh
+type IndexRefresh struct {
+	// Listed folders were fetched again, Reused ones were taken over unchanged
+	Listed int
+	Reused int
+	// Unverified is the number of reused folders whose moddate was not seen,
+	// because they lie below another reused folder; changes inside them cannot
+	// have been detected
+	Unverified int
+}
+
+// OpenIndex reads the index of the folder rootID at path, or starts an empty
+// one that Refresh fills. The index must belong to the same remote folder.
+func OpenIndex(path string, rootID uint64, crypto bool) (*Index, error) {
+	x := &Index{
+		path: path,
+		data: indexFile{Version: indexVersion, RootID: rootID, Crypto: crypto, Folders: map[uint64]*indexFolder{}},
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return x, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("corrupt index %s: %w", path, err)
+	}
+	var data indexFile
+	if err := json.NewDecoder(zr).Decode(&data); err != nil {
+		return nil, fmt.Errorf("corrupt index %s: %w", path, err)
+	}
+	if data.Version != indexVersion {
+		// An outdated index is rebuilt rather than rejected
+		return x, nil
+	}
+	if data.RootID != rootID || data.Crypto != crypto {
+		return nil, fmt.Errorf("index %s belongs to a different remote folder", path)
+	}
+	if data.Folders == nil {
+		data.Folders = map[uint64]*indexFolder{}
+	}
+	x.data = data
+	return x, nil
+}
+
+// DefaultIndexPath returns the per-user location of the index of a remote folder
+func DefaultIndexPath(rootID uint64, crypto bool) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	collection := api.CollectionCloud
+	if crypto {
+		collection = api.CollectionCrypto
+	}
+	return filepath.Join(dir, "icedrive", fmt.Sprintf("index-%s-%d.json.gz", collection, rootID)), nil
+}
+
+// Refresh brings the index up to date with one listing request per changed
+// folder. The root folder is always listed, and so are folders new to the
+// index. Any other subfolder of a listed folder is listed again only if its
+// moddate in that listing differs from the indexed one; otherwise its whole
+// subtree is taken over from the index without further requests.
+//
+// Files added, deleted, renamed or replaced directly inside a folder are found
+// as long as the server bumps the folder's moddate for them. Changes further
+// down are only found if it also bumps the moddate of every folder up to the
+// nearest listed one, which is not documented. The folders where such changes
+// can hide are counted as Unverified; if that is not zero, call Rebuild before
+// relying on the index to delete or overwrite anything.
+func (x *Index) Refresh(c *Client) (IndexRefresh, error) {
+	return x.refresh(c, false)
+}
+
+// Rebuild lists every folder again, regardless of what the index holds. It
+// makes one listing request per folder and leaves nothing unverified.
+func (x *Index) Rebuild(c *Client) (IndexRefresh, error) {
+	return x.refresh(c, true)
+}
+
+func (x *Index) refresh(c *Client, full bool) (IndexRefresh, error) {
+	var stats IndexRefresh
+	if err := c.defaultAuthChecks(x.data.Crypto); err != nil {
+		return stats, err
+	}
+	x.mu.RLock()
+	old := x.data.Folders
+	x.mu.RUnlock()
+	if full {
+		old = map[uint64]*indexFolder{}
+	}
+
+	folders := map[uint64]*indexFolder{}
+	// visit lists a folder and its changed subfolders
+	var visit func(id uint64, p string, moddate uint64) error
+	visit = func(id uint64, p string, moddate uint64) error {
+		var items []api.Item
+		var err error
+		if x.data.Crypto {
+			items, err = c.ListFolderEncrypted(id)
+		} else {
+			items, err = c.ListFolder(id)
+		}
+		if err != nil {
+			return fmt.Errorf("list %q: %w", p, err)
+		}
+		stats.Listed++
+		folders[id] = &indexFolder{Path: p, Moddate: moddate, Items: items}
+		for _, item := range items {
+			if item.IsFolder != 1 {
+				continue
+			}
+			childID, childPath := FolderID(item), path.Join(p, item.Filename)
+			if prev, known := old[childID]; known && prev.Moddate == item.Moddate {
+				n := reuseSubtree(old, folders, childID, childPath)
+				stats.Reused += n
+				stats.Unverified += n - 1
+				continue
+			}
+			if err := visit(childID, childPath, item.Moddate); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := visit(x.data.RootID, "/", 0); err != nil {
+		return stats, err
+	}
+
+	x.mu.Lock()
+	x.data.Folders = folders
+	x.data.Refreshed = time.Now()
+	x.data.Unverified = stats.Unverified
+	x.mu.Unlock()
+	return stats, nil
+}
+
+// reuseSubtree copies the folder id and its indexed subfolders from old to
+// folders under the path p, which changes if a folder was renamed or moved.
+// Subfolders missing from old are left out and show up as unindexed.
+func reuseSubtree(old, folders map[uint64]*indexFolder, id uint64, p string) int {
+	prev, ok := old[id]
+	if !ok {
+		return 0
+	}
+	f := *prev
+	f.Path = p
+	folders[id] = &f
+	n := 1
+	for _, item := range f.Items {
+		if item.IsFolder == 1 {
+			n += reuseSubtree(old, folders, FolderID(item), path.Join(p, item.Filename))
+		}
+	}
+	return n
+}
+
+// Save writes the index file atomically
+func (x *Index) Save() error {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if err := os.MkdirAll(filepath.Dir(x.path), 0o755); err != nil {
+		return err
+	}
+	tmp := x.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(f)
+	err = json.NewEncoder(zw).Encode(&x.data)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, x.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// Refreshed returns when the index was last refreshed, zero if never
+func (x *Index) Refreshed() time.Time {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.data.Refreshed
+}
+
+// Unverified returns the number of folders the last refresh took over without
+// being able to detect changes in them, see Refresh
+func (x *Index) Unverified() int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.data.Unverified
+}
+
+// List returns the indexed contents of a folder, false if it is not indexed
+func (x *Index) List(folderID uint64) ([]api.Item, bool) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	f, ok := x.data.Folders[folderID]
+	if !ok {
+		return nil, false
+	}
+	return f.Items, true
+}
+
+// Lookup resolves a slash-separated path relative to the index root, e.g.
+// "/Photos/2024/img.jpg". The root itself resolves to a folder item without a UID.
+func (x *Index) Lookup(p string) (api.Item, bool) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	item := api.Item{ID: x.data.RootID, IsFolder: 1}
+	for _, name := range strings.Split(strings.Trim(path.Clean("/"+p), "/"), "/") {
+		if name == "" {
+			continue
+		}
+		f, ok := x.data.Folders[FolderID(item)]
+		if item.IsFolder != 1 || !ok {
+			return api.Item{}, false
+		}
+		found := false
+		for _, child := range f.Items {
+			if child.Filename == name {
+				item, found = child, true
+				break
+			}
+		}
+		if !found {
+			return api.Item{}, false
+		}
+	}
+	return item, true
+}
+
+// Walk visits the indexed items below folderID like Client.Walk, without API
+// requests. It fails if crypto does not match the index or a folder on the
+// way is not indexed.
+func (x *Index) Walk(folderID uint64, crypto bool, fn WalkFunc) error {
+	if crypto != x.data.Crypto {
+		return errors.New("index covers the other collection")
+	}
+	return x.walk(folderID, "", fn)
+}
+
+func (x *Index) walk(folderID uint64, prefix string, fn WalkFunc) error {
+	items, ok := x.List(folderID)
+	if !ok {
+		return fmt.Errorf("folder %q is not indexed, refresh the index", "/"+prefix)
+	}
+	for _, item := range items {
+		itemPath := path.Join(prefix, item.Filename)
+		err := fn(itemPath, item)
+		if item.IsFolder != 1 {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if err == SkipDir {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := x.walk(FolderID(item), itemPath, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Search finds the indexed items below folderID that match q, sorted by path
+func (x *Index) Search(folderID uint64, q SearchQuery) ([]SearchResult, error) {
+	collection := api.CollectionCloud
+	if x.data.Crypto {
+		collection = api.CollectionCrypto
+	}
+	var results []SearchResult
+	err := x.walk(folderID, "", func(itemPath string, item api.Item) error {
+		full := "/" + itemPath
+		if q.Match(full, item) {
+			results = append(results, SearchResult{Collection: collection, Path: full, Item: item})
+		}
+		return nil
+	})
+	sort.Slice(results, func(i, j int) bool { return results[i].Path < results[j].Path })
+	return results, err
+}
+
+// walkTree walks the index instead of the API if one is given for the collection
+func (c *Client) walkTree(idx *Index, folderID uint64, crypto bool, fn WalkFunc) error {
+	if idx != nil && idx.data.Crypto == crypto {
+		return idx.Walk(folderID, crypto, fn)
+	}
+	return c.Walk(folderID, crypto, fn)
+}
@@ -0,0 +1,357 @@
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/StarHack/go-icedrive/api"
+)
+
+// RekeyOptions configures RekeyCryptoVault
+type RekeyOptions struct {
+	// JournalPath is the local file that records progress so an interrupted
+	// re-key can be resumed by calling RekeyCryptoVault again with the same password
+	JournalPath string
+	// Progress, if set, is called before each item is processed
+	Progress func(itemPath string, item api.Item)
+}
+
+// rekeyJournal is the on-disk progress record of a re-key operation
+type rekeyJournal struct {
+	NewKeyCheck string                       `json:"new_key_check"`
+	Folders     map[string]*rekeyFolderState `json:"folders"`
+	Files       map[string]*rekeyFileState   `json:"files"`
+	NewUIDs     map[string]bool              `json:"new_uids"`
+	// HashScheme is the digest of the stored crypto hash, confirmed before the first change
+	HashScheme api.CryptoHashScheme `json:"hash_scheme"`
+	// HashUpdated is set once the server returns the hash of the new key, before the old copies are erased
+	HashUpdated bool `json:"hash_updated"`
+
+	path string
+	mu   sync.Mutex
+}
+
+type rekeyFolderState struct {
+	Name    string `json:"name"`
+	Renamed bool   `json:"renamed"`
+}
+
+type rekeyFileState struct {
+	Name     string `json:"name"`
+	NewUID   string `json:"new_uid,omitempty"`
+	SHA256   string `json:"sha256,omitempty"`
+	Verified bool   `json:"verified"`
+	// Trashed is set once the old copy is in the trash, Erased once it is
+	// removed for good after the new stored hash was confirmed
+	Trashed bool `json:"trashed"`
+	Erased  bool `json:"erased"`
+}
+
+func loadRekeyJournal(path, newKeyCheck string) (*rekeyJournal, error) {
+	j := &rekeyJournal{
+		NewKeyCheck: newKeyCheck,
+		Folders:     map[string]*rekeyFolderState{},
+		Files:       map[string]*rekeyFileState{},
+		NewUIDs:     map[string]bool{},
+		path:        path,
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return j, j.save()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, j); err != nil {
+		return nil, fmt.Errorf("corrupt re-key journal %s: %w", path, err)
+	}
+	if j.NewKeyCheck != newKeyCheck {
+		return nil, fmt.Errorf("re-key journal %s belongs to a different new password", path)
+	}
+	return j, nil
+}
+
+func (j *rekeyJournal) save() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := j.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, j.path)
+}
+
+// ErrRekeyUnverified is returned by RekeyCryptoVault when the current key does
+// not match the crypto hash stored on the server, so the hash of the new key
+// could not be stored in the same form
+var ErrRekeyUnverified = errors.New("current crypto key does not match the stored hash; refusing to re-key")
+
+// ErrRekeyHashNotStored is returned by RekeyCryptoVault when the crypto hash
+// read back from the server after the update is not the hash of the new key.
+// The old copies of the files stay in the trash.
+var ErrRekeyHashNotStored = errors.New("server did not store the new crypto hash; old copies are kept in the trash")
+
+// RekeyCryptoVault changes the crypto password by re-encrypting every folder
+// name, file name and file body in the encrypted collection under the key
+// derived from newCryptoPassword and the account's salt.
+//
+// Folders are renamed in place. Each file is streamed down, decrypted with the
+// current key, encrypted with the new key and uploaded next to the original;
+// the new copy is downloaded and compared by SHA-256 before the old copy is
+// moved to the trash. Then the crypto hash stored on the server is replaced by
+// the hash of the new key, so the Icedrive apps and SetCryptoPassword accept
+// the new password, and read back. Only once the server returns the new hash
+// are the old copies erased from the trash; otherwise ErrRekeyHashNotStored is
+// returned and they can still be restored. The format of the hash update is
+// inferred from GET /crypto-auth (see api.SetCryptoStoredHash), which is why
+// it is checked this way.
+//
+// Progress is journaled to opts.JournalPath so an interrupted run can be
+// resumed by setting the old password again and calling RekeyCryptoVault with
+// the same new password. On success the client switches to the new key.
+//
+// The stored hash must match the current key under a known scheme (see
+// api.MatchCryptoHashScheme); otherwise nothing is changed and
+// ErrRekeyUnverified is returned.
+func (c *Client) RekeyCryptoVault(newCryptoPassword string, opts RekeyOptions) error {
+	if err := c.defaultAuthChecks(true); err != nil {
+		return err
+	}
+	if opts.JournalPath == "" {
+		return errors.New("re-key requires a journal path")
+	}
+	if c.CryptoSalt == "" {
+		return errors.New("crypto salt unknown; call SetCryptoPassword first")
+	}
+	oldKey := c.CryptoHexKey
+	newKey, err := api.DeriveCryptoKey(newCryptoPassword, c.CryptoSalt)
+	if err != nil {
+		return err
+	}
+	if newKey == oldKey {
+		return errors.New("new crypto password is the same as the current one")
+	}
+	check := sha256.Sum256([]byte(newKey))
+	journal, err := loadRekeyJournal(opts.JournalPath, hex.EncodeToString(check[:]))
+	if err != nil {
+		return err
+	}
+	if journal.HashScheme == "" {
+		scheme, err := api.MatchCryptoHashScheme(oldKey, c.cryptoStoredHash)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrRekeyUnverified, err)
+		}
+		journal.HashScheme = scheme
+		if err := journal.save(); err != nil {
+			return err
+		}
+	}
+	newStored, err := api.CryptoKeyHash(newKey, journal.HashScheme)
+	if err != nil {
+		return err
+	}
+
+	if !journal.HashUpdated {
+		err = c.Walk(0, true, func(itemPath string, item api.Item) error {
+			if journal.NewUIDs[item.UID] {
+				return nil
+			}
+			if opts.Progress != nil {
+				opts.Progress(itemPath, item)
+			}
+			if item.IsFolder == 1 {
+				return c.rekeyFolder(journal, item, newKey)
+			}
+			return c.rekeyFile(journal, item.ParentID, item, oldKey, newKey)
+		})
+		if err != nil {
+			return err
+		}
+		err = c.pool.WithClient(func(h *api.HTTPClient) error {
+			if err := api.SetCryptoStoredHash(h, newStored, c.CryptoSalt); err != nil {
+				return err
+			}
+			stored, salt, err := api.FetchCryptoSaltAndStoredHash(h)
+			if err != nil {
+				return err
+			}
+			if salt != c.CryptoSalt || api.VerifyCryptoKey(newKey, stored) != nil {
+				return ErrRekeyHashNotStored
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("re-key: update stored crypto hash: %w", err)
+		}
+		journal.HashUpdated = true
+		if err := journal.save(); err != nil {
+			return err
+		}
+	}
+
+	c.cryptoStoredHash = newStored
+	c.cryptoVerified = true
+	c.CryptoHexKey = newKey
+	c.pool.SetCryptoKeyHex(newKey)
+	return c.eraseRekeyedOriginals(journal)
+}
+
+// eraseRekeyedOriginals removes the trashed old copies for good
+func (c *Client) eraseRekeyedOriginals(journal *rekeyJournal) error {
+	for uid, state := range journal.Files {
+		if !state.Trashed || state.Erased {
+			continue
+		}
+		if err := c.Delete(api.Item{UID: uid, Crypto: 1}); err != nil {
+			return fmt.Errorf("erase old copy of %q from the trash: %w", state.Name, err)
+		}
+		state.Erased = true
+		if err := journal.save(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) rekeyFolder(journal *rekeyJournal, item api.Item, newKey string) error {
+	state := journal.Folders[item.UID]
+	if state == nil {
+		// Remember the plain name before renaming: once renamed, listing with the old key yields garbage
+		state = &rekeyFolderState{Name: item.Filename}
+		journal.Folders[item.UID] = state
+		if err := journal.save(); err != nil {
+			return err
+		}
+	}
+	if state.Renamed {
+		return nil
+	}
+	err := c.pool.WithClient(func(h *api.HTTPClient) error {
+		return api.RenameFolderWithKey(h, item, state.Name, newKey)
+	})
+	if err != nil {
+		return fmt.Errorf("re-key folder %q: %w", state.Name, err)
+	}
+	state.Renamed = true
+	return journal.save()
+}
+
+func (c *Client) rekeyFile(journal *rekeyJournal, folderID uint64, item api.Item, oldKey, newKey string) error {
+	state := journal.Files[item.UID]
+	if state == nil {
+		state = &rekeyFileState{Name: item.Filename}
+		journal.Files[item.UID] = state
+		if err := journal.save(); err != nil {
+			return err
+		}
+	}
+	if state.Trashed {
+		return nil
+	}
+
+	if state.NewUID == "" {
+		newUID, sum, err := c.reencryptFile(folderID, item, state.Name, oldKey, newKey)
+		if err != nil {
+			return fmt.Errorf("re-key file %q: %w", state.Name, err)
+		}
+		state.NewUID = newUID
+		state.SHA256 = sum
+		journal.NewUIDs[newUID] = true
+		if err := journal.save(); err != nil {
+			return err
+		}
+	}
+
+	newItem := api.Item{UID: state.NewUID, Crypto: 1}
+	if !state.Verified {
+		sum, err := c.hashEncryptedWithKey(newItem, newKey)
+		if err != nil {
+			return fmt.Errorf("verify re-keyed file %q: %w", state.Name, err)
+		}
+		if sum != state.SHA256 {
+			// Discard the bad copy so the next run starts over for this file
+			_ = c.Delete(newItem)
+			delete(journal.NewUIDs, state.NewUID)
+			state.NewUID, state.SHA256 = "", ""
+			_ = journal.save()
+			return fmt.Errorf("verify re-keyed file %q: content mismatch", state.Name)
+		}
+		state.Verified = true
+		if err := journal.save(); err != nil {
+			return err
+		}
+	}
+
+	if err := c.TrashItem(item); err != nil {
+		return fmt.Errorf("trash old copy of %q: %w", state.Name, err)
+	}
+	state.Trashed = true
+	return journal.save()
+}
+
+// reencryptFile streams item down with oldKey and back up with newKey, returning the new UID and plaintext SHA-256
+func (c *Client) reencryptFile(folderID uint64, item api.Item, name, oldKey, newKey string) (string, string, error) {
+	size, err := c.GetPlainSize(item)
+	if err != nil {
+		return "", "", err
+	}
+
+	// One client serves both streams so that re-keying works with a pool of size 1
+	h := c.pool.Acquire()
+	defer c.pool.Release(h)
+	src, err := api.OpenDownloadStreamWithKey(h, item, oldKey)
+	if err != nil {
+		return "", "", err
+	}
+	defer src.Close()
+
+	w, err := api.NewUploadWriter(h, folderID, name, api.UploadOptions{
+		Moddate: time.Unix(int64(item.Moddate), 0),
+		Crypto:  true,
+		HexKey:  newKey,
+		Size:    size,
+	})
+	if err != nil {
+		return "", "", err
+	}
+	hasher := sha256.New()
+	if _, err := io.Copy(w, io.TeeReader(src, hasher)); err != nil {
+		_ = w.Close()
+		return "", "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", "", err
+	}
+	resp := w.Response()
+	if resp == nil {
+		return "", "", errors.New("upload response missing")
+	}
+	return UploadedUID(resp), hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// hashEncryptedWithKey downloads a crypto item with the given key and returns the SHA-256 of its plaintext
+func (c *Client) hashEncryptedWithKey(item api.Item, hexkey string) (string, error) {
+	h := c.pool.Acquire()
+	defer c.pool.Release(h)
+	r, err := api.OpenDownloadStreamWithKey(h, item, hexkey)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
@@ -0,0 +1,219 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/StarHack/go-icedrive/api"
+)
+
+// SearchQuery filters items; all set criteria must match. Size, extension and
+// file type criteria only match files.
+type SearchQuery struct {
+	// Name matches items whose name contains it, ignoring case
+	Name string
+	// Glob matches names against a path.Match pattern, ignoring case, e.g. "report-*.pdf"
+	Glob string
+	// Regexp matches the full path, e.g. "/Photos/2024/IMG_1234.jpg"
+	Regexp *regexp.Regexp
+	// Extensions and FileTypes match any of the given values, ignoring case; extensions without the dot
+	Extensions []string
+	FileTypes  []string
+	// MinSize and MaxSize bound the size in bytes; a MaxSize of 0 means no limit
+	MinSize uint64
+	MaxSize uint64
+	// ModifiedAfter and ModifiedBefore bound the modification time; zero means no limit
+	ModifiedAfter  time.Time
+	ModifiedBefore time.Time
+	// Favorites and Public only match items marked as favorite or shared publicly
+	Favorites bool
+	Public    bool
+	// Folders includes folders in the results, which otherwise only contain files
+	Folders bool
+}
+
+// SearchOptions selects where Search looks
+type SearchOptions struct {
+	// Cloud and Crypto select the plain and the encrypted collection; both may be set
+	Cloud  bool
+	Crypto bool
+	// FolderID limits the search to a folder and its subfolders, 0 is the root
+	FolderID uint64
+	// Concurrency is the number of folders listed in parallel, the pool size if <= 0
+	Concurrency int
+	// Index, if set, is searched instead of the API for the collection it covers
+	Index *Index
+}
+
+// SearchResult is an item found by Search
+type SearchResult struct {
+	Collection api.CollectionType
+	// Path is the full path below the searched folder, starting with "/"
+	Path string
+	Item api.Item
+}
+
+// Match reports whether an item at the full path itemPath matches the query
+func (q *SearchQuery) Match(itemPath string, item api.Item) bool {
+	isFolder := item.IsFolder == 1
+	if isFolder && !q.Folders {
+		return false
+	}
+	name := strings.ToLower(item.Filename)
+	if q.Name != "" && !strings.Contains(name, strings.ToLower(q.Name)) {
+		return false
+	}
+	if q.Glob != "" {
+		if ok, _ := path.Match(strings.ToLower(q.Glob), name); !ok {
+			return false
+		}
+	}
+	if q.Regexp != nil && !q.Regexp.MatchString(itemPath) {
+		return false
+	}
+	if q.Favorites && item.Fave != 1 {
+		return false
+	}
+	if q.Public && item.IsPublic != 1 {
+		return false
+	}
+	mod := time.Unix(int64(item.Moddate), 0)
+	if !q.ModifiedAfter.IsZero() && mod.Before(q.ModifiedAfter) {
+		return false
+	}
+	if !q.ModifiedBefore.IsZero() && !mod.Before(q.ModifiedBefore) {
+		return false
+	}
+	if isFolder {
+		return len(q.Extensions) == 0 && len(q.FileTypes) == 0 && q.MinSize == 0 && q.MaxSize == 0
+	}
+	if len(q.Extensions) > 0 && !containsFold(q.Extensions, strings.TrimPrefix(path.Ext(item.Filename), ".")) {
+		return false
+	}
+	if len(q.FileTypes) > 0 && !containsFold(q.FileTypes, item.FileType) {
+		return false
+	}
+	if item.Filesize < q.MinSize || (q.MaxSize > 0 && item.Filesize > q.MaxSize) {
+		return false
+	}
+	return true
+}
+
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(strings.TrimPrefix(v, "."), s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Search finds the items below opts.FolderID that match q. Icedrive offers no
+// search endpoint, so the tree is walked, listing several folders at once.
+// Results are sorted by collection and path. Folders that could not be listed
+// are reported in the returned error along with the results found elsewhere.
+func (c *Client) Search(q SearchQuery, opts SearchOptions) ([]SearchResult, error) {
+	if !opts.Cloud && !opts.Crypto {
+		return nil, errors.New("select at least one collection")
+	}
+	if q.Glob != "" {
+		if _, err := path.Match(q.Glob, ""); err != nil {
+			return nil, fmt.Errorf("invalid glob %q: %w", q.Glob, err)
+		}
+	}
+	if err := c.defaultAuthChecks(opts.Crypto); err != nil {
+		return nil, err
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = c.PoolSize()
+	}
+
+	var results []SearchResult
+	var errs []error
+	var mu sync.Mutex
+	search := func(cType api.CollectionType, crypto bool) {
+		match := func(itemPath string, item api.Item) {
+			full := "/" + itemPath
+			if q.Match(full, item) {
+				mu.Lock()
+				results = append(results, SearchResult{Collection: cType, Path: full, Item: item})
+				mu.Unlock()
+			}
+		}
+		var err error
+		if opts.Index != nil && opts.Index.data.Crypto == crypto {
+			err = opts.Index.Walk(opts.FolderID, crypto, func(itemPath string, item api.Item) error {
+				match(itemPath, item)
+				return nil
+			})
+		} else {
+			err = c.walkParallel(opts.FolderID, crypto, opts.Concurrency, match)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", cType, err))
+		}
+	}
+	if opts.Cloud {
+		search(api.CollectionCloud, false)
+	}
+	if opts.Crypto {
+		search(api.CollectionCrypto, true)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Collection != results[j].Collection {
+			return results[i].Collection < results[j].Collection
+		}
+		return results[i].Path < results[j].Path
+	})
+	return results, errors.Join(errs...)
+}
+
+// walkParallel visits every item below folderID like Walk, but lists up to
+// concurrency folders at once. fn is called from several goroutines and in no
+// particular order. Folders that cannot be listed are skipped and their errors
+// returned together at the end.
+func (c *Client) walkParallel(folderID uint64, crypto bool, concurrency int, fn func(itemPath string, item api.Item)) error {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs []error
+	slots := make(chan struct{}, concurrency)
+
+	var visit func(id uint64, prefix string)
+	visit = func(id uint64, prefix string) {
+		defer wg.Done()
+		slots <- struct{}{}
+		var items []api.Item
+		var err error
+		if crypto {
+			items, err = c.ListFolderEncrypted(id)
+		} else {
+			items, err = c.ListFolder(id)
+		}
+		<-slots
+		if err != nil {
+			mu.Lock()
+			errs = append(errs, fmt.Errorf("list %q: %w", "/"+prefix, err))
+			mu.Unlock()
+			return
+		}
+		for _, item := range items {
+			itemPath := path.Join(prefix, item.Filename)
+			fn(itemPath, item)
+			if item.IsFolder == 1 {
+				wg.Add(1)
+				go visit(FolderID(item), itemPath)
+			}
+		}
+	}
+	wg.Add(1)
+	visit(folderID, "")
+	wg.Wait()
+	return errors.Join(errs...)
+}
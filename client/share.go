@@ -0,0 +1,203 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/StarHack/go-icedrive/api"
+)
+
+// CreatePublicLink shares a plain file or folder through a public link,
+// optionally protected by a password and limited in time. Calling it again
+// for a shared item updates password and expiry.
+func (c *Client) CreatePublicLink(item api.Item, opts api.PublicLinkOptions) (*api.PublicLink, error) {
+	if err := c.defaultAuthChecks(false); err != nil {
+		return nil, err
+	}
+	var link *api.PublicLink
+	err := c.pool.WithClient(func(h *api.HTTPClient) error {
+		var err error
+		link, err = api.CreatePublicLink(h, item, opts)
+		return err
+	})
+	return link, err
+}
+
+// RevokePublicLinks removes the public links of items in a single request
+func (c *Client) RevokePublicLinks(items ...api.Item) error {
+	if err := c.defaultAuthChecks(false); err != nil {
+		return err
+	}
+	return c.pool.WithClient(func(h *api.HTTPClient) error {
+		return api.RemovePublicLinks(h, items...)
+	})
+}
+
+// ListPublicLinks returns all public links of the account
+func (c *Client) ListPublicLinks() ([]api.PublicLink, error) {
+	if err := c.defaultAuthChecks(false); err != nil {
+		return nil, err
+	}
+	var links []api.PublicLink
+	err := c.pool.WithClient(func(h *api.HTTPClient) error {
+		var err error
+		links, err = api.ListPublicLinks(h)
+		return err
+	})
+	return links, err
+}
+
+// PublicLink returns the link metadata of a shared item: URL, password
+// protection, expiry and download count
+func (c *Client) PublicLink(item api.Item) (*api.PublicLink, error) {
+	if err := c.defaultAuthChecks(false); err != nil {
+		return nil, err
+	}
+	var link *api.PublicLink
+	err := c.pool.WithClient(func(h *api.HTTPClient) error {
+		var err error
+		link, err = api.GetPublicLink(h, item)
+		return err
+	})
+	return link, err
+}
+
+// PublicDownloader fetches the content of a public link without an account.
+// It is not safe for concurrent use.
+type PublicDownloader struct {
+	h        *api.HTTPClient
+	shareID  string
+	password string
+}
+
+// NewPublicDownloader prepares downloads from a public link or bare share ID;
+// password is only needed for protected links
+func NewPublicDownloader(link, password string) (*PublicDownloader, error) {
+	id, err := api.ParsePublicLink(link)
+	if err != nil {
+		return nil, err
+	}
+	h := api.NewHTTPClientWithEnv()
+	h.SetApiBase(defaultAPIBase)
+	h.SetHeaders(defaultHeaders)
+	return &PublicDownloader{h: h, shareID: id, password: password}, nil
+}
+
+// SetAPIBase points the downloader at another API endpoint, e.g. a proxy or a test server
+func (d *PublicDownloader) SetAPIBase(apiBase string) {
+	d.h.SetApiBase(apiBase)
+}
+
+// ShareID returns the ID of the public link
+func (d *PublicDownloader) ShareID() string {
+	return d.shareID
+}
+
+// List returns the items of a shared folder; folderID 0 lists the shared item
+// itself, which is a single file for shared files
+func (d *PublicDownloader) List(folderID uint64) ([]api.Item, error) {
+	resp, err := api.GetPublicShare(d.h, d.shareID, d.password, folderID)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
+
+// Open streams a file of the share, decompressing compressed uploads
+func (d *PublicDownloader) Open(item api.Item) (io.ReadCloser, error) {
+	urls, err := api.GetPublicDownloadURLs(d.h, d.shareID, d.password, item)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := api.OpenDownloadURL(d.h, urls[0].URL, "")
+	if err != nil {
+		return nil, err
+	}
+	if IsCompressed(item) {
+		return newDecompressReader(rc)
+	}
+	return rc, nil
+}
+
+// Download stores a file of the share in destDir, keeping its modification
+// time. Names that would leave destDir are refused.
+func (d *PublicDownloader) Download(item api.Item, destDir string) error {
+	if !localSafe(item) {
+		return fmt.Errorf("unsafe name %q", item.Filename)
+	}
+	if err := os.MkdirAll(destDir, 0o755); err != nil {
+		return err
+	}
+	rc, err := d.Open(item)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	_, err = writeLocalFile(filepath.Join(destDir, localName(item, rc)), rc, item)
+	return err
+}
+
+// DownloadAll recursively downloads the whole share into destDir. Files that
+// fail are recorded in the summary and the download continues with the rest;
+// files and folders whose names would leave destDir are recorded as failed.
+func (d *PublicDownloader) DownloadAll(destDir string) (*DownloadDirSummary, error) {
+	summary := &DownloadDirSummary{}
+	if err := d.downloadFolder(0, "", destDir, summary); err != nil {
+		return summary, err
+	}
+	return summary, nil
+}
+
+func (d *PublicDownloader) downloadFolder(folderID uint64, prefix, localDir string, summary *DownloadDirSummary) error {
+	items, err := d.List(folderID)
+	if err != nil {
+		return fmt.Errorf("list %q: %w", "/"+prefix, err)
+	}
+	if err := os.MkdirAll(localDir, 0o755); err != nil {
+		return err
+	}
+	for _, item := range items {
+		if !localSafe(item) {
+			summary.add(DownloadResult{RemotePath: path.Join(prefix, item.Filename), Status: DownloadStatusFailed, Err: fmt.Errorf("unsafe name %q", item.Filename)})
+			continue
+		}
+		if item.IsFolder == 1 {
+			if err := d.downloadFolder(FolderID(item), path.Join(prefix, item.Filename), filepath.Join(localDir, item.Filename), summary); err != nil {
+				return err
+			}
+			continue
+		}
+		name := DecompressedName(item)
+		result := DownloadResult{
+			RemotePath: path.Join(prefix, name),
+			LocalPath:  filepath.Join(localDir, name),
+			Status:     DownloadStatusFailed,
+		}
+		rc, err := d.Open(item)
+		if err == nil {
+			if name = localName(item, rc); name != DecompressedName(item) {
+				result.RemotePath = path.Join(prefix, name)
+				result.LocalPath = filepath.Join(localDir, name)
+			}
+			result.Size, err = writeLocalFile(result.LocalPath, rc, item)
+			rc.Close()
+		}
+		if err != nil {
+			result.Err = err
+		} else {
+			result.Status = DownloadStatusDownloaded
+		}
+		summary.add(result)
+	}
+	return nil
+}
+
+// localSafe reports whether the name of a shared item, with and without
+// CompressedSuffix, stays inside the directory it is downloaded to. Names
+// come from whoever shared the item and are not trusted.
+func localSafe(item api.Item) bool {
+	return filepath.IsLocal(item.Filename) && filepath.IsLocal(DecompressedName(item))
+}
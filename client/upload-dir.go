@@ -0,0 +1,358 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/StarHack/go-icedrive/api"
+)
+
+// UploadDirOptions configures UploadDir
+type UploadDirOptions struct {
+	// Crypto uploads into the encrypted collection
+	Crypto bool
+	// Concurrency is the number of parallel uploads, the pool size if <= 0
+	Concurrency int
+	// Include, if not empty, limits uploads to files whose relative path or name matches one of the globs
+	Include []string
+	// Exclude skips files and folders whose relative path or name matches one of the globs
+	Exclude []string
+	// NoIgnoreFile disables reading IgnoreFileName files
+	NoIgnoreFile bool
+	// Conflict decides what happens to files that already exist remotely.
+	// Files skipped under api.UploadSkipIdentical are reported as skipped.
+	Conflict api.UploadConflictPolicy
+	// Progress, if set, is called after each file, possibly from several goroutines at once
+	Progress func(UploadResult)
+}
+
+// UploadStatus is the outcome of a single file in UploadDir
+type UploadStatus string
+
+const (
+	UploadStatusUploaded UploadStatus = "uploaded"
+	UploadStatusSkipped  UploadStatus = "skipped"
+	UploadStatusFailed   UploadStatus = "failed"
+)
+
+// UploadResult describes what happened to one local file or folder
+type UploadResult struct {
+	LocalPath  string
+	RemotePath string // slash-separated, relative to the target folder
+	Size       int64
+	Status     UploadStatus
+	UID        string // of the uploaded file
+	Err        error
+}
+
+// UploadDirSummary collects the per-file results of UploadDir, sorted by LocalPath
+type UploadDirSummary struct {
+	Results  []UploadResult
+	Uploaded int
+	Skipped  int
+	Failed   int
+	Bytes    int64 // total size of uploaded files
+}
+
+// Err joins the errors of all failed results, nil if nothing failed
+func (s *UploadDirSummary) Err() error {
+	var errs []error
+	for _, r := range s.Results {
+		if r.Status == UploadStatusFailed {
+			errs = append(errs, fmt.Errorf("%s: %w", r.LocalPath, r.Err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *UploadDirSummary) add(r UploadResult) {
+	s.Results = append(s.Results, r)
+	switch r.Status {
+	case UploadStatusUploaded:
+		s.Uploaded++
+		s.Bytes += r.Size
+	case UploadStatusSkipped:
+		s.Skipped++
+	case UploadStatusFailed:
+		s.Failed++
+	}
+}
+
+type uploadDirJob struct {
+	localPath string
+	relPath   string
+	folderID  uint64
+	size      int64
+}
+
+// UploadDir mirrors the contents of localDir into the remote folder folderID.
+// Missing subfolders are created when the first file below them is uploaded,
+// so folders that are empty or whose files are all filtered out are not
+// created; existing ones are reused. Files are uploaded concurrently through
+// the pool with their modification times preserved. Failures of single files
+// don't stop the upload; they are reported in the summary, whose Err method
+// combines them. The returned error is only set if the upload could not start.
+func (c *Client) UploadDir(localDir string, folderID uint64, opts UploadDirOptions) (*UploadDirSummary, error) {
+	if err := c.defaultAuthChecks(opts.Crypto); err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(localDir)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", localDir)
+	}
+	workers := opts.Concurrency
+	if workers <= 0 {
+		workers = c.pool.Size()
+	}
+
+	summary := &UploadDirSummary{}
+	var mu sync.Mutex
+	record := func(r UploadResult) {
+		mu.Lock()
+		summary.add(r)
+		mu.Unlock()
+		if opts.Progress != nil {
+			opts.Progress(r)
+		}
+	}
+
+	jobs := make(chan uploadDirJob)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				record(c.uploadDirFile(job, opts.Crypto, opts.Conflict))
+			}
+		}()
+	}
+
+	folders := newRemoteFolders(c, opts.Crypto)
+	folderIDs := map[string]uint64{".": folderID}
+	folderErrs := map[string]error{}
+	rules := map[string]ignoreRules{}
+
+	// remoteFolder returns the ID of the remote folder for rel, creating it and
+	// its parents on first use. A failure is recorded once for the folder.
+	var remoteFolder func(rel string) (uint64, error)
+	remoteFolder = func(rel string) (uint64, error) {
+		if id, ok := folderIDs[rel]; ok {
+			return id, nil
+		}
+		if err, ok := folderErrs[rel]; ok {
+			return 0, err
+		}
+		id, err := remoteFolder(path.Dir(rel))
+		if err == nil {
+			id, err = folders.ensure(id, path.Base(rel))
+		}
+		if err != nil {
+			if _, ok := folderErrs[path.Dir(rel)]; !ok {
+				record(UploadResult{LocalPath: filepath.Join(localDir, filepath.FromSlash(rel)), RemotePath: rel, Status: UploadStatusFailed, Err: err})
+			}
+			folderErrs[rel] = err
+			return 0, err
+		}
+		folderIDs[rel] = id
+		return id, nil
+	}
+
+	walkErr := filepath.WalkDir(localDir, func(p string, d fs.DirEntry, err error) error {
+		rel, relErr := filepath.Rel(localDir, p)
+		if relErr != nil {
+			return relErr
+		}
+		rel = filepath.ToSlash(rel)
+		if err != nil {
+			record(UploadResult{LocalPath: p, RemotePath: rel, Status: UploadStatusFailed, Err: err})
+			if d != nil && d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		parent := path.Dir(rel)
+
+		if d.IsDir() {
+			if rel != "." {
+				if matchAnyGlob(opts.Exclude, rel) || rules[parent].ignored(rel, true) {
+					return fs.SkipDir
+				}
+			}
+			r := rules[parent]
+			if !opts.NoIgnoreFile {
+				relDir := rel
+				if relDir == "." {
+					relDir = ""
+				}
+				if r, err = r.loadIgnoreFile(p, relDir); err != nil {
+					record(UploadResult{LocalPath: p, RemotePath: rel, Status: UploadStatusFailed, Err: err})
+					return fs.SkipDir
+				}
+			}
+			rules[rel] = r
+			return nil
+		}
+
+		if (!opts.NoIgnoreFile && d.Name() == IgnoreFileName) || matchAnyGlob(opts.Exclude, rel) || rules[parent].ignored(rel, false) {
+			return nil
+		}
+		if len(opts.Include) > 0 && !matchAnyGlob(opts.Include, rel) {
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			record(UploadResult{LocalPath: p, RemotePath: rel, Status: UploadStatusSkipped, Err: errors.New("not a regular file")})
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			record(UploadResult{LocalPath: p, RemotePath: rel, Status: UploadStatusFailed, Err: err})
+			return nil
+		}
+		parentID, err := remoteFolder(parent)
+		if err != nil {
+			// The failure was recorded for the folder
+			return nil
+		}
+		jobs <- uploadDirJob{localPath: p, relPath: rel, folderID: parentID, size: info.Size()}
+		return nil
+	})
+	close(jobs)
+	wg.Wait()
+
+	sort.Slice(summary.Results, func(i, j int) bool {
+		return summary.Results[i].LocalPath < summary.Results[j].LocalPath
+	})
+	return summary, walkErr
+}
+
+func (c *Client) uploadDirFile(job uploadDirJob, crypto bool, conflict api.UploadConflictPolicy) UploadResult {
+	result := UploadResult{LocalPath: job.localPath, RemotePath: job.relPath, Size: job.size, Status: UploadStatusFailed}
+	if err := c.ensureTokenFresh(c.uploadTokenMargin); err != nil {
+		result.Err = err
+		return result
+	}
+	resp, err := c.uploadLocalFile(job.folderID, job.localPath, crypto, conflict)
+	if errors.Is(err, api.ErrUploadSkipped) {
+		result.Status, result.Err = UploadStatusSkipped, err
+		return result
+	}
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	result.Status = UploadStatusUploaded
+	result.UID = UploadedUID(resp)
+	return result
+}
+
+// UploadedUID returns the UID of an uploaded file, built from its ID if the response lacks it
+func UploadedUID(resp *api.UploadResponse) string {
+	if resp == nil {
+		return ""
+	}
+	if resp.FileObj.UID != "" {
+		return resp.FileObj.UID
+	}
+	return "file-" + strconv.FormatUint(resp.FileObj.ID, 10)
+}
+
+// MkdirAll returns the ID of the folder at the slash-separated relPath below
+// parentID, creating missing folders along the way
+func (c *Client) MkdirAll(parentID uint64, relPath string, crypto bool) (uint64, error) {
+	if err := c.defaultAuthChecks(crypto); err != nil {
+		return 0, err
+	}
+	folders := newRemoteFolders(c, crypto)
+	id := parentID
+	for _, name := range strings.Split(path.Clean(relPath), "/") {
+		if name == "." || name == "" {
+			continue
+		}
+		var err error
+		if id, err = folders.ensure(id, name); err != nil {
+			return 0, err
+		}
+	}
+	return id, nil
+}
+
+// remoteFolders finds or creates subfolders by name, caching folder listings
+type remoteFolders struct {
+	c        *Client
+	crypto   bool
+	listings map[uint64][]api.Item
+}
+
+func newRemoteFolders(c *Client, crypto bool) *remoteFolders {
+	return &remoteFolders{c: c, crypto: crypto, listings: map[uint64][]api.Item{}}
+}
+
+func (rf *remoteFolders) list(parentID uint64) ([]api.Item, error) {
+	if items, ok := rf.listings[parentID]; ok {
+		return items, nil
+	}
+	var items []api.Item
+	var err error
+	if rf.crypto {
+		items, err = rf.c.ListFolderEncrypted(parentID)
+	} else {
+		items, err = rf.c.ListFolder(parentID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rf.listings[parentID] = items
+	return items, nil
+}
+
+func (rf *remoteFolders) find(parentID uint64, name string) (uint64, bool, error) {
+	items, err := rf.list(parentID)
+	if err != nil {
+		return 0, false, err
+	}
+	for _, item := range items {
+		if item.IsFolder == 1 && item.Filename == name {
+			return FolderID(item), true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// ensure returns the ID of the folder name in parentID, creating it if needed
+func (rf *remoteFolders) ensure(parentID uint64, name string) (uint64, error) {
+	if id, ok, err := rf.find(parentID, name); err != nil || ok {
+		return id, err
+	}
+	var err error
+	if rf.crypto {
+		err = rf.c.CreateFolderEncrypted(parentID, name)
+	} else {
+		err = rf.c.CreateFolder(parentID, name)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("create folder %q: %w", name, err)
+	}
+	delete(rf.listings, parentID)
+	id, ok, err := rf.find(parentID, name)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, fmt.Errorf("created folder %q not found", name)
+	}
+	// A new folder is empty, no need to list it later
+	rf.listings[id] = nil
+	return id, nil
+}
@@ -0,0 +1,317 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/StarHack/go-icedrive/api"
+)
+
+// defaultUsageTop is the length of the largest files and folders lists
+const defaultUsageTop = 20
+
+// UsageOptions selects what DiskUsage examines
+type UsageOptions struct {
+	// Cloud, Crypto and Trash select the plain collection, the encrypted one and the trash
+	Cloud  bool
+	Crypto bool
+	Trash  bool
+	// FolderID limits the collections to a folder and its subfolders, 0 is the root
+	FolderID uint64
+	// Top is the number of largest files and folders reported, 20 if <= 0
+	Top int
+	// Index, if set, is walked instead of the API for the collection it covers
+	Index *Index
+}
+
+// UsageNode is a folder with the space used by its files and subfolders
+type UsageNode struct {
+	Name     string       `json:"name"`
+	Path     string       `json:"path"`
+	Size     uint64       `json:"size"`
+	Files    int          `json:"files"`
+	Folders  int          `json:"folders"`
+	Children []*UsageNode `json:"children,omitempty"`
+
+	own uint64 // size of the files directly inside
+}
+
+// UsageEntry is a file or folder in a largest-first list
+type UsageEntry struct {
+	Collection api.CollectionType `json:"collection"`
+	Path       string             `json:"path"`
+	Size       uint64             `json:"size"`
+}
+
+// TypeUsage is the space used by files of one type or extension
+type TypeUsage struct {
+	Key   string `json:"key"`
+	Files int    `json:"files"`
+	Size  uint64 `json:"size"`
+}
+
+// Reconciliation compares the counted usage with the account's storage
+// statistics. The difference is space the listings don't show, such as older
+// file versions; it is only meaningful for a report covering the whole account.
+type Reconciliation struct {
+	StorageUsed uint64 `json:"storage_used"`
+	Counted     uint64 `json:"counted"`
+	Difference  int64  `json:"difference"`
+}
+
+// UsageReport is the result of DiskUsage. Sizes are as listed by the server.
+type UsageReport struct {
+	Cloud  *UsageNode `json:"cloud,omitempty"`
+	Crypto *UsageNode `json:"crypto,omitempty"`
+	// TrashSize and TrashItems cover the top level of the trash, folders included
+	TrashSize  uint64 `json:"trash_size"`
+	TrashItems int    `json:"trash_items"`
+
+	LargestFiles   []UsageEntry `json:"largest_files"`
+	LargestFolders []UsageEntry `json:"largest_folders"`
+	ByFileType     []TypeUsage  `json:"by_file_type"`
+	ByExtension    []TypeUsage  `json:"by_extension"`
+
+	Reconciliation Reconciliation `json:"reconciliation"`
+}
+
+// DiskUsage walks the selected collections and the trash and reports where
+// the space goes: a folder tree with total sizes, the largest files and
+// folders, a breakdown by file type and extension, and a reconciliation
+// against the storage used according to GetUserStats.
+func (c *Client) DiskUsage(opts UsageOptions) (*UsageReport, error) {
+	if !opts.Cloud && !opts.Crypto && !opts.Trash {
+		return nil, errors.New("select at least one of cloud, crypto and trash")
+	}
+	if err := c.defaultAuthChecks(opts.Crypto); err != nil {
+		return nil, err
+	}
+	if opts.Top <= 0 {
+		opts.Top = defaultUsageTop
+	}
+
+	report := &UsageReport{}
+	var files, folders []UsageEntry
+	byType := map[string]*TypeUsage{}
+	byExt := map[string]*TypeUsage{}
+	count := func(m map[string]*TypeUsage, key string, size uint64) {
+		if key == "" {
+			key = "(none)"
+		}
+		t, ok := m[key]
+		if !ok {
+			t = &TypeUsage{Key: key}
+			m[key] = t
+		}
+		t.Files++
+		t.Size += size
+	}
+
+	scan := func(cType api.CollectionType, crypto bool) (*UsageNode, error) {
+		root := &UsageNode{Name: string(cType), Path: "/"}
+		nodes := map[string]*UsageNode{".": root}
+		err := c.walkTree(opts.Index, opts.FolderID, crypto, func(itemPath string, item api.Item) error {
+			parent := nodes[path.Dir(itemPath)]
+			if item.IsFolder == 1 {
+				node := &UsageNode{Name: item.Filename, Path: "/" + itemPath}
+				nodes[itemPath] = node
+				parent.Children = append(parent.Children, node)
+				return nil
+			}
+			parent.own += item.Filesize
+			parent.Files++
+			files = append(files, UsageEntry{Collection: cType, Path: "/" + itemPath, Size: item.Filesize})
+			count(byType, item.FileType, item.Filesize)
+			count(byExt, strings.ToLower(strings.TrimPrefix(path.Ext(item.Filename), ".")), item.Filesize)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		root.total()
+		for p, node := range nodes {
+			if p != "." {
+				folders = append(folders, UsageEntry{Collection: cType, Path: node.Path, Size: node.Size})
+			}
+		}
+		return root, nil
+	}
+
+	var err error
+	if opts.Cloud {
+		if report.Cloud, err = scan(api.CollectionCloud, false); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Crypto {
+		if report.Crypto, err = scan(api.CollectionCrypto, true); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Trash {
+		if err := c.trashUsage(report); err != nil {
+			return nil, err
+		}
+	}
+
+	report.LargestFiles = largestEntries(files, opts.Top)
+	report.LargestFolders = largestEntries(folders, opts.Top)
+	report.ByFileType = sortedTypeUsage(byType)
+	report.ByExtension = sortedTypeUsage(byExt)
+
+	stats, err := c.GetUserStats()
+	if err != nil {
+		return nil, fmt.Errorf("user stats: %w", err)
+	}
+	counted := report.TrashSize
+	for _, root := range []*UsageNode{report.Cloud, report.Crypto} {
+		if root != nil {
+			counted += root.Size
+		}
+	}
+	report.Reconciliation = Reconciliation{
+		StorageUsed: stats.Storage.Used,
+		Counted:     counted,
+		Difference:  int64(stats.Storage.Used) - int64(counted),
+	}
+	return report, nil
+}
+
+// trashUsage adds up the trash. Trashed folders are not listed with their
+// contents, so their size is taken from their folder properties.
+func (c *Client) trashUsage(report *UsageReport) error {
+	items, err := c.ListFolderTrash(0)
+	if err != nil {
+		return fmt.Errorf("list trash: %w", err)
+	}
+	for _, item := range items {
+		report.TrashItems++
+		if item.IsFolder != 1 {
+			report.TrashSize += item.Filesize
+			continue
+		}
+		props, err := c.GetFolderProperties(item.UID, item.Crypto == 1)
+		if err != nil {
+			return fmt.Errorf("trashed folder %s: %w", item.Filename, err)
+		}
+		report.TrashSize += props.TotalSize
+	}
+	return nil
+}
+
+// total fills in the sizes and counts including subfolders and sorts children largest first
+func (n *UsageNode) total() {
+	n.Size = n.own
+	for _, child := range n.Children {
+		child.total()
+		n.Size += child.Size
+		n.Files += child.Files
+		n.Folders += child.Folders + 1
+	}
+	sort.Slice(n.Children, func(i, j int) bool {
+		if n.Children[i].Size != n.Children[j].Size {
+			return n.Children[i].Size > n.Children[j].Size
+		}
+		return n.Children[i].Name < n.Children[j].Name
+	})
+}
+
+func largestEntries(entries []UsageEntry, top int) []UsageEntry {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Size != entries[j].Size {
+			return entries[i].Size > entries[j].Size
+		}
+		return entries[i].Path < entries[j].Path
+	})
+	if len(entries) > top {
+		entries = entries[:top]
+	}
+	return entries
+}
+
+func sortedTypeUsage(m map[string]*TypeUsage) []TypeUsage {
+	out := make([]TypeUsage, 0, len(m))
+	for _, t := range m {
+		out = append(out, *t)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Size != out[j].Size {
+			return out[i].Size > out[j].Size
+		}
+		return out[i].Key < out[j].Key
+	})
+	return out
+}
+
+// WriteJSON writes the report as indented JSON
+func (r *UsageReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteTree writes the folder trees largest first, down to maxDepth levels
+// below each collection (all levels if maxDepth <= 0), followed by the
+// largest files, the type breakdown and the reconciliation
+func (r *UsageReport) WriteTree(w io.Writer, maxDepth int) error {
+	var b strings.Builder
+	var writeNode func(n *UsageNode, depth int)
+	writeNode = func(n *UsageNode, depth int) {
+		fmt.Fprintf(&b, "%10s  %s%s (%d files)\n", FormatSize(n.Size), strings.Repeat("  ", depth), n.Name, n.Files)
+		if maxDepth > 0 && depth >= maxDepth {
+			return
+		}
+		for _, child := range n.Children {
+			writeNode(child, depth+1)
+		}
+	}
+	for _, root := range []*UsageNode{r.Cloud, r.Crypto} {
+		if root != nil {
+			writeNode(root, 0)
+		}
+	}
+	if r.TrashItems > 0 {
+		fmt.Fprintf(&b, "%10s  trash (%d items)\n", FormatSize(r.TrashSize), r.TrashItems)
+	}
+
+	if len(r.LargestFiles) > 0 {
+		b.WriteString("\nLargest files:\n")
+		for _, e := range r.LargestFiles {
+			fmt.Fprintf(&b, "%10s  %s:%s\n", FormatSize(e.Size), e.Collection, e.Path)
+		}
+	}
+	if len(r.ByFileType) > 0 {
+		b.WriteString("\nBy file type:\n")
+		for _, t := range r.ByFileType {
+			fmt.Fprintf(&b, "%10s  %s (%d files)\n", FormatSize(t.Size), t.Key, t.Files)
+		}
+	}
+
+	rc := r.Reconciliation
+	fmt.Fprintf(&b, "\nCounted %s of %s used", FormatSize(rc.Counted), FormatSize(rc.StorageUsed))
+	if rc.Difference > 0 {
+		fmt.Fprintf(&b, ", %s not in listings (e.g. file versions)", FormatSize(uint64(rc.Difference)))
+	}
+	b.WriteByte('\n')
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// FormatSize renders a byte count with binary units, e.g. "1.5 MiB"
+func FormatSize(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
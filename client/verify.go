@@ -0,0 +1,157 @@
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/StarHack/go-icedrive/api"
+)
+
+// VerifyMode selects how uploads are checked after the server accepted them
+type VerifyMode int
+
+const (
+	// VerifyNone trusts the server's answer
+	VerifyNone VerifyMode = iota
+	// VerifySize compares the plain size of the stored file with the bytes sent
+	VerifySize
+	// VerifyContent downloads the stored file again, decrypting it if needed,
+	// and compares its SHA-256 with the hash of the bytes sent
+	VerifyContent
+)
+
+// ErrIntegrity matches every *IntegrityError with errors.Is
+var ErrIntegrity = errors.New("upload integrity check failed")
+
+// IntegrityError reports a stored file that differs from what was uploaded.
+// The hashes are only set by VerifyContent.
+type IntegrityError struct {
+	Name         string
+	UID          string
+	Size         int64
+	StoredSize   int64
+	SHA256       string
+	StoredSHA256 string
+}
+
+func (e *IntegrityError) Error() string {
+	if e.Size != e.StoredSize {
+		return fmt.Sprintf("%s: uploaded %d bytes but %d are stored", e.Name, e.Size, e.StoredSize)
+	}
+	return fmt.Sprintf("%s: stored content has SHA-256 %s, expected %s", e.Name, e.StoredSHA256, e.SHA256)
+}
+
+func (e *IntegrityError) Is(target error) bool {
+	return target == ErrIntegrity
+}
+
+// SetUploadVerification makes UploadFile, UploadFileEncrypted, UploadReader
+// and UploadDir check every upload with mode. A mismatch is returned as an
+// *IntegrityError; the stored file is left in place. Upload writers are not verified.
+func (c *Client) SetUploadVerification(mode VerifyMode) {
+	c.verifyUploads = mode
+}
+
+// VerifyUpload checks the stored file item against the size and hex SHA-256
+// of its plaintext. An empty sum only compares the size.
+func (c *Client) VerifyUpload(item api.Item, size int64, sum string) error {
+	if err := c.defaultAuthChecks(item.Crypto == 1); err != nil {
+		return err
+	}
+	mode := VerifyContent
+	if sum == "" {
+		mode = VerifySize
+	}
+	hexkey := ""
+	if item.Crypto == 1 {
+		hexkey = c.CryptoHexKey
+	}
+	return c.verifyStored(item, hexkey, mode, size, sum)
+}
+
+func (c *Client) verifyStored(item api.Item, hexkey string, mode VerifyMode, size int64, sum string) error {
+	mismatch := &IntegrityError{Name: item.Filename, UID: item.UID, Size: size, SHA256: sum}
+	if mode == VerifySize {
+		stored, err := c.GetPlainSize(item)
+		if err != nil {
+			return fmt.Errorf("verify %s: %w", item.Filename, err)
+		}
+		if stored != size {
+			mismatch.StoredSize = stored
+			return mismatch
+		}
+		return nil
+	}
+
+	h := sha256.New()
+	var stored int64
+	err := c.pool.WithClient(func(hc *api.HTTPClient) error {
+		rc, err := api.OpenDownloadStreamWithKey(hc, item, hexkey)
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		stored, err = io.Copy(h, rc)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("verify %s: %w", item.Filename, err)
+	}
+	mismatch.StoredSize = stored
+	mismatch.StoredSHA256 = hex.EncodeToString(h.Sum(nil))
+	if stored != size || mismatch.StoredSHA256 != sum {
+		return mismatch
+	}
+	if c.hashCache != nil && !IsCompressed(item) {
+		c.hashCache.Put(item, sum)
+	}
+	return nil
+}
+
+// uploadVerified uploads r like uploadFrom and checks the result as set by SetUploadVerification
+func (c *Client) uploadVerified(folderID uint64, name string, r io.Reader, opts api.UploadOptions) (*api.UploadResponse, error) {
+	if c.verifyUploads == VerifyNone {
+		return c.uploadFrom(folderID, name, r, opts)
+	}
+	sent := &hashCounter{h: sha256.New()}
+	resp, err := c.uploadFrom(folderID, name, io.TeeReader(r, sent), opts)
+	if err != nil {
+		return resp, err
+	}
+	sum := ""
+	if c.verifyUploads == VerifyContent {
+		sum = hex.EncodeToString(sent.h.Sum(nil))
+	}
+	item := uploadedItem(resp, name, opts.Crypto)
+	return resp, c.verifyStored(item, opts.HexKey, c.verifyUploads, sent.n, sum)
+}
+
+// uploadedItem describes the file created by an upload of name as a listing would
+func uploadedItem(resp *api.UploadResponse, name string, crypto bool) api.Item {
+	item := api.Item{
+		ID:       resp.FileObj.ID,
+		UID:      UploadedUID(resp),
+		Filename: name,
+		Filesize: resp.FileObj.Filesize,
+		Moddate:  resp.FileObj.Moddate,
+	}
+	if crypto {
+		item.Crypto = 1
+	}
+	return item
+}
+
+// hashCounter hashes and counts the bytes written to it
+type hashCounter struct {
+	h hash.Hash
+	n int64
+}
+
+func (hc *hashCounter) Write(p []byte) (int, error) {
+	hc.n += int64(len(p))
+	return hc.h.Write(p)
+}
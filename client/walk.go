@@ -0,0 +1,67 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/StarHack/go-icedrive/api"
+)
+
+// WalkFunc is called by Walk for every item below the start folder.
+// itemPath is slash-separated and relative to the start folder.
+type WalkFunc func(itemPath string, item api.Item) error
+
+// SkipDir can be returned by a WalkFunc for a folder to skip its contents
+var SkipDir = errors.New("skip this folder")
+
+// Walk visits every file and folder below folderID, folders before their contents.
+// With crypto set the encrypted collection is walked and names are decrypted.
+func (c *Client) Walk(folderID uint64, crypto bool, fn WalkFunc) error {
+	return c.walk(folderID, crypto, "", fn)
+}
+
+func (c *Client) walk(folderID uint64, crypto bool, prefix string, fn WalkFunc) error {
+	var items []api.Item
+	var err error
+	if crypto {
+		items, err = c.ListFolderEncrypted(folderID)
+	} else {
+		items, err = c.ListFolder(folderID)
+	}
+	if err != nil {
+		return fmt.Errorf("list %q: %w", "/"+prefix, err)
+	}
+	for _, item := range items {
+		itemPath := path.Join(prefix, item.Filename)
+		err := fn(itemPath, item)
+		if item.IsFolder != 1 {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if err == SkipDir {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := c.walk(FolderID(item), crypto, itemPath, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FolderID returns the numeric ID of a folder item as used by ListFolder and uploads
+func FolderID(item api.Item) uint64 {
+	var id uint64
+	if strings.HasPrefix(item.UID, "folder-") {
+		if _, err := fmt.Sscanf(item.UID, "folder-%d", &id); err == nil {
+			return id
+		}
+	}
+	return item.ID
+}
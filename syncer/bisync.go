@@ -0,0 +1,349 @@
+package syncer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/StarHack/go-icedrive/api"
+	"github.com/StarHack/go-icedrive/client"
+)
+
+// ConflictPolicy decides what Bisync does with a file changed on both sides
+type ConflictPolicy int
+
+const (
+	// ConflictNewerWins keeps the copy with the later modification time. The
+	// local loser goes to the local trash, the remote one to the Icedrive trash
+	// or its version history. Equal times keep both.
+	ConflictNewerWins ConflictPolicy = iota
+	// ConflictKeepBoth keeps the remote copy under the original name and the
+	// local copy under a name with a conflict suffix, on both sides
+	ConflictKeepBoth
+	// ConflictKeepLocal overwrites the remote copy with the local one
+	ConflictKeepLocal
+	// ConflictKeepRemote overwrites the local copy with the remote one
+	ConflictKeepRemote
+	// ConflictSkip leaves both copies alone; the conflict comes up again on the next run
+	ConflictSkip
+	// ConflictPrompt asks Options.Prompt for each conflict
+	ConflictPrompt
+)
+
+var conflictPolicyNames = map[ConflictPolicy]string{
+	ConflictNewerWins:  "newer-wins",
+	ConflictKeepBoth:   "keep-both",
+	ConflictKeepLocal:  "keep-local",
+	ConflictKeepRemote: "keep-remote",
+	ConflictSkip:       "skip",
+	ConflictPrompt:     "prompt",
+}
+
+func (p ConflictPolicy) String() string {
+	if name, ok := conflictPolicyNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("ConflictPolicy(%d)", int(p))
+}
+
+// ParseConflictPolicy returns the policy with the given name, e.g. "keep-both"
+func ParseConflictPolicy(name string) (ConflictPolicy, error) {
+	for p, n := range conflictPolicyNames {
+		if n == name {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown conflict policy %q", name)
+}
+
+// Conflict describes a file changed on both sides since the last sync
+type Conflict struct {
+	Path         string
+	LocalSize    int64
+	LocalModTime time.Time
+	Remote       api.Item
+	// Resolution is the policy applied, ConflictPrompt if a dry run left it open
+	Resolution ConflictPolicy
+}
+
+// PromptConflict returns a Prompt function that asks on out and reads the answer from in
+func PromptConflict(in io.Reader, out io.Writer) func(Conflict) ConflictPolicy {
+	reader := bufio.NewReader(in)
+	return func(c Conflict) ConflictPolicy {
+		fmt.Fprintf(out, "Conflict: %s\n", c.Path)
+		fmt.Fprintf(out, "  local:  %d bytes, modified %s\n", c.LocalSize, c.LocalModTime.Format(time.RFC3339))
+		fmt.Fprintf(out, "  remote: %d bytes, modified %s\n", c.Remote.Filesize, time.Unix(int64(c.Remote.Moddate), 0).Format(time.RFC3339))
+		for {
+			fmt.Fprint(out, "Keep [l]ocal, [r]emote, [b]oth or [s]kip? ")
+			line, err := reader.ReadString('\n')
+			switch strings.ToLower(strings.TrimSpace(line)) {
+			case "l", "local":
+				return ConflictKeepLocal
+			case "r", "remote":
+				return ConflictKeepRemote
+			case "b", "both":
+				return ConflictKeepBoth
+			case "s", "skip":
+				return ConflictSkip
+			}
+			if err != nil {
+				return ConflictSkip
+			}
+		}
+	}
+}
+
+// change is how one side of a file differs from the last sync
+type change int
+
+const (
+	unchanged change = iota
+	added
+	modified
+	deleted
+)
+
+func (s *Syncer) localChange(p string, e *Entry, l localFile, exists bool) change {
+	switch {
+	case e == nil && !exists:
+		return unchanged
+	case e == nil:
+		return added
+	case !exists:
+		return deleted
+	case l.Size == e.Size && l.ModTime.Unix() == e.ModTime:
+		return unchanged
+	case s.opts.Hash && l.Size == e.Size && e.SHA256 != "":
+		// Only touched if the content still has the hash of the last sync
+		if sum, err := hashFile(s.localPath(p)); err == nil && sum == e.SHA256 {
+			return unchanged
+		}
+	}
+	return modified
+}
+
+func remoteChange(e *Entry, item api.Item, exists bool) change {
+	switch {
+	case e == nil && !exists:
+		return unchanged
+	case e == nil:
+		return added
+	case !exists:
+		return deleted
+	case item.UID == e.UID && item.Moddate == e.RemoteModdate:
+		return unchanged
+	}
+	return modified
+}
+
+// planBisync compares both sides with the state of the last sync. Changes made
+// on one side only are copied to the other, including deletions; files changed
+// on both sides are conflicts unless they ended up identical. A deletion on one
+// side loses against a modification on the other, which restores the file.
+func (s *Syncer) planBisync(plan *Plan, local *localTree, remote *remoteTree) {
+	var actions []Action
+	paths := map[string]bool{}
+	for p := range local.files {
+		paths[p] = true
+	}
+	for p := range remote.files {
+		paths[p] = true
+	}
+
+	for _, p := range sortedKeys(paths) {
+		l, hasLocal := local.files[p]
+		item, hasRemote := remote.files[p]
+		e := s.state.Files[p]
+		lc := s.localChange(p, e, l, hasLocal)
+		rc := remoteChange(e, item, hasRemote)
+
+		upload := func(reason string) {
+			a := Action{Type: ActionCreate, Target: Remote, Path: p, Size: l.Size, Reason: reason}
+			if hasRemote {
+				a.Type, a.item = ActionUpdate, item
+			}
+			actions = append(actions, a)
+		}
+		download := func(reason string, backup bool) {
+			a := Action{Type: ActionCreate, Target: Local, Path: p, Size: int64(item.Filesize), Reason: reason, item: item}
+			if hasLocal {
+				a.Type, a.backup = ActionUpdate, backup
+			}
+			actions = append(actions, a)
+		}
+
+		switch {
+		case lc == unchanged && rc == unchanged:
+			if hasLocal && hasRemote {
+				entry := s.syncedEntry(p, l, item)
+				if e != nil && entry.SHA256 == "" && l.Size == e.Size {
+					// Touched but identical by hash
+					entry.SHA256 = e.SHA256
+				}
+				plan.inSync[p] = entry
+			}
+		case lc == unchanged && rc == deleted:
+			actions = append(actions, Action{Type: ActionDelete, Target: Local, Path: p})
+		case lc == unchanged && rc == added:
+			download("new remotely", false)
+		case lc == unchanged:
+			download("changed remotely", false)
+		case rc == unchanged && lc == deleted:
+			actions = append(actions, Action{Type: ActionDelete, Target: Remote, Path: p, item: item})
+		case rc == unchanged && lc == added:
+			upload("new locally")
+		case rc == unchanged:
+			upload("changed locally")
+		case lc == deleted && rc == deleted:
+			// Gone on both sides, the stale state entry is dropped
+		case lc == deleted:
+			download("deleted locally but changed remotely", false)
+		case rc == deleted:
+			upload("deleted remotely but changed locally")
+		case client.MatchesRemote(l.Size, l.ModTime, item):
+			plan.inSync[p] = s.syncedEntry(p, l, item)
+		default:
+			actions = append(actions, s.resolveConflict(plan, p, l, item, local, remote)...)
+		}
+	}
+
+	for _, a := range s.planBisyncFolders(actions, local, remote) {
+		plan.add(a)
+	}
+}
+
+// planBisyncFolders adds folder actions to the file actions: folders new on
+// one side are created on the other, and folders removed on one side are
+// deleted from the other unless changes were made inside them. Deleting a
+// folder replaces the deletions of the files below it. Empty folders leave no
+// trace in the state, so one deleted on one side comes back from the other.
+func (s *Syncer) planBisyncFolders(actions []Action, local *localTree, remote *remoteTree) []Action {
+	// Folders that hold files of the last sync existed on both sides back then
+	synced := map[string]bool{}
+	for p := range s.state.Files {
+		for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+			synced[dir] = true
+		}
+	}
+	// Folders that receive or keep changed files must survive
+	busy := map[Side]map[string]bool{Local: {}, Remote: {}}
+	for _, a := range actions {
+		if a.Type == ActionDelete {
+			continue
+		}
+		other := Local
+		if a.Target == Local {
+			other = Remote
+		}
+		for _, p := range []string{a.Path, a.OldPath} {
+			if p == "" {
+				continue
+			}
+			for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+				busy[a.Target][dir] = true
+				busy[other][dir] = true
+			}
+		}
+	}
+
+	var folderActions []Action
+	deleted := map[Side]map[string]bool{Local: {}, Remote: {}}
+	for _, dir := range sortedKeys(local.dirs) {
+		if _, ok := remote.folders[dir]; ok {
+			continue
+		}
+		if synced[dir] && !busy[Local][dir] {
+			if !underAny(dir, deleted[Local]) {
+				deleted[Local][dir] = true
+				folderActions = append(folderActions, Action{Type: ActionDelete, Target: Local, Path: dir})
+			}
+			continue
+		}
+		folderActions = append(folderActions, Action{Type: ActionMkdir, Target: Remote, Path: dir})
+	}
+	for _, dir := range sortedKeys(remote.folders) {
+		if local.dirs[dir] {
+			continue
+		}
+		if synced[dir] && !busy[Remote][dir] {
+			if !underAny(dir, deleted[Remote]) {
+				deleted[Remote][dir] = true
+				folderActions = append(folderActions, Action{Type: ActionDelete, Target: Remote, Path: dir, item: remote.folders[dir]})
+			}
+			continue
+		}
+		folderActions = append(folderActions, Action{Type: ActionMkdir, Target: Local, Path: dir})
+	}
+
+	// File deletions inside deleted folders are covered by the folder
+	kept := actions[:0]
+	for _, a := range actions {
+		if a.Type == ActionDelete && (deleted[a.Target][a.Path] || underAny(a.Path, deleted[a.Target])) {
+			continue
+		}
+		kept = append(kept, a)
+	}
+	return append(kept, folderActions...)
+}
+
+func (s *Syncer) resolveConflict(plan *Plan, p string, l localFile, item api.Item, local *localTree, remote *remoteTree) []Action {
+	c := Conflict{Path: p, LocalSize: l.Size, LocalModTime: l.ModTime, Remote: item}
+	policy := s.opts.Conflict
+	if policy == ConflictPrompt {
+		if s.opts.DryRun {
+			c.Resolution = ConflictPrompt
+			plan.Conflicts = append(plan.Conflicts, c)
+			return nil
+		}
+		if policy = s.opts.Prompt(c); policy == ConflictPrompt {
+			policy = ConflictSkip
+		}
+	}
+	if policy == ConflictNewerWins {
+		switch localTime, remoteTime := l.ModTime.Unix(), int64(item.Moddate); {
+		case localTime > remoteTime:
+			policy = ConflictKeepLocal
+		case localTime < remoteTime:
+			policy = ConflictKeepRemote
+		default:
+			policy = ConflictKeepBoth
+		}
+	}
+	c.Resolution = policy
+	plan.Conflicts = append(plan.Conflicts, c)
+
+	switch policy {
+	case ConflictKeepLocal:
+		return []Action{{Type: ActionUpdate, Target: Remote, Path: p, Size: l.Size, Reason: "conflict, local kept", item: item}}
+	case ConflictKeepRemote:
+		return []Action{{Type: ActionUpdate, Target: Local, Path: p, Size: int64(item.Filesize), Reason: "conflict, remote kept", item: item, backup: true}}
+	case ConflictKeepBoth:
+		renamed := conflictPath(p, time.Now(), func(candidate string) bool {
+			_, isLocal := local.files[candidate]
+			_, isRemote := remote.files[candidate]
+			return isLocal || isRemote
+		})
+		return []Action{
+			{Type: ActionRename, Target: Local, Path: renamed, OldPath: p},
+			{Type: ActionCreate, Target: Remote, Path: renamed, Size: l.Size, Reason: "conflict, local copy"},
+			{Type: ActionCreate, Target: Local, Path: p, Size: int64(item.Filesize), Reason: "conflict, remote copy", item: item},
+		}
+	}
+	return nil
+}
+
+// conflictPath returns a free name for the local copy of p, like "report (conflict 2006-01-02 150405).txt"
+func conflictPath(p string, t time.Time, taken func(string) bool) string {
+	ext := filepath.Ext(p)
+	base := strings.TrimSuffix(p, ext)
+	stamp := t.Format("2006-01-02 150405")
+	candidate := fmt.Sprintf("%s (conflict %s)%s", base, stamp, ext)
+	for i := 2; taken(candidate); i++ {
+		candidate = fmt.Sprintf("%s (conflict %s %d)%s", base, stamp, i, ext)
+	}
+	return candidate
+}
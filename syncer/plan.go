@@ -0,0 +1,328 @@
+package syncer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/StarHack/go-icedrive/api"
+	"github.com/StarHack/go-icedrive/client"
+)
+
+// ActionType is the kind of change an Action applies to the destination
+type ActionType string
+
+const (
+	ActionMkdir  ActionType = "mkdir"
+	ActionCreate ActionType = "create"
+	ActionUpdate ActionType = "update"
+	ActionRename ActionType = "rename"
+	ActionDelete ActionType = "delete"
+)
+
+// Action is one step of a Plan. Paths are slash-separated and relative to the
+// synced directory.
+type Action struct {
+	Type    ActionType
+	Target  Side // the side the action changes
+	Path    string
+	OldPath string // source path of a rename
+	Size    int64  // bytes to transfer for creates and updates
+	Reason  string
+
+	item   api.Item // remote item the action works on, if any
+	backup bool     // move the local file to the local trash before overwriting it
+}
+
+func (a Action) String() string {
+	switch a.Type {
+	case ActionRename:
+		return fmt.Sprintf("%-6s %-6s %s -> %s", a.Type, a.Target, a.OldPath, a.Path)
+	case ActionCreate, ActionUpdate:
+		return fmt.Sprintf("%-6s %-6s %s (%d bytes, %s)", a.Type, a.Target, a.Path, a.Size, a.Reason)
+	default:
+		return fmt.Sprintf("%-6s %-6s %s", a.Type, a.Target, a.Path)
+	}
+}
+
+// Plan lists the actions that make the destination match the source, in the
+// order they are applied: folders first, then renames, transfers and deletes.
+type Plan struct {
+	Direction Direction
+	Actions   []Action
+	Bytes     int64 // total bytes to transfer
+	// Conflicts lists the files changed on both sides in a Bisync and how they are resolved
+	Conflicts []Conflict
+
+	// inSync records files found identical and stale the state entries of
+	// files gone from both sides, to refresh the state on apply
+	inSync map[string]*Entry
+	stale  []string
+}
+
+// String renders the plan one action per line, as shown for a dry run
+func (p *Plan) String() string {
+	var b strings.Builder
+	for _, c := range p.Conflicts {
+		fmt.Fprintf(&b, "conflict %s: %s\n", c.Path, c.Resolution)
+	}
+	for _, a := range p.Actions {
+		b.WriteString(a.String())
+		b.WriteByte('\n')
+	}
+	fmt.Fprintf(&b, "%d actions, %d bytes to transfer\n", len(p.Actions), p.Bytes)
+	return b.String()
+}
+
+// Empty reports whether nothing needs to be done
+func (p *Plan) Empty() bool {
+	return len(p.Actions) == 0
+}
+
+func (p *Plan) add(a Action) {
+	p.Actions = append(p.Actions, a)
+	p.Bytes += a.Size
+}
+
+var actionOrder = map[ActionType]int{ActionMkdir: 0, ActionRename: 1, ActionCreate: 2, ActionUpdate: 2, ActionDelete: 3}
+
+func (p *Plan) sort() {
+	sort.SliceStable(p.Actions, func(i, j int) bool {
+		a, b := p.Actions[i], p.Actions[j]
+		if actionOrder[a.Type] != actionOrder[b.Type] {
+			return actionOrder[a.Type] < actionOrder[b.Type]
+		}
+		// Sorting by path creates parents before their children
+		return a.Path < b.Path
+	})
+}
+
+// Plan scans both sides and computes the actions of a sync without changing anything
+func (s *Syncer) Plan() (*Plan, error) {
+	local, err := s.scanLocal()
+	if err != nil {
+		return nil, fmt.Errorf("scan %s: %w", s.opts.LocalDir, err)
+	}
+	remote, err := s.scanRemote()
+	if err != nil {
+		return nil, fmt.Errorf("scan remote folder: %w", err)
+	}
+	s.remoteFolders = remote.folders
+
+	plan := &Plan{Direction: s.opts.Direction, inSync: map[string]*Entry{}}
+	switch s.opts.Direction {
+	case Upload:
+		s.planUpload(plan, local, remote)
+	case Download:
+		s.planDownload(plan, local, remote)
+	default:
+		s.planBisync(plan, local, remote)
+	}
+	for p := range s.state.Files {
+		_, isLocal := local.files[p]
+		_, isRemote := remote.files[p]
+		if !isLocal && !isRemote {
+			plan.stale = append(plan.stale, p)
+		}
+	}
+	plan.sort()
+	return plan, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// renameKey identifies a file by size and modification time for rename detection
+type renameKey struct {
+	size    int64
+	modTime int64
+}
+
+func (s *Syncer) planUpload(plan *Plan, local *localTree, remote *remoteTree) {
+	for _, dir := range sortedKeys(local.dirs) {
+		if _, ok := remote.folders[dir]; !ok {
+			plan.add(Action{Type: ActionMkdir, Target: Remote, Path: dir})
+		}
+	}
+
+	// Files that vanished locally but whose remote copy is still the one last
+	// synced may have been renamed. Moving them implies deleting the old path.
+	candidates := map[renameKey][]string{}
+	for p, e := range s.state.Files {
+		if _, ok := local.files[p]; ok || !s.opts.Delete {
+			continue
+		}
+		if item, ok := remote.files[p]; ok && item.UID == e.UID {
+			k := renameKey{e.Size, e.ModTime}
+			candidates[k] = append(candidates[k], p)
+		}
+	}
+	renamed := map[string]bool{}
+
+	for _, p := range sortedKeys(local.files) {
+		l := local.files[p]
+		item, ok := remote.files[p]
+		switch {
+		case !ok:
+			if old := candidates[renameKey{l.Size, l.ModTime.Unix()}]; len(old) == 1 && !renamed[old[0]] {
+				renamed[old[0]] = true
+				plan.add(Action{Type: ActionRename, Target: Remote, Path: p, OldPath: old[0], item: remote.files[old[0]]})
+				continue
+			}
+			plan.add(Action{Type: ActionCreate, Target: Remote, Path: p, Size: l.Size, Reason: "new"})
+		case client.MatchesRemote(l.Size, l.ModTime, item):
+			plan.inSync[p] = s.syncedEntry(p, l, item)
+		case s.opts.Hash && s.sameContent(p, l, item):
+			e := *s.state.Files[p]
+			e.Size, e.ModTime = l.Size, l.ModTime.Unix()
+			plan.inSync[p] = &e
+		default:
+			plan.add(Action{Type: ActionUpdate, Target: Remote, Path: p, Size: l.Size, Reason: changeReason(l, item), item: item})
+		}
+	}
+
+	if !s.opts.Delete {
+		return
+	}
+	deletedFolders := map[string]bool{}
+	for _, dir := range sortedKeys(remote.folders) {
+		if !local.dirs[dir] && !underAny(dir, deletedFolders) && !holdsRenameSource(dir, renamed) {
+			deletedFolders[dir] = true
+			plan.add(Action{Type: ActionDelete, Target: Remote, Path: dir, item: remote.folders[dir]})
+		}
+	}
+	for _, p := range sortedKeys(remote.files) {
+		if _, ok := local.files[p]; !ok && !renamed[p] && !underAny(p, deletedFolders) {
+			plan.add(Action{Type: ActionDelete, Target: Remote, Path: p, item: remote.files[p]})
+		}
+	}
+}
+
+func (s *Syncer) planDownload(plan *Plan, local *localTree, remote *remoteTree) {
+	for _, dir := range sortedKeys(remote.folders) {
+		if !local.dirs[dir] {
+			plan.add(Action{Type: ActionMkdir, Target: Local, Path: dir})
+		}
+	}
+
+	// A remote file that moved keeps its UID; reuse the local copy if it is
+	// still the one last synced. Moving it implies deleting the old path.
+	byUID := map[string]string{}
+	for p, e := range s.state.Files {
+		if _, ok := remote.files[p]; ok || !s.opts.Delete {
+			continue
+		}
+		if l, ok := local.files[p]; ok && l.Size == e.Size && l.ModTime.Unix() == e.ModTime {
+			byUID[e.UID] = p
+		}
+	}
+	renamed := map[string]bool{}
+
+	for _, p := range sortedKeys(remote.files) {
+		item := remote.files[p]
+		l, ok := local.files[p]
+		switch {
+		case !ok:
+			if old, found := byUID[item.UID]; found && !renamed[old] {
+				renamed[old] = true
+				plan.add(Action{Type: ActionRename, Target: Local, Path: p, OldPath: old, item: item})
+				continue
+			}
+			plan.add(Action{Type: ActionCreate, Target: Local, Path: p, Size: int64(item.Filesize), Reason: "new", item: item})
+		case client.MatchesRemote(l.Size, l.ModTime, item):
+			plan.inSync[p] = s.syncedEntry(p, l, item)
+		case s.opts.Hash && s.sameContent(p, l, item):
+			e := *s.state.Files[p]
+			e.Size, e.ModTime = l.Size, l.ModTime.Unix()
+			plan.inSync[p] = &e
+		default:
+			plan.add(Action{Type: ActionUpdate, Target: Local, Path: p, Size: int64(item.Filesize), Reason: changeReason(l, item), item: item})
+		}
+	}
+
+	if !s.opts.Delete {
+		return
+	}
+	deletedDirs := map[string]bool{}
+	for _, dir := range sortedKeys(local.dirs) {
+		if _, ok := remote.folders[dir]; !ok && !underAny(dir, deletedDirs) && !holdsRenameSource(dir, renamed) {
+			deletedDirs[dir] = true
+			plan.add(Action{Type: ActionDelete, Target: Local, Path: dir})
+		}
+	}
+	for _, p := range sortedKeys(local.files) {
+		if _, ok := remote.files[p]; !ok && !renamed[p] && !underAny(p, deletedDirs) {
+			plan.add(Action{Type: ActionDelete, Target: Local, Path: p})
+		}
+	}
+}
+
+// holdsRenameSource reports whether a file renamed away from below dir is still needed
+func holdsRenameSource(dir string, renamed map[string]bool) bool {
+	for p := range renamed {
+		if strings.HasPrefix(p, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func changeReason(l localFile, item api.Item) string {
+	if l.ModTime.Unix() != int64(item.Moddate) {
+		return "modified"
+	}
+	return "size changed"
+}
+
+// syncedEntry builds the state entry of a file that is identical on both sides, keeping a known hash
+func (s *Syncer) syncedEntry(p string, l localFile, item api.Item) *Entry {
+	e := &Entry{Size: l.Size, ModTime: l.ModTime.Unix(), UID: item.UID, RemoteModdate: item.Moddate}
+	if old := s.state.Files[p]; old != nil && old.UID == item.UID && old.Size == l.Size && old.ModTime == e.ModTime {
+		e.SHA256 = old.SHA256
+	}
+	return e
+}
+
+// sameContent compares the local file with the hash recorded when the
+// unchanged remote file was last synced, or else with the cached hash of the
+// remote file. Remote files are never downloaded just to hash them.
+func (s *Syncer) sameContent(p string, l localFile, item api.Item) bool {
+	e := s.state.Files[p]
+	if e == nil || e.SHA256 == "" || e.UID != item.UID || e.RemoteModdate != item.Moddate || e.Size != l.Size {
+		remoteSum, ok := s.c.CachedRemoteHash(item)
+		if !ok {
+			return false
+		}
+		sum, err := hashFile(s.localPath(p))
+		return err == nil && sum == remoteSum
+	}
+	if e.ModTime == l.ModTime.Unix() {
+		// Unchanged since its hash was taken
+		return true
+	}
+	sum, err := hashFile(s.localPath(p))
+	return err == nil && sum == e.SHA256
+}
+
+func hashFile(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,121 @@
+package syncer
+
+import (
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/StarHack/go-icedrive/api"
+	"github.com/StarHack/go-icedrive/client"
+)
+
+type localFile struct {
+	Size    int64
+	ModTime time.Time
+}
+
+// localTree is a snapshot of the local directory, keyed by slash-separated relative path
+type localTree struct {
+	files map[string]localFile
+	dirs  map[string]bool
+}
+
+// remoteTree is a snapshot of the remote folder, keyed by slash-separated
+// relative path with compressed names mapped to their original names
+type remoteTree struct {
+	files   map[string]api.Item
+	folders map[string]api.Item
+}
+
+func (s *Syncer) scanLocal() (*localTree, error) {
+	tree := &localTree{files: map[string]localFile{}, dirs: map[string]bool{}}
+	root := s.opts.LocalDir
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == root {
+			return nil
+		}
+		if p == s.opts.StatePath || p == s.opts.StatePath+".tmp" || p == s.opts.LocalTrashDir {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if s.excluded(rel) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			tree.dirs[rel] = true
+			return nil
+		}
+		if !d.Type().IsRegular() || strings.HasPrefix(d.Name(), tempPrefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		tree.files[rel] = localFile{Size: info.Size(), ModTime: info.ModTime()}
+		return nil
+	})
+	return tree, err
+}
+
+func (s *Syncer) scanRemote() (*remoteTree, error) {
+	tree := &remoteTree{files: map[string]api.Item{}, folders: map[string]api.Item{}}
+	walk := s.c.Walk
+	if s.opts.Index != nil {
+		walk = s.opts.Index.Walk
+	}
+	err := walk(s.opts.FolderID, s.opts.Crypto, func(itemPath string, item api.Item) error {
+		if item.IsFolder == 1 {
+			if s.excluded(itemPath) {
+				return client.SkipDir
+			}
+			tree.folders[itemPath] = item
+			return nil
+		}
+		p := path.Join(path.Dir(itemPath), client.DecompressedName(item))
+		if s.excluded(p) {
+			return nil
+		}
+		tree.files[p] = item
+		return nil
+	})
+	return tree, err
+}
+
+// excluded reports whether relPath or its base name matches one of the exclude globs
+func (s *Syncer) excluded(relPath string) bool {
+	for _, g := range s.opts.Exclude {
+		if ok, _ := path.Match(g, relPath); ok {
+			return true
+		}
+		if ok, _ := path.Match(g, path.Base(relPath)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// underAny reports whether p lies below one of the given folder paths
+func underAny(p string, folders map[string]bool) bool {
+	for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+		if folders[dir] {
+			return true
+		}
+	}
+	return false
+}
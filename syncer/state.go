@@ -0,0 +1,151 @@
+package syncer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// stateVersion is bumped when the state file format changes incompatibly
+const stateVersion = 1
+
+// Entry records a file as it was after it was last synced
+type Entry struct {
+	Size          int64  `json:"size"`
+	ModTime       int64  `json:"mtime"` // local, Unix seconds
+	UID           string `json:"uid"`
+	RemoteModdate uint64 `json:"moddate"`
+	SHA256        string `json:"sha256,omitempty"`
+}
+
+// State is the persistent record of the last sync, keyed by slash-separated
+// path relative to the synced directory. It makes subsequent runs incremental
+// and lets renames be detected.
+type State struct {
+	Version  int               `json:"version"`
+	FolderID uint64            `json:"folder_id"`
+	Crypto   bool              `json:"crypto"`
+	Files    map[string]*Entry `json:"files"`
+
+	path  string
+	mu    sync.Mutex
+	dirty int
+}
+
+// LoadState reads the state file at path, or starts an empty state if it does
+// not exist yet. The state must belong to the same remote folder.
+func LoadState(path string, folderID uint64, crypto bool) (*State, error) {
+	s := &State{
+		Version:  stateVersion,
+		FolderID: folderID,
+		Crypto:   crypto,
+		Files:    map[string]*Entry{},
+		path:     path,
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("corrupt sync state %s: %w", path, err)
+	}
+	if s.Version != stateVersion {
+		return nil, fmt.Errorf("sync state %s has unsupported version %d", path, s.Version)
+	}
+	if s.FolderID != folderID || s.Crypto != crypto {
+		return nil, fmt.Errorf("sync state %s belongs to a different remote folder", path)
+	}
+	if s.Files == nil {
+		s.Files = map[string]*Entry{}
+	}
+	return s, nil
+}
+
+// Get returns the entry for path, nil if unknown
+func (s *State) Get(path string) *Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Files[path]
+}
+
+// Set records the entry for path
+func (s *State) Set(path string, e *Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Files[path] = e
+	s.dirty++
+}
+
+// Delete forgets path
+func (s *State) Delete(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.Files, path)
+	s.dirty++
+}
+
+// DeleteTree forgets path and everything below it
+func (s *State) DeleteTree(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for p := range s.Files {
+		if p == path || strings.HasPrefix(p, path+"/") {
+			delete(s.Files, p)
+			s.dirty++
+		}
+	}
+}
+
+// Move moves the entry of oldPath to newPath
+func (s *State) Move(oldPath, newPath string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.Files[oldPath]; ok {
+		delete(s.Files, oldPath)
+		s.Files[newPath] = e
+		s.dirty++
+	}
+}
+
+// saveEvery is the number of changes after which Checkpoint writes the state
+const saveEvery = 100
+
+// Checkpoint saves the state if enough changes have accumulated since the last save
+func (s *State) Checkpoint() error {
+	s.mu.Lock()
+	due := s.dirty >= saveEvery
+	s.mu.Unlock()
+	if !due {
+		return nil
+	}
+	return s.Save()
+}
+
+// Save writes the state file atomically
+func (s *State) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	s.dirty = 0
+	return nil
+}
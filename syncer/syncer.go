@@ -0,0 +1,488 @@
+// Package syncer synchronizes a local directory and a remote Icedrive folder
+// in one direction. A sync first computes a Plan from the differences between
+// both sides, which can be inspected as a dry run, and then applies it. The
+// state of the last sync is kept in a local file so that later runs only
+// transfer what changed and can detect renames.
+package syncer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/StarHack/go-icedrive/api"
+	"github.com/StarHack/go-icedrive/client"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// StateFileName is the default state file, stored in the local directory
+	StateFileName = ".icedrive-sync.json"
+	// LocalTrashDirName is the default folder in the local directory that receives local deletions
+	LocalTrashDirName = ".icedrive-trash"
+
+	// tempPrefix marks partial downloads, which scans ignore
+	tempPrefix = ".icedrive-sync-"
+)
+
+// Direction says which side is the source of a sync
+type Direction int
+
+const (
+	// Upload makes the remote folder match the local directory
+	Upload Direction = iota
+	// Download makes the local directory match the remote folder
+	Download
+	// Bisync propagates changes made on either side since the last run to the
+	// other, resolving conflicting changes with Options.Conflict
+	Bisync
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Download:
+		return "download"
+	case Bisync:
+		return "bisync"
+	}
+	return "upload"
+}
+
+// Side names the local or the remote side of a sync
+type Side int
+
+const (
+	Local Side = iota
+	Remote
+)
+
+func (s Side) String() string {
+	if s == Remote {
+		return "remote"
+	}
+	return "local"
+}
+
+// Options configures a Syncer
+type Options struct {
+	Direction Direction
+	// LocalDir is the local side of the sync
+	LocalDir string
+	// FolderID is the remote side of the sync, 0 for the root
+	FolderID uint64
+	// Crypto syncs with the encrypted collection
+	Crypto bool
+	// Delete removes files missing on the source side: remote files go to the
+	// Icedrive trash, local files to LocalTrashDir. It also enables rename
+	// detection. Bisync always propagates deletions in this way.
+	Delete bool
+	// Conflict decides how Bisync handles files changed on both sides, ConflictNewerWins by default
+	Conflict ConflictPolicy
+	// Prompt is asked to resolve each conflict under ConflictPrompt, see PromptConflict
+	Prompt func(Conflict) ConflictPolicy
+	// Hash compares files by their SHA-256 recorded at the last sync, or known
+	// to the client's hash cache, when only the modification time differs,
+	// avoiding transfers of touched files
+	Hash bool
+	// DryRun makes Run return the plan without applying it
+	DryRun bool
+	// Concurrency is the number of parallel transfers, the client's pool size if <= 0
+	Concurrency int
+	// BandwidthLimit caps all transfers together in bytes per second, unlimited if <= 0
+	BandwidthLimit int64
+	// Exclude skips files and folders whose relative path or name matches one of the globs
+	Exclude []string
+	// Index, if set, is scanned instead of listing the remote folder. It must
+	// cover FolderID and be refreshed before the run; it is not updated by the sync.
+	// Changes in the folders counted by Index.Unverified are not seen, so
+	// rebuild the index first if that is not zero.
+	Index *client.Index
+	// StatePath is the state file, LocalDir/StateFileName if empty
+	StatePath string
+	// LocalTrashDir receives local deletions, LocalDir/LocalTrashDirName if empty
+	LocalTrashDir string
+	// Progress, if set, is called after each applied action, possibly from several goroutines at once
+	Progress func(Result)
+}
+
+// Result is the outcome of one applied action
+type Result struct {
+	Action Action
+	Err    error
+}
+
+// Summary collects the results of Apply
+type Summary struct {
+	Results []Result
+	Applied int
+	Failed  int
+	Bytes   int64 // bytes transferred
+}
+
+// Err joins the errors of all failed actions, nil if nothing failed
+func (s *Summary) Err() error {
+	var errs []error
+	for _, r := range s.Results {
+		if r.Err != nil {
+			errs = append(errs, fmt.Errorf("%s %s: %w", r.Action.Type, r.Action.Path, r.Err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Syncer syncs one local directory with one remote folder
+type Syncer struct {
+	c       *client.Client
+	opts    Options
+	state   *State
+	limiter *rate.Limiter
+
+	remoteFolders map[string]api.Item
+	folderIDs     map[string]uint64
+	trashStamp    string
+}
+
+// New returns a Syncer for the given options, loading the state of earlier runs
+func New(c *client.Client, opts Options) (*Syncer, error) {
+	if opts.LocalDir == "" {
+		return nil, errors.New("sync requires a local directory")
+	}
+	dir, err := filepath.Abs(opts.LocalDir)
+	if err != nil {
+		return nil, err
+	}
+	opts.LocalDir = dir
+	if opts.Direction != Upload {
+		err = os.MkdirAll(dir, 0o755)
+	} else {
+		var fi os.FileInfo
+		if fi, err = os.Stat(dir); err == nil && !fi.IsDir() {
+			err = fmt.Errorf("%s is not a directory", dir)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if opts.StatePath == "" {
+		opts.StatePath = filepath.Join(dir, StateFileName)
+	}
+	if opts.LocalTrashDir == "" {
+		opts.LocalTrashDir = filepath.Join(dir, LocalTrashDirName)
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = c.PoolSize()
+	}
+	if opts.Conflict == ConflictPrompt && opts.Prompt == nil {
+		return nil, errors.New("conflict policy prompt requires a Prompt function")
+	}
+	state, err := LoadState(opts.StatePath, opts.FolderID, opts.Crypto)
+	if err != nil {
+		return nil, err
+	}
+	return &Syncer{c: c, opts: opts, state: state, limiter: newBandwidthLimiter(opts.BandwidthLimit)}, nil
+}
+
+// Run computes the plan and applies it unless DryRun is set, in which case the summary is nil
+func (s *Syncer) Run() (*Plan, *Summary, error) {
+	plan, err := s.Plan()
+	if err != nil {
+		return nil, nil, err
+	}
+	if s.opts.DryRun {
+		return plan, nil, nil
+	}
+	summary, err := s.Apply(plan)
+	return plan, summary, err
+}
+
+// Apply executes a plan returned by Plan: folders are created and renames done
+// first, then files are transferred concurrently, and deletions come last.
+// Failed actions don't stop the sync; they are reported in the summary and
+// retried by the next run. The returned error is set if the state could not be saved.
+func (s *Syncer) Apply(plan *Plan) (*Summary, error) {
+	if plan.Direction != s.opts.Direction {
+		return nil, errors.New("plan was made for the other direction")
+	}
+	s.folderIDs = map[string]uint64{".": s.opts.FolderID}
+	for p, item := range s.remoteFolders {
+		s.folderIDs[p] = client.FolderID(item)
+	}
+	s.trashStamp = time.Now().Format("20060102-150405")
+
+	for p, e := range plan.inSync {
+		s.state.Set(p, e)
+	}
+	for _, p := range plan.stale {
+		s.state.Delete(p)
+	}
+
+	summary := &Summary{}
+	var mu sync.Mutex
+	record := func(a Action, err error) {
+		mu.Lock()
+		summary.Results = append(summary.Results, Result{Action: a, Err: err})
+		if err != nil {
+			summary.Failed++
+		} else {
+			summary.Applied++
+			summary.Bytes += a.Size
+		}
+		mu.Unlock()
+		if s.opts.Progress != nil {
+			s.opts.Progress(Result{Action: a, Err: err})
+		}
+	}
+
+	var transfers []Action
+	for _, a := range plan.Actions {
+		switch a.Type {
+		case ActionMkdir:
+			record(a, s.mkdir(a))
+		case ActionRename:
+			record(a, s.rename(a))
+		case ActionCreate, ActionUpdate:
+			transfers = append(transfers, a)
+		}
+	}
+
+	jobs := make(chan Action)
+	var wg sync.WaitGroup
+	for i := 0; i < s.opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for a := range jobs {
+				err := s.transfer(a)
+				record(a, err)
+				if err == nil {
+					// Periodic saves bound the work lost if the process dies
+					_ = s.state.Checkpoint()
+				}
+			}
+		}()
+	}
+	for _, a := range transfers {
+		jobs <- a
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, a := range plan.Actions {
+		if a.Type == ActionDelete {
+			record(a, s.delete(a))
+		}
+	}
+	err := s.state.Save()
+	if hc := s.c.HashCache(); hc != nil {
+		err = errors.Join(err, hc.Save())
+	}
+	return summary, err
+}
+
+func (s *Syncer) localPath(p string) string {
+	return filepath.Join(s.opts.LocalDir, filepath.FromSlash(p))
+}
+
+func (s *Syncer) folderID(dir string) (uint64, error) {
+	id, ok := s.folderIDs[dir]
+	if !ok {
+		return 0, fmt.Errorf("remote folder %q unavailable", dir)
+	}
+	return id, nil
+}
+
+func (s *Syncer) mkdir(a Action) error {
+	if a.Target == Local {
+		return os.MkdirAll(s.localPath(a.Path), 0o755)
+	}
+	parentID, err := s.folderID(path.Dir(a.Path))
+	if err != nil {
+		return err
+	}
+	id, err := s.c.MkdirAll(parentID, path.Base(a.Path), s.opts.Crypto)
+	if err != nil {
+		return err
+	}
+	s.folderIDs[a.Path] = id
+	return nil
+}
+
+func (s *Syncer) rename(a Action) error {
+	if a.Target == Local {
+		newPath := s.localPath(a.Path)
+		if err := os.MkdirAll(filepath.Dir(newPath), 0o755); err != nil {
+			return err
+		}
+		if err := os.Rename(s.localPath(a.OldPath), newPath); err != nil {
+			return err
+		}
+		s.state.Move(a.OldPath, a.Path)
+		return nil
+	}
+
+	item := a.item
+	if newDir := path.Dir(a.Path); newDir != path.Dir(a.OldPath) {
+		folderID, err := s.folderID(newDir)
+		if err != nil {
+			return err
+		}
+		if err := s.c.Move(folderID, item); err != nil {
+			return err
+		}
+	}
+	newName := path.Base(a.Path)
+	if client.IsCompressed(item) {
+		newName += client.CompressedSuffix
+	}
+	if newName != item.Filename {
+		if err := s.c.Rename(item, newName); err != nil {
+			return err
+		}
+	}
+	s.state.Move(a.OldPath, a.Path)
+	return nil
+}
+
+func (s *Syncer) transfer(a Action) error {
+	var e *Entry
+	var err error
+	if a.Target == Local {
+		e, err = s.download(a)
+	} else {
+		e, err = s.upload(a)
+	}
+	if err != nil {
+		return err
+	}
+	s.state.Set(a.Path, e)
+	return nil
+}
+
+func (s *Syncer) upload(a Action) (*Entry, error) {
+	folderID, err := s.folderID(path.Dir(a.Path))
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(s.localPath(a.Path))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	hasher := sha256.New()
+	resp, err := s.c.UploadReader(folderID, path.Base(a.Path), io.TeeReader(throttle(f, s.limiter), hasher), api.UploadOptions{
+		Moddate: fi.ModTime(),
+		Crypto:  s.opts.Crypto,
+		Size:    fi.Size(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	uid := client.UploadedUID(resp)
+	sum := hex.EncodeToString(hasher.Sum(nil))
+	if hc := s.c.HashCache(); hc != nil {
+		hc.Put(api.Item{UID: uid, Filesize: resp.FileObj.Filesize, Moddate: resp.FileObj.Moddate}, sum)
+	}
+	if a.Type == ActionUpdate && a.item.UID != "" && a.item.UID != uid {
+		// The server kept the previous copy next to the new one, e.g. because it was compressed
+		if err := s.c.TrashItem(a.item); err != nil {
+			return nil, fmt.Errorf("trash previous copy: %w", err)
+		}
+	}
+	return &Entry{
+		Size:          fi.Size(),
+		ModTime:       fi.ModTime().Unix(),
+		UID:           uid,
+		RemoteModdate: uint64(fi.ModTime().Unix()),
+		SHA256:        sum,
+	}, nil
+}
+
+func (s *Syncer) download(a Action) (*Entry, error) {
+	var rc io.ReadCloser
+	var err error
+	if s.opts.Crypto {
+		rc, err = s.c.DownloadFileEncryptedStream(a.item)
+	} else {
+		rc, err = s.c.DownloadFileStream(a.item)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	dest := s.localPath(a.Path)
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return nil, err
+	}
+	if a.backup {
+		if err := s.moveToLocalTrash(a.Path); err != nil {
+			return nil, fmt.Errorf("back up local copy: %w", err)
+		}
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dest), tempPrefix+"*.part")
+	if err != nil {
+		return nil, err
+	}
+	hasher := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, hasher), throttle(rc, s.limiter))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), dest)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	mtime := time.Unix(int64(a.item.Moddate), 0)
+	if err := os.Chtimes(dest, mtime, mtime); err != nil {
+		return nil, err
+	}
+	sum := hex.EncodeToString(hasher.Sum(nil))
+	if hc := s.c.HashCache(); hc != nil {
+		hc.Put(a.item, sum)
+	}
+	return &Entry{
+		Size:          n,
+		ModTime:       mtime.Unix(),
+		UID:           a.item.UID,
+		RemoteModdate: a.item.Moddate,
+		SHA256:        sum,
+	}, nil
+}
+
+func (s *Syncer) delete(a Action) error {
+	if a.Target == Local {
+		if err := s.moveToLocalTrash(a.Path); err != nil {
+			return err
+		}
+	} else if err := s.c.TrashItem(a.item); err != nil {
+		return err
+	} else if hc := s.c.HashCache(); hc != nil {
+		hc.Forget(a.item.UID)
+	}
+	s.state.DeleteTree(a.Path)
+	return nil
+}
+
+// moveToLocalTrash moves the local file or folder p into this run's folder in LocalTrashDir
+func (s *Syncer) moveToLocalTrash(p string) error {
+	trashed := filepath.Join(s.opts.LocalTrashDir, s.trashStamp, filepath.FromSlash(p))
+	if err := os.MkdirAll(filepath.Dir(trashed), 0o755); err != nil {
+		return err
+	}
+	return os.Rename(s.localPath(p), trashed)
+}
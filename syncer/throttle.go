@@ -0,0 +1,46 @@
+package syncer
+
+import (
+	"context"
+	"io"
+
+	"golang.org/x/time/rate"
+)
+
+// newBandwidthLimiter returns a limiter for bytesPerSecond shared by all transfers, nil for unlimited
+func newBandwidthLimiter(bytesPerSecond int64) *rate.Limiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	burst := bytesPerSecond
+	if burst > 1024*1024 {
+		burst = 1024 * 1024
+	}
+	return rate.NewLimiter(rate.Limit(bytesPerSecond), int(burst))
+}
+
+// throttledReader delays reads so that the limiter's rate is not exceeded
+type throttledReader struct {
+	r       io.Reader
+	limiter *rate.Limiter
+}
+
+func throttle(r io.Reader, limiter *rate.Limiter) io.Reader {
+	if limiter == nil {
+		return r
+	}
+	return &throttledReader{r: r, limiter: limiter}
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > t.limiter.Burst() {
+		p = p[:t.limiter.Burst()]
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		if werr := t.limiter.WaitN(context.Background(), n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
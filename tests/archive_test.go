@@ -0,0 +1,264 @@
+package tests
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StarHack/go-icedrive/api"
+	"github.com/StarHack/go-icedrive/client"
+)
+
+func TestDetectArchiveFormat(t *testing.T) {
+	cases := map[string]client.ArchiveFormat{
+		"photos.zip":        client.ArchiveZip,
+		"backup.TAR":        client.ArchiveTar,
+		"src.tar.gz":        client.ArchiveTarGz,
+		"release-1.2.3.tgz": client.ArchiveTarGz,
+	}
+	for name, want := range cases {
+		got, err := client.DetectArchiveFormat(name)
+		if err != nil || got != want {
+			t.Errorf("DetectArchiveFormat(%q) = %v, %v; want %v", name, got, err, want)
+		}
+		if err == nil && got.Extension() == "" {
+			t.Errorf("Missing extension for %v", got)
+		}
+	}
+	if _, err := client.DetectArchiveFormat("notes.txt"); err == nil {
+		t.Error("Expected an error for a non-archive name")
+	}
+}
+
+func TestWriteArchive(t *testing.T) {
+	f := newFakeAPI(t)
+	c := f.newClient()
+	root := f.addFolder(0, "photos")
+	sub := f.addFolder(root, "sub")
+	f.addFile(root, "a.txt", []byte("alpha"), 1700000000)
+	f.addFile(sub, "b.txt", []byte("bravo"), 1700000100)
+
+	want := map[string]string{
+		"photos/a.txt":     "alpha",
+		"photos/sub/b.txt": "bravo",
+	}
+	for _, format := range []client.ArchiveFormat{client.ArchiveZip, client.ArchiveTar, client.ArchiveTarGz} {
+		t.Run(format.Extension(), func(t *testing.T) {
+			tmpDir := t.TempDir()
+			t.Setenv("TMPDIR", tmpDir)
+
+			var buf bytes.Buffer
+			if err := c.WriteArchive(&buf, root, client.ArchiveOptions{Format: format, Prefix: "photos"}); err != nil {
+				t.Fatalf("WriteArchive failed: %v", err)
+			}
+			files, dirs := readArchive(t, format, buf.Bytes())
+			if !reflect.DeepEqual(files, want) {
+				t.Errorf("Archive holds %v, want %v", files, want)
+			}
+			if !dirs["photos/sub"] {
+				t.Errorf("Missing folder entry, got %v", dirs)
+			}
+			if left, _ := os.ReadDir(tmpDir); len(left) != 0 {
+				t.Errorf("Temporary files left behind: %v", left)
+			}
+		})
+	}
+
+	// Compressed uploads are decompressed into zip archives, under their
+	// original name only if they really are gzip
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte(strings.Repeat("compressed ", 100)))
+	zw.Close()
+	f.addFile(sub, "c.log"+client.CompressedSuffix, gz.Bytes(), 1700000200)
+	f.addFile(sub, "d.txt"+client.CompressedSuffix, []byte("plain"), 1700000300)
+	want["photos/sub/c.log"] = strings.Repeat("compressed ", 100)
+	want["photos/sub/d.txt"+client.CompressedSuffix] = "plain"
+
+	var buf bytes.Buffer
+	if err := c.WriteArchive(&buf, root, client.ArchiveOptions{Format: client.ArchiveZip, Prefix: "photos"}); err != nil {
+		t.Fatalf("WriteArchive failed: %v", err)
+	}
+	if files, _ := readArchive(t, client.ArchiveZip, buf.Bytes()); !reflect.DeepEqual(files, want) {
+		t.Errorf("Archive holds %v, want %v", files, want)
+	}
+
+	// Tar needs sizes up front, which compressed uploads don't have
+	buf.Reset()
+	err := c.WriteArchive(&buf, root, client.ArchiveOptions{Format: client.ArchiveTar})
+	if !errors.Is(err, client.ErrCompressedInTar) || buf.Len() != 0 {
+		t.Errorf("Expected ErrCompressedInTar before writing, got %v and %d bytes", err, buf.Len())
+	}
+}
+
+func TestWriteArchiveRefusesUnsafeNames(t *testing.T) {
+	f := newFakeAPI(t)
+	c := f.newClient()
+	root := f.addFolder(0, "photos")
+	f.addFile(root, "a.txt", []byte("alpha"), 1700000000)
+
+	var buf bytes.Buffer
+	if err := c.WriteArchive(&buf, root, client.ArchiveOptions{Prefix: "../up"}); err == nil || buf.Len() != 0 {
+		t.Errorf("Expected an unsafe prefix to be refused, got %v and %d bytes", err, buf.Len())
+	}
+	for _, name := range []string{"..", "../evil.txt", "sub/evil.txt", `..\evil.txt`, "../evil" + client.CompressedSuffix} {
+		sub := f.addFolder(root, "sub")
+		uid := f.addFile(sub, "evil.txt", []byte("evil"), 1700000000).UID
+		f.mutate(uid, func(it *api.Item, _ *[]byte) { it.Filename = name })
+		buf.Reset()
+		if err := c.WriteArchive(&buf, root, client.ArchiveOptions{}); err == nil || buf.Len() != 0 {
+			t.Errorf("%q: expected the archive to be refused, got %v and %d bytes", name, err, buf.Len())
+		}
+		f.mutate(f.folderUID(sub), func(it *api.Item, _ *[]byte) { it.Filename = "done" })
+		f.mutate(uid, func(it *api.Item, _ *[]byte) { it.Filename = "fixed.txt" })
+	}
+}
+
+// readArchive returns the file contents and folders of an archive, checking
+// that every file keeps the modification time of the fake API
+func readArchive(t *testing.T, format client.ArchiveFormat, data []byte) (map[string]string, map[string]bool) {
+	t.Helper()
+	files := map[string]string{}
+	dirs := map[string]bool{}
+	mtimes := map[string]int64{
+		"photos/a.txt":     1700000000,
+		"photos/sub/b.txt": 1700000100,
+		"photos/sub/c.log": 1700000200,
+		"photos/sub/d.txt" + client.CompressedSuffix: 1700000300,
+	}
+	add := func(name string, mtime time.Time, r io.Reader) {
+		content, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", name, err)
+		}
+		files[name] = string(content)
+		if mtime.Unix() != mtimes[name] {
+			t.Errorf("%s has mtime %d, want %d", name, mtime.Unix(), mtimes[name])
+		}
+	}
+
+	if format == client.ArchiveZip {
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatalf("Failed to open zip: %v", err)
+		}
+		for _, zf := range zr.File {
+			if strings.HasSuffix(zf.Name, "/") {
+				dirs[strings.TrimSuffix(zf.Name, "/")] = true
+				continue
+			}
+			rc, err := zf.Open()
+			if err != nil {
+				t.Fatalf("Failed to open %s: %v", zf.Name, err)
+			}
+			add(zf.Name, zf.Modified, rc)
+			rc.Close()
+		}
+		return files, dirs
+	}
+
+	var r io.Reader = bytes.NewReader(data)
+	if format == client.ArchiveTarGz {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			t.Fatalf("Failed to open gzip: %v", err)
+		}
+		r = zr
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read tar: %v", err)
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			dirs[strings.TrimSuffix(hdr.Name, "/")] = true
+			continue
+		}
+		add(hdr.Name, hdr.ModTime, tr)
+	}
+	return files, dirs
+}
+
+func TestExtractArchiveSkipsUnsafeEntries(t *testing.T) {
+	type entry struct {
+		name    string
+		dir     bool
+		symlink bool
+	}
+	entries := []entry{
+		{name: "./", dir: true},
+		{name: "safe/", dir: true},
+		{name: "../evil/", dir: true},
+		{name: "/abs/", dir: true},
+		{name: "safe/a.txt"},
+		{name: "../escape.txt"},
+		{name: "link", symlink: true},
+	}
+
+	var tarBuf, zipBuf bytes.Buffer
+	tw := tar.NewWriter(&tarBuf)
+	zw := zip.NewWriter(&zipBuf)
+	for _, e := range entries {
+		hdr := &tar.Header{Typeflag: tar.TypeReg, Name: e.name, Mode: 0o644, Size: 5, ModTime: time.Unix(1700000000, 0)}
+		zh := &zip.FileHeader{Name: e.name, Modified: time.Unix(1700000000, 0)}
+		zh.SetMode(0o644)
+		switch {
+		case e.dir:
+			hdr.Typeflag, hdr.Size = tar.TypeDir, 0
+			zh.SetMode(fs.ModeDir | 0o755)
+		case e.symlink:
+			hdr.Typeflag, hdr.Size, hdr.Linkname = tar.TypeSymlink, 0, "/etc/passwd"
+			zh.SetMode(fs.ModeSymlink | 0o777)
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("Failed to write tar header: %v", err)
+		}
+		zfw, err := zw.CreateHeader(zh)
+		if err != nil {
+			t.Fatalf("Failed to write zip header: %v", err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			tw.Write([]byte("hello"))
+			zfw.Write([]byte("hello"))
+		}
+	}
+	tw.Close()
+	zw.Close()
+
+	for format, archive := range map[client.ArchiveFormat]*bytes.Buffer{client.ArchiveTar: &tarBuf, client.ArchiveZip: &zipBuf} {
+		t.Run(format.Extension(), func(t *testing.T) {
+			f := newFakeAPI(t)
+			c := f.newClient()
+			summary, err := c.ExtractArchive(archive, 0, client.ExtractOptions{Format: format})
+			if err != nil {
+				t.Fatalf("ExtractArchive failed: %v", err)
+			}
+			var skipped []string
+			for _, r := range summary.Results {
+				if r.Status == client.UploadStatusSkipped {
+					skipped = append(skipped, r.LocalPath)
+				}
+			}
+			want := []string{"../evil/", "/abs/", "../escape.txt", "link"}
+			if summary.Uploaded != 1 || !reflect.DeepEqual(skipped, want) {
+				t.Errorf("Expected 1 upload and skipped %v, got %d and %v", want, summary.Uploaded, skipped)
+			}
+			if n := len(f.callsTo("/folder-create")); n != 1 {
+				t.Errorf("Expected only the safe folder to be created, got %d folders", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package tests
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/StarHack/go-icedrive/api"
+)
+
+func TestPoolReloginSingleFlight(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer fresh" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"id":1,"email":"test@example.com"}`))
+	}))
+	defer srv.Close()
+
+	p := api.NewHTTPClientPool(4, 60000)
+	p.SetApiBase(srv.URL)
+	p.SetBearerToken("expired")
+
+	var logins int32
+	p.SetReloginFunc(func() error {
+		atomic.AddInt32(&logins, 1)
+		time.Sleep(50 * time.Millisecond)
+		p.SetBearerToken("fresh")
+		return nil
+	})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- p.WithClient(func(h *api.HTTPClient) error {
+				user, err := api.UserData(h)
+				if err == nil && user.ID != 1 {
+					err = errors.New("unexpected user data")
+				}
+				return err
+			})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Request failed: %v", err)
+		}
+	}
+	if n := atomic.LoadInt32(&logins); n != 1 {
+		t.Errorf("Expected exactly one re-login, got %d", n)
+	}
+}
+
+func TestPoolReloginBackoff(t *testing.T) {
+	p := api.NewHTTPClientPool(1, 60000)
+	p.SetBearerToken("expired")
+
+	var logins int32
+	p.SetReloginFunc(func() error {
+		atomic.AddInt32(&logins, 1)
+		return errors.New("invalid credentials")
+	})
+
+	if _, err := p.Relogin("expired"); err == nil {
+		t.Fatal("Expected first re-login to fail")
+	}
+	if _, err := p.Relogin("expired"); err == nil {
+		t.Fatal("Expected second re-login to be refused during backoff")
+	}
+	if n := atomic.LoadInt32(&logins); n != 1 {
+		t.Errorf("Expected one login attempt during backoff, got %d", n)
+	}
+}
+
+func TestPoolTokenExpiry(t *testing.T) {
+	p := api.NewHTTPClientPool(1, 60000)
+	if !p.TokenExpiry().IsZero() {
+		t.Fatal("Expected no expiry without a token")
+	}
+
+	p.SetTokenLifetime(time.Hour)
+	p.SetBearerToken("opaque-token")
+	if !p.TokenExpiresWithin(2*time.Hour) || p.TokenExpiresWithin(30*time.Minute) {
+		t.Errorf("Unexpected expiry for opaque token: %v", p.TokenExpiry())
+	}
+
+	exp := time.Now().Add(10 * time.Minute).Truncate(time.Second)
+	payload := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"exp":%d}`, exp.Unix())))
+	p.SetBearerToken("eyJhbGciOiJIUzI1NiJ9." + payload + ".sig")
+	if !p.TokenExpiry().Equal(exp) {
+		t.Errorf("Expected JWT expiry %v, got %v", exp, p.TokenExpiry())
+	}
+}
@@ -0,0 +1,87 @@
+package tests
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/StarHack/go-icedrive/api"
+	"github.com/StarHack/go-icedrive/client"
+)
+
+func TestCompressedNames(t *testing.T) {
+	file := api.Item{Filename: "access.log" + client.CompressedSuffix}
+	if !client.IsCompressed(file) {
+		t.Errorf("Expected %q to be compressed", file.Filename)
+	}
+	if name := client.DecompressedName(file); name != "access.log" {
+		t.Errorf("Unexpected decompressed name: %q", name)
+	}
+
+	folder := api.Item{Filename: "logs" + client.CompressedSuffix, IsFolder: 1}
+	if client.IsCompressed(folder) {
+		t.Error("Folders are never compressed")
+	}
+}
+
+func TestEnableCompressionLevel(t *testing.T) {
+	c := client.NewClient()
+	if err := c.EnableCompression(42); err == nil {
+		t.Error("Expected invalid compression level to fail")
+	}
+	if err := c.EnableCompression(9); err != nil {
+		t.Errorf("Failed to enable compression: %v", err)
+	}
+}
+
+func TestCompressedSuffixWithoutGzipIsPassedThrough(t *testing.T) {
+	f := newFakeAPI(t)
+	c := f.newClient()
+	if err := c.EnableCompression(6); err != nil {
+		t.Fatal(err)
+	}
+
+	content := strings.Repeat("log line\n", 1000)
+	w, err := c.UploadFileWriter(0, "app.log")
+	if err != nil {
+		t.Fatalf("UploadFileWriter failed: %v", err)
+	}
+	if _, err := io.WriteString(w, content); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	own := f.addFile(0, "notes"+client.CompressedSuffix, []byte("not gzip at all"), 1700000000)
+
+	items, err := c.ListFolder(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for _, item := range items {
+		if err := c.DownloadFile(item, dir); err != nil {
+			t.Fatalf("DownloadFile %s failed: %v", item.Filename, err)
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "app.log")); err != nil || string(data) != content {
+		t.Errorf("Compressed upload not restored: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, own.Filename)); err != nil || string(data) != "not gzip at all" {
+		t.Errorf("Own %s file not kept as-is: %q, %v", client.CompressedSuffix, data, err)
+	}
+
+	rc, err := c.DownloadFileStream(own)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if client.Decompressing(rc) {
+		t.Error("A file without gzip header must not be decompressed")
+	}
+	if data, _ := io.ReadAll(rc); string(data) != "not gzip at all" {
+		t.Errorf("Unexpected stream content %q", data)
+	}
+}
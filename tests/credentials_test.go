@@ -0,0 +1,50 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/StarHack/go-icedrive/client"
+)
+
+func TestFileCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	content := "# test\nICEDRIVE_EMAIL=user@example.com\nICEDRIVE_PASSWORD=\"s3cret=1\"\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write credentials file: %v", err)
+	}
+
+	creds, err := client.FileCredentials(path).Credentials()
+	if err != nil {
+		t.Fatalf("Failed to read credentials: %v", err)
+	}
+	if creds.Email != "user@example.com" || creds.Password != "s3cret=1" {
+		t.Errorf("Unexpected credentials: %+v", creds)
+	}
+}
+
+func TestCachedCredentialsWipe(t *testing.T) {
+	provider := client.CacheCredentials(client.PromptCredentials(
+		strings.NewReader("user@example.com\nhunter2\nother@example.com\npassword\n"), &strings.Builder{}))
+
+	for i := 0; i < 2; i++ {
+		creds, err := provider.Credentials()
+		if err != nil {
+			t.Fatalf("Failed to get credentials: %v", err)
+		}
+		if creds.Email != "user@example.com" || creds.Password != "hunter2" {
+			t.Fatalf("Expected cached credentials, got %+v", creds)
+		}
+	}
+
+	provider.(client.Wiper).Wipe()
+	creds, err := provider.Credentials()
+	if err != nil {
+		t.Fatalf("Failed to get credentials after wipe: %v", err)
+	}
+	if creds.Email != "other@example.com" {
+		t.Errorf("Expected provider to be asked again after wipe, got %+v", creds)
+	}
+}
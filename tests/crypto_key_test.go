@@ -0,0 +1,51 @@
+package tests
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/StarHack/go-icedrive/api"
+	"github.com/StarHack/go-icedrive/client"
+)
+
+func TestVerifyCryptoKey(t *testing.T) {
+	keyHex, err := api.DeriveCryptoKey("correct horse", "salt")
+	if err != nil {
+		t.Fatalf("Failed to derive key: %v", err)
+	}
+	key, _ := hex.DecodeString(keyHex)
+	sum := sha256.Sum256(key)
+	storedHex := hex.EncodeToString(sum[:])
+
+	if _, err := api.DeriveAndVerifyCryptoKey("correct horse", "salt", storedHex); err != nil {
+		t.Errorf("Expected correct password to verify, got %v", err)
+	}
+	if _, err := api.DeriveAndVerifyCryptoKey("correct hrose", "salt", storedHex); !errors.Is(err, api.ErrWrongCryptoPassword) {
+		t.Errorf("Expected ErrWrongCryptoPassword, got %v", err)
+	}
+
+	c := client.NewClient()
+	if err := c.SetCryptoPasswordWithSalt("wrong", "salt", storedHex); !errors.Is(err, api.ErrWrongCryptoPassword) {
+		t.Errorf("Expected ErrWrongCryptoPassword, got %v", err)
+	}
+	if c.CryptoHexKey != "" {
+		t.Error("Expected a wrong password not to set a key")
+	}
+	if err := c.SetCryptoPasswordWithSalt("correct horse", "salt", storedHex); err != nil || !c.CryptoKeyVerified() {
+		t.Errorf("Expected the correct password to verify, err=%v", err)
+	}
+
+	// Skipping the check accepts the key but leaves it unverified
+	c.SkipCryptoHashCheck(true)
+	if err := c.SetCryptoPasswordWithSalt("wrong", "salt", storedHex); err != nil {
+		t.Errorf("Expected a skipped check to accept the password, got %v", err)
+	}
+	if c.CryptoKeyVerified() || c.CryptoHexKey == keyHex || c.CryptoHexKey == "" {
+		t.Errorf("Expected an unverified key to be set, verified=%v", c.CryptoKeyVerified())
+	}
+	if err := c.SetCryptoKeyHex(keyHex); err != nil || c.CryptoHexKey != keyHex || c.CryptoKeyVerified() {
+		t.Errorf("Failed to set raw key: %v", err)
+	}
+}
@@ -0,0 +1,227 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/StarHack/go-icedrive/api"
+)
+
+const testKeyHex = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+
+func testPlaintext(size int) []byte {
+	content := make([]byte, size)
+	for i := range content {
+		content[i] = byte(i*7 + i/251)
+	}
+	return content
+}
+
+func encryptForTest(t *testing.T, plain []byte) []byte {
+	t.Helper()
+	var enc bytes.Buffer
+	if err := api.EncryptTwofishCBCStream(&enc, bytes.NewReader(plain), testKeyHex, uint64(len(plain))); err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	return enc.Bytes()
+}
+
+func TestCryptoStreamRoundTrip(t *testing.T) {
+	const mib = 1024 * 1024
+	sizes := []int{0, 1, 15, 16, 17, 4*mib - 33, 4*mib - 32, 4*mib - 31, 4*mib + 100, 9*mib + 5}
+	for _, size := range sizes {
+		t.Run(fmt.Sprintf("Size_%d", size), func(t *testing.T) {
+			plain := testPlaintext(size)
+			enc := encryptForTest(t, plain)
+
+			for _, workers := range []int{1, 4} {
+				var dec bytes.Buffer
+				// DataErrReader returns EOF separately from the last data, HalfReader splits reads unevenly
+				src := iotest.DataErrReader(iotest.HalfReader(bytes.NewReader(enc)))
+				if err := api.DecryptTwofishCBCStreamParallel(&dec, src, testKeyHex, workers); err != nil {
+					t.Fatalf("Decrypt with %d workers failed: %v", workers, err)
+				}
+				if !bytes.Equal(dec.Bytes(), plain) {
+					t.Fatalf("Round trip mismatch with %d workers: got %d bytes, want %d", workers, dec.Len(), len(plain))
+				}
+			}
+
+			info, err := api.VerifyCryptoStream(bytes.NewReader(enc), testKeyHex)
+			if err != nil {
+				t.Fatalf("Verify failed: %v", err)
+			}
+			if info.PlainSize != int64(size) || info.CipherSize != int64(len(enc)) {
+				t.Errorf("Unexpected sizes: %+v", info)
+			}
+		})
+	}
+}
+
+func TestCryptoStreamIntegrityChecks(t *testing.T) {
+	plain := testPlaintext(1000)
+	enc := encryptForTest(t, plain)
+
+	wrongKey := "ff" + testKeyHex[2:]
+	if _, err := api.VerifyCryptoStream(bytes.NewReader(enc), wrongKey); !errors.Is(err, api.ErrWrongCryptoKey) {
+		t.Errorf("Expected ErrWrongCryptoKey, got %v", err)
+	}
+	if _, err := api.VerifyCryptoStream(bytes.NewReader(enc[:len(enc)-5]), testKeyHex); !errors.Is(err, api.ErrTruncatedCryptoFile) {
+		t.Errorf("Expected ErrTruncatedCryptoFile for unaligned truncation, got %v", err)
+	}
+	if _, err := api.VerifyCryptoStream(bytes.NewReader(enc[:len(enc)-16]), testKeyHex); !errors.Is(err, api.ErrTruncatedCryptoFile) {
+		t.Errorf("Expected ErrTruncatedCryptoFile for block truncation, got %v", err)
+	}
+	if _, err := api.VerifyCryptoStream(bytes.NewReader(enc[:20]), testKeyHex); !errors.Is(err, api.ErrTruncatedCryptoFile) {
+		t.Errorf("Expected ErrTruncatedCryptoFile for short header, got %v", err)
+	}
+}
+
+func BenchmarkDecryptParallel(b *testing.B) {
+	plain := testPlaintext(32 * 1024 * 1024)
+	var enc bytes.Buffer
+	if err := api.EncryptTwofishCBCStream(&enc, bytes.NewReader(plain), testKeyHex, uint64(len(plain))); err != nil {
+		b.Fatalf("Encrypt failed: %v", err)
+	}
+	for _, workers := range []int{1, 0} {
+		b.Run(fmt.Sprintf("Workers_%d", workers), func(b *testing.B) {
+			b.SetBytes(int64(len(plain)))
+			for i := 0; i < b.N; i++ {
+				if err := api.DecryptTwofishCBCStreamParallel(io.Discard, bytes.NewReader(enc.Bytes()), testKeyHex, workers); err != nil {
+					b.Fatalf("Decrypt failed: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestEncryptWriter(t *testing.T) {
+	plain := testPlaintext(9*1024*1024 + 7)
+	for _, size := range []int64{int64(len(plain)), -1} {
+		var enc bytes.Buffer
+		w, err := api.NewEncryptWriter(&enc, testKeyHex, size, 3)
+		if err != nil {
+			t.Fatalf("NewEncryptWriter failed: %v", err)
+		}
+		// Uneven writes to exercise chunk boundaries
+		for rest := plain; len(rest) > 0; {
+			n := 777777
+			if n > len(rest) {
+				n = len(rest)
+			}
+			if _, err := w.Write(rest[:n]); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+			rest = rest[n:]
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+
+		var dec bytes.Buffer
+		if err := api.DecryptTwofishCBCStreamStrict(&dec, &enc, testKeyHex); err != nil {
+			t.Fatalf("Decrypt (size %d) failed: %v", size, err)
+		}
+		if !bytes.Equal(dec.Bytes(), plain) {
+			t.Fatalf("Round trip mismatch (size %d)", size)
+		}
+	}
+
+	w, err := api.NewEncryptWriter(io.Discard, testKeyHex, 10, 0)
+	if err != nil {
+		t.Fatalf("NewEncryptWriter failed: %v", err)
+	}
+	_, _ = w.Write([]byte("short"))
+	if err := w.Close(); err == nil {
+		t.Error("Expected size mismatch error")
+	}
+}
+
+func TestEncryptWriterUnknownSizeSpoolsToDisk(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	plain := testPlaintext(9 * 1024 * 1024)
+
+	var enc bytes.Buffer
+	w, err := api.NewEncryptWriter(&enc, testKeyHex, -1, 2)
+	if err != nil {
+		t.Fatalf("NewEncryptWriter failed: %v", err)
+	}
+	if _, err := w.Write(plain); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if enc.Len() != 0 {
+		t.Error("Nothing may be written before the header is known")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if entries, _ := os.ReadDir(tmp); len(entries) != 0 {
+		t.Errorf("Spool file left behind: %v", entries)
+	}
+	var dec bytes.Buffer
+	if err := api.DecryptTwofishCBCStreamStrict(&dec, &enc, testKeyHex); err != nil || !bytes.Equal(dec.Bytes(), plain) {
+		t.Fatalf("Round trip failed: %v", err)
+	}
+}
+
+// gatedWriter lets the header through and blocks every later write until open is closed
+type gatedWriter struct {
+	open chan struct{}
+	n    int64
+}
+
+func (g *gatedWriter) Write(p []byte) (int, error) {
+	if g.n > 0 {
+		<-g.open
+	}
+	g.n += int64(len(p))
+	return len(p), nil
+}
+
+func TestEncryptWriterBoundsChunksInFlight(t *testing.T) {
+	const mib = 1024 * 1024
+	plain := testPlaintext(64 * mib)
+	dst := &gatedWriter{open: make(chan struct{})}
+	w, err := api.NewEncryptWriter(dst, testKeyHex, int64(len(plain)), 64)
+	if err != nil {
+		t.Fatalf("NewEncryptWriter failed: %v", err)
+	}
+
+	var accepted int64
+	done := make(chan error, 1)
+	go func() {
+		for rest := plain; len(rest) > 0; rest = rest[mib:] {
+			if _, err := w.Write(rest[:mib]); err != nil {
+				done <- err
+				return
+			}
+			atomic.AddInt64(&accepted, mib)
+		}
+		done <- w.Close()
+	}()
+
+	// With dst stalled, writes stop once the bounded number of chunks is taken
+	var last int64 = -1
+	for n := atomic.LoadInt64(&accepted); n != last; n = atomic.LoadInt64(&accepted) {
+		last = n
+		time.Sleep(200 * time.Millisecond)
+	}
+	if last == 0 || last > 24*mib {
+		t.Errorf("Expected at most 24 MiB to be accepted while dst is stalled, got %d MiB", last/mib)
+	}
+
+	close(dst.open)
+	if err := <-done; err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	if want := int64(len(plain)) + 32; dst.n != want {
+		t.Errorf("Expected %d bytes written, got %d", want, dst.n)
+	}
+}
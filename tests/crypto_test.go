@@ -22,7 +22,9 @@ func TestEncryptedFileUploadWorkflow(t *testing.T) {
 		t.Fatalf("Login failed: %v", err)
 	}
 
-	c.SetCryptoPassword(testCryptoPassword)
+	if err := c.SetCryptoPassword(testCryptoPassword); err != nil {
+		t.Fatalf("Failed to set crypto password: %v", err)
+	}
 	saltDisplay := c.CryptoSalt
 	if len(saltDisplay) > 16 {
 		saltDisplay = saltDisplay[:16] + "..."
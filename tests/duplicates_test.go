@@ -0,0 +1,170 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/StarHack/go-icedrive/api"
+	"github.com/StarHack/go-icedrive/client"
+)
+
+func TestDuplicateReportOutput(t *testing.T) {
+	report := &client.DuplicateReport{
+		Groups: []client.DuplicateGroup{{
+			SHA256: "abc",
+			Size:   10,
+			Files: []client.DuplicateFile{
+				{Collection: api.CollectionCloud, Path: "/a.txt", UID: "file-1", Moddate: 1},
+				{Collection: api.CollectionCloud, Path: "/b/a.txt", UID: "file-2", Moddate: 2},
+				{Collection: api.CollectionCrypto, Path: "/c.txt", UID: "file-3", Moddate: 3},
+			},
+		}},
+		Files:  5,
+		Hashed: 3,
+		Wasted: 20,
+	}
+
+	if dups := report.Duplicates(); len(dups) != 2 || dups[0].UID != "file-2" || dups[1].UID != "file-3" {
+		t.Errorf("Expected all but the first file as duplicates, got %+v", dups)
+	}
+
+	var csvOut bytes.Buffer
+	if err := report.WriteCSV(&csvOut); err != nil {
+		t.Fatalf("Failed to write CSV: %v", err)
+	}
+	rows, err := csv.NewReader(&csvOut).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to parse CSV: %v", err)
+	}
+	if len(rows) != 4 || rows[1][7] != "true" || rows[2][7] != "false" || rows[3][3] != "crypto" {
+		t.Errorf("Unexpected CSV rows: %v", rows)
+	}
+
+	var jsonOut bytes.Buffer
+	if err := report.WriteJSON(&jsonOut); err != nil {
+		t.Fatalf("Failed to write JSON: %v", err)
+	}
+	var decoded client.DuplicateReport
+	if err := json.Unmarshal(jsonOut.Bytes(), &decoded); err != nil {
+		t.Fatalf("Failed to parse JSON: %v", err)
+	}
+	if decoded.Wasted != 20 || len(decoded.Groups) != 1 || decoded.Groups[0].Files[2].Path != "/c.txt" {
+		t.Errorf("Unexpected JSON report: %+v", decoded)
+	}
+}
+
+func TestFindDuplicatesNeedsCollection(t *testing.T) {
+	c := client.NewClient()
+	if _, err := c.FindDuplicates(client.DuplicateOptions{}); err == nil {
+		t.Error("Expected an error without a collection")
+	}
+}
+
+func TestFindDuplicatesGrouping(t *testing.T) {
+	f := newFakeAPI(t)
+	c := f.newClient()
+	x := f.addFolder(0, "x")
+	y := f.addFolder(0, "y")
+	f.addFile(0, "a.txt", []byte("same"), 300)
+	f.addFile(x, "a.txt", []byte("same"), 100)
+	f.addFile(y, "a.txt", []byte("same"), 200)
+	f.addFile(0, "b.txt", []byte("bravo"), 100)
+	f.addFile(0, "c.txt", []byte("charl"), 100)
+	f.addFile(0, "unique.txt", []byte("only one of this size"), 100)
+	f.addFile(0, "empty.txt", nil, 100)
+	stub1 := f.addFile(0, "stub1.bin", []byte("1234567890"), 100)
+	stub2 := f.addFile(x, "stub2.bin", []byte("abcdefghij"), 50)
+
+	// Cached hashes stand in for downloads; these two differ in content but
+	// share a cached hash, so they can only be grouped through the cache
+	cache := client.NewHashCache()
+	cache.Put(stub1, "feed")
+	cache.Put(stub2, "feed")
+	c.SetHashCache(cache)
+
+	report, err := c.FindDuplicates(client.DuplicateOptions{Cloud: true})
+	if err != nil {
+		t.Fatalf("FindDuplicates failed: %v", err)
+	}
+	if err := report.Err(); err != nil {
+		t.Fatalf("Hashing failed: %v", err)
+	}
+	if report.Files != 8 || report.Hashed != 7 {
+		t.Errorf("Expected 8 files with 7 hashed, got %d and %d", report.Files, report.Hashed)
+	}
+	if len(report.Groups) != 2 {
+		t.Fatalf("Expected 2 groups, got %+v", report.Groups)
+	}
+	var paths [][]string
+	for _, g := range report.Groups {
+		var p []string
+		for _, file := range g.Files {
+			p = append(p, file.Path)
+		}
+		paths = append(paths, p)
+	}
+	want := [][]string{{"/x/stub2.bin", "/stub1.bin"}, {"/x/a.txt", "/y/a.txt", "/a.txt"}}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("Expected groups %v, largest first and oldest first, got %v", want, paths)
+	}
+	if report.Groups[0].SHA256 != "feed" || report.Groups[1].Size != 4 {
+		t.Errorf("Unexpected group hashes or sizes: %+v", report.Groups)
+	}
+	if report.Wasted != 10+2*4 {
+		t.Errorf("Expected 18 wasted bytes, got %d", report.Wasted)
+	}
+
+	// Only the candidates without a cached hash were downloaded
+	downloaded := 0
+	for _, call := range f.callsTo("/download-multi") {
+		if items := call.Form.Get("items"); items == stub1.UID || items == stub2.UID {
+			t.Errorf("Cached file %s was downloaded", items)
+		}
+		downloaded++
+	}
+	if downloaded != 5 {
+		t.Errorf("Expected 5 downloads, got %d", downloaded)
+	}
+	if _, ok := cache.Get(report.Groups[1].Files[0].Item); !ok {
+		t.Error("Expected downloaded hashes to be cached")
+	}
+}
+
+func TestTrashDuplicatesInOneRequest(t *testing.T) {
+	f := newFakeAPI(t)
+	c := f.newClient()
+	file := func(uid string, crypto int) client.DuplicateFile {
+		return client.DuplicateFile{UID: uid, Item: api.Item{UID: uid, Crypto: crypto, Filesize: 1, Moddate: 1700000000}}
+	}
+	report := &client.DuplicateReport{Groups: []client.DuplicateGroup{{
+		Files: []client.DuplicateFile{file("file-1", 0), file("file-2", 0), file("file-3", 1), file("file-4", 1)},
+	}}}
+	cache := client.NewHashCache()
+	for _, df := range report.Groups[0].Files {
+		cache.Put(df.Item, "sum")
+	}
+	c.SetHashCache(cache)
+
+	n, err := c.TrashDuplicates(report)
+	if err != nil {
+		t.Fatalf("TrashDuplicates failed: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("Expected 3 files trashed, got %d", n)
+	}
+	var batches []string
+	for _, call := range f.callsTo("/trash-add") {
+		batches = append(batches, call.Form.Get("items"))
+	}
+	if want := []string{"file-2,file-3,file-4"}; !reflect.DeepEqual(batches, want) {
+		t.Errorf("Expected trash-add batches %v, got %v", want, batches)
+	}
+	for _, df := range report.Groups[0].Files {
+		if _, ok := cache.Get(df.Item); ok != (df.UID == "file-1") {
+			t.Errorf("%s: cached hash kept = %v", df.UID, ok)
+		}
+	}
+}
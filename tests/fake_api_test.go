@@ -0,0 +1,537 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/StarHack/go-icedrive/api"
+	"github.com/StarHack/go-icedrive/client"
+)
+
+// fakeAPI is an in-memory stand-in for the Icedrive API, just detailed enough
+// for offline tests of the client. Names of encrypted items are stored as sent,
+// i.e. encrypted, and file bodies as uploaded.
+type fakeAPI struct {
+	t   *testing.T
+	srv *httptest.Server
+
+	mu     sync.Mutex
+	nextID uint64
+	items  map[string]*fakeItem
+	calls  []fakeCall
+	// cryptoAuth is the hash returned by GET /crypto-auth, "ICE::<hash>::<salt>"
+	cryptoAuth string
+	// storageExtra is storage reported by /user-stats beyond the stored files, like file versions
+	storageExtra uint64
+	// cryptoAuthReadOnly makes POST /crypto-auth succeed without storing the hash
+	cryptoAuthReadOnly bool
+	// fail, if set, is asked before each request and fails it with status 500 when true
+	fail func(path string, form url.Values) bool
+	// links are the public links by share ID
+	links map[string]*fakeLink
+}
+
+// fakeLink is a public link to the item with the given UID
+type fakeLink struct {
+	uid      string
+	password string
+	expires  uint64
+}
+
+type fakeItem struct {
+	api.Item
+	data    []byte
+	trashed bool
+}
+
+// fakeCall records a request: its path, raw query, headers of interest and
+// its form and query values combined
+type fakeCall struct {
+	Path          string
+	Query         string
+	ContentType   string
+	Authorization string
+	Form          url.Values
+}
+
+func newFakeAPI(t *testing.T) *fakeAPI {
+	t.Helper()
+	f := &fakeAPI{t: t, nextID: 100, items: map[string]*fakeItem{}, links: map[string]*fakeLink{}}
+	f.srv = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+// newClient returns a client logged in to the fake API with a bearer token
+func (f *fakeAPI) newClient() *client.Client {
+	f.t.Helper()
+	c := client.NewClientWithPoolSize(2, 600000)
+	c.SetAPIBase(f.srv.URL)
+	if err := c.LoginWithBearerToken("token"); err != nil {
+		f.t.Fatalf("Login to fake API failed: %v", err)
+	}
+	f.t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// addFolder creates a plain folder directly on the server and returns its ID
+func (f *fakeAPI) addFolder(parentID uint64, name string) uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.addItemLocked(parentID, name, true, false, nil, 1700000000).ID
+}
+
+// addFile creates a plain file directly on the server and returns it
+func (f *fakeAPI) addFile(parentID uint64, name string, data []byte, moddate uint64) api.Item {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.addItemLocked(parentID, name, false, false, data, moddate).Item
+}
+
+func (f *fakeAPI) addItemLocked(parentID uint64, name string, folder, crypto bool, data []byte, moddate uint64) *fakeItem {
+	f.nextID++
+	it := &fakeItem{Item: api.Item{
+		ID:       f.nextID,
+		Filename: name,
+		ParentID: parentID,
+		Moddate:  moddate,
+		Filesize: uint64(len(data)),
+		IsOwner:  1,
+	}, data: data}
+	if folder {
+		it.UID = fmt.Sprintf("folder-%d", f.nextID)
+		it.IsFolder = 1
+		it.Filesize = 0
+	} else {
+		it.UID = fmt.Sprintf("file-%d", f.nextID)
+	}
+	if crypto {
+		it.Crypto = 1
+	}
+	f.items[it.UID] = it
+	f.touchLocked(parentID, moddate)
+	return it
+}
+
+// touchLocked updates the modification time of a folder after a change of its direct children
+func (f *fakeAPI) touchLocked(folderID uint64, moddate uint64) {
+	if it := f.items[f.folderUID(folderID)]; it != nil && moddate > it.Moddate {
+		it.Moddate = moddate
+	}
+}
+
+// item returns a copy of the item with the given UID
+func (f *fakeAPI) item(uid string) (api.Item, []byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	it := f.items[uid]
+	if it == nil {
+		return api.Item{}, nil, false
+	}
+	return it.Item, it.data, true
+}
+
+// mutate changes an item directly on the server, e.g. to simulate edits by another client
+func (f *fakeAPI) mutate(uid string, fn func(it *api.Item, data *[]byte)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	it := f.items[uid]
+	if it == nil {
+		f.t.Fatalf("No item %s", uid)
+	}
+	fn(&it.Item, &it.data)
+	it.Filesize = uint64(len(it.data))
+}
+
+// folderUID returns the UID of the folder with the given ID
+func (f *fakeAPI) folderUID(id uint64) string {
+	return fmt.Sprintf("folder-%d", id)
+}
+
+// fileUID returns the UID of the file name in the folder parentID
+func (f *fakeAPI) fileUID(parentID uint64, name string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for uid, it := range f.items {
+		if it.ParentID == parentID && it.Filename == name && it.IsFolder == 0 {
+			return uid
+		}
+	}
+	f.t.Fatalf("No file %s in folder %d", name, parentID)
+	return ""
+}
+
+// count returns how many requests went to path
+func (f *fakeAPI) count(path string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, c := range f.calls {
+		if c.Path == path {
+			n++
+		}
+	}
+	return n
+}
+
+// trashed returns how many items are in the trash
+func (f *fakeAPI) trashed() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, it := range f.items {
+		if it.trashed {
+			n++
+		}
+	}
+	return n
+}
+
+// lastCall returns the last request to path
+func (f *fakeAPI) lastCall(path string) (fakeCall, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := len(f.calls) - 1; i >= 0; i-- {
+		if f.calls[i].Path == path {
+			return f.calls[i], true
+		}
+	}
+	return fakeCall{}, false
+}
+
+// callsTo returns all requests to path in order
+func (f *fakeAPI) callsTo(path string) []fakeCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []fakeCall
+	for _, c := range f.calls {
+		if c.Path == path {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func (f *fakeAPI) resetCalls() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = nil
+}
+
+func (f *fakeAPI) serve(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	if strings.HasPrefix(path, "/geo-fileserver-list") {
+		path = "/geo-fileserver-list"
+	}
+	form := url.Values{}
+	for k, v := range r.URL.Query() {
+		form[k] = v
+	}
+	var file []byte
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		if err := r.ParseMultipartForm(64 << 20); err == nil {
+			for k, v := range r.MultipartForm.Value {
+				form[k] = v
+			}
+			if fhs := r.MultipartForm.File["files[]"]; len(fhs) > 0 {
+				fr, _ := fhs[0].Open()
+				file, _ = io.ReadAll(fr)
+				fr.Close()
+				form.Set("upload_name", fhs[0].Filename)
+			}
+		}
+	} else if r.Method == http.MethodPost {
+		if err := r.ParseForm(); err == nil {
+			for k, v := range r.PostForm {
+				form[k] = v
+			}
+		}
+	}
+
+	f.mu.Lock()
+	f.calls = append(f.calls, fakeCall{
+		Path:          path,
+		Query:         r.URL.RawQuery,
+		ContentType:   r.Header.Get("Content-Type"),
+		Authorization: r.Header.Get("Authorization"),
+		Form:          form,
+	})
+	fail := f.fail
+	f.mu.Unlock()
+	if fail != nil && fail(path, form) {
+		http.Error(w, "injected failure", http.StatusInternalServerError)
+		return
+	}
+
+	if strings.HasPrefix(path, "/dl/") {
+		f.serveDownload(w, r, strings.TrimPrefix(path, "/dl/"))
+		return
+	}
+
+	f.mu.Lock()
+	resp, status := f.handleLocked(path, form, file)
+	f.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (f *fakeAPI) serveDownload(w http.ResponseWriter, r *http.Request, uid string) {
+	_, data, ok := f.item(uid)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeContent(w, r, uid, time.Time{}, bytes.NewReader(data))
+}
+
+type fakeResp map[string]interface{}
+
+func (f *fakeAPI) handleLocked(path string, form url.Values, file []byte) (interface{}, int) {
+	ok := fakeResp{"error": false}
+	switch path {
+	case "/api":
+		if form.Get("request") == "pow-new" {
+			return api.POWChallenge{
+				Challenge:      base64.RawURLEncoding.EncodeToString([]byte("challenge")),
+				DifficultyBits: 1,
+				Token:          "pow",
+				Scope:          form.Get("scope"),
+			}, 200
+		}
+		return fakeResp{"error": false, "token": "token"}, 200
+	case "/user-data":
+		return fakeResp{"id": 1, "email": "test@example.com"}, 200
+	case "/user-stats":
+		used := f.storageExtra
+		for _, it := range f.items {
+			used += it.Filesize
+		}
+		return fakeResp{"error": false, "storage": fakeResp{"used": used}}, 200
+	case "/crypto-auth":
+		if v := form.Get("hash"); v != "" {
+			if !f.cryptoAuthReadOnly {
+				f.cryptoAuth = v
+			}
+			return ok, 200
+		}
+		return fakeResp{"error": false, "hash": f.cryptoAuth}, 200
+	case "/geo-fileserver-list":
+		return fakeResp{"error": false, "upload_endpoints": []string{f.srv.URL + "/upload"}}, 200
+	case "/upload":
+		folderID, _ := strconv.ParseUint(form.Get("folderId"), 10, 64)
+		moddate, _ := strconv.ParseFloat(form.Get("moddate"), 64)
+		name := form.Get("upload_name")
+		crypto := form.Get("crypto") == "1"
+		if crypto {
+			name = form.Get("custom_filename")
+		}
+		it := f.addItemLocked(folderID, name, false, crypto, file, uint64(moddate))
+		return fakeResp{"error": false, "id": it.ID, "folderId": folderID, "fileObj": fakeResp{
+			"id": it.ID, "uid": it.UID, "filename": it.Filename, "filesize": it.Filesize,
+			"moddate": it.Moddate, "crypto": it.Crypto, "folderId": folderID,
+		}}, 200
+	case "/collection":
+		folderID, _ := strconv.ParseUint(form.Get("folderId"), 10, 64)
+		if form.Get("type") == "trash" {
+			data := f.trashLocked()
+			return fakeResp{"error": false, "id": folderID, "results": len(data), "data": data}, 200
+		}
+		crypto := form.Get("type") == "crypto"
+		data := f.childrenLocked(folderID, crypto)
+		return fakeResp{"error": false, "id": folderID, "results": len(data), "data": data}, 200
+	case "/folder-properties":
+		it := f.items[form.Get("id")]
+		if it == nil || it.IsFolder != 1 {
+			return fakeResp{"error": true, "message": "not found"}, 200
+		}
+		files, folders, size := f.totalsLocked(it.ID, it.Crypto == 1)
+		return fakeResp{"error": false, "isFolder": 1, "folderId": it.ID, "filename": it.Filename,
+			"moddate": it.Moddate, "num_files": files, "num_folders": folders, "total_size": size}, 200
+	case "/download-multi":
+		var urls []fakeResp
+		for _, uid := range strings.Split(form.Get("items"), ",") {
+			it := f.items[uid]
+			if it == nil || it.IsFolder == 1 {
+				return fakeResp{"error": true, "message": "no such file " + uid}, 200
+			}
+			urls = append(urls, fakeResp{"id": it.ID, "filename": it.Filename, "filesize": it.Filesize,
+				"folderId": it.ParentID, "moddate": it.Moddate, "url": f.srv.URL + "/dl/" + it.UID})
+		}
+		return fakeResp{"error": false, "urls": urls}, 200
+	case "/erase":
+		for _, uid := range strings.Split(form.Get("items"), ",") {
+			if it := f.items[uid]; it != nil {
+				delete(f.items, uid)
+				f.touchLocked(it.ParentID, uint64(time.Now().Unix()))
+			}
+		}
+		return ok, 200
+	case "/trash-add":
+		for _, uid := range strings.Split(form.Get("items"), ",") {
+			if it := f.items[uid]; it != nil {
+				it.trashed = true
+			}
+		}
+		return ok, 200
+	case "/fave-add", "/fave-remove":
+		for _, uid := range strings.Split(form.Get("items"), ",") {
+			if it := f.items[uid]; it != nil {
+				it.Fave = 0
+				if path == "/fave-add" {
+					it.Fave = 1
+				}
+			}
+		}
+		return ok, 200
+	case "/folder-color":
+		for _, uid := range strings.Split(form.Get("items"), ",") {
+			if it := f.items[uid]; it != nil {
+				it.Color = form.Get("color")
+			}
+		}
+		return ok, 200
+	case "/public-link-create":
+		it := f.items[form.Get("items")]
+		if it == nil {
+			return fakeResp{"error": true, "message": "not found"}, 200
+		}
+		expires, _ := strconv.ParseUint(form.Get("expires"), 10, 64)
+		id := "s-" + it.UID
+		f.links[id] = &fakeLink{uid: it.UID, password: form.Get("password"), expires: expires}
+		return fakeResp{"error": false, "link": f.linkLocked(id)}, 200
+	case "/public-links":
+		var data []fakeResp
+		for _, id := range sortedLinkIDs(f.links) {
+			data = append(data, f.linkLocked(id))
+		}
+		return fakeResp{"error": false, "data": data}, 200
+	case "/public-link":
+		if _, ok := f.links["s-"+form.Get("id")]; !ok {
+			return fakeResp{"error": true, "message": "not shared"}, 200
+		}
+		return fakeResp{"error": false, "link": f.linkLocked("s-" + form.Get("id"))}, 200
+	case "/public-link-remove":
+		for _, uid := range strings.Split(form.Get("items"), ",") {
+			delete(f.links, "s-"+uid)
+		}
+		return ok, 200
+	case "/public-share", "/public-download":
+		link := f.links[form.Get("id")]
+		if link == nil {
+			return fakeResp{"error": true, "message": "no such share"}, 200
+		}
+		if link.password != form.Get("password") {
+			return fakeResp{"error": true, "message": "wrong password"}, 200
+		}
+		shared := f.items[link.uid]
+		if path == "/public-download" {
+			var urls []fakeResp
+			for _, uid := range strings.Split(form.Get("items"), ",") {
+				it := f.items[uid]
+				if it == nil || it.IsFolder == 1 {
+					return fakeResp{"error": true, "message": "no such file " + uid}, 200
+				}
+				urls = append(urls, fakeResp{"id": it.ID, "filename": it.Filename, "filesize": it.Filesize,
+					"folderId": it.ParentID, "moddate": it.Moddate, "url": f.srv.URL + "/dl/" + it.UID})
+			}
+			return fakeResp{"error": false, "urls": urls}, 200
+		}
+		folderID, _ := strconv.ParseUint(form.Get("folderId"), 10, 64)
+		switch {
+		case folderID != 0:
+			return fakeResp{"error": false, "id": folderID, "data": f.childrenLocked(folderID, false)}, 200
+		case shared.IsFolder == 1:
+			return fakeResp{"error": false, "id": shared.ID, "data": f.childrenLocked(shared.ID, false)}, 200
+		}
+		return fakeResp{"error": false, "data": []api.Item{shared.Item}}, 200
+	case "/file-rename", "/folder-rename":
+		it := f.items[form.Get("id")]
+		if it == nil {
+			return fakeResp{"error": true, "message": "not found"}, 200
+		}
+		it.Filename = form.Get("filename")
+		return ok, 200
+	case "/folder-create":
+		parentID, _ := strconv.ParseUint(form.Get("parentId"), 10, 64)
+		it := f.addItemLocked(parentID, form.Get("filename"), true, form.Get("crypto") == "1", nil, uint64(time.Now().Unix()))
+		return fakeResp{"error": false, "id": it.ID}, 200
+	case "/move":
+		folderID, _ := strconv.ParseUint(form.Get("folderId"), 10, 64)
+		for _, uid := range strings.Split(form.Get("items"), ",") {
+			if it := f.items[uid]; it != nil {
+				it.ParentID = folderID
+			}
+		}
+		return ok, 200
+	}
+	return fakeResp{"error": true, "message": "unknown request " + path}, 404
+}
+
+func (f *fakeAPI) linkLocked(id string) fakeResp {
+	link := f.links[id]
+	it := f.items[link.uid]
+	protected := 0
+	if link.password != "" {
+		protected = 1
+	}
+	return fakeResp{"id": id, "uid": it.UID, "filename": it.Filename, "isFolder": it.IsFolder,
+		"filesize": it.Filesize, "url": f.srv.URL + "/s/" + id, "password": protected, "expires": link.expires}
+}
+
+func sortedLinkIDs(links map[string]*fakeLink) []string {
+	ids := make([]string, 0, len(links))
+	for id := range links {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (f *fakeAPI) childrenLocked(folderID uint64, crypto bool) []api.Item {
+	var out []api.Item
+	for _, it := range f.items {
+		if it.ParentID == folderID && !it.trashed && (it.Crypto == 1) == crypto {
+			out = append(out, it.Item)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// trashLocked returns the items moved to the trash, whose contents are not listed separately
+func (f *fakeAPI) trashLocked() []api.Item {
+	var out []api.Item
+	for _, it := range f.items {
+		if it.trashed {
+			out = append(out, it.Item)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+func (f *fakeAPI) totalsLocked(folderID uint64, crypto bool) (files, folders int, size uint64) {
+	for _, it := range f.childrenLocked(folderID, crypto) {
+		if it.IsFolder == 1 {
+			fi, fo, s := f.totalsLocked(it.ID, crypto)
+			files, folders, size = files+fi, folders+fo+1, size+s
+		} else {
+			files++
+			size += it.Filesize
+		}
+	}
+	return files, folders, size
+}
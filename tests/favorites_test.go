@@ -0,0 +1,120 @@
+package tests
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/StarHack/go-icedrive/api"
+	"github.com/StarHack/go-icedrive/client"
+)
+
+func TestItemColor(t *testing.T) {
+	cases := []struct {
+		color interface{}
+		want  string
+	}{
+		{nil, ""},
+		{"blue", "blue"},
+		{float64(3), "3"},
+	}
+	for _, tc := range cases {
+		if got := api.ItemColor(api.Item{Color: tc.color}); got != tc.want {
+			t.Errorf("ItemColor(%v) = %q, want %q", tc.color, got, tc.want)
+		}
+	}
+}
+
+// checkItemsRequest checks the multipart body of a bulk item request
+func checkItemsRequest(t *testing.T, call fakeCall, request, items, crypto string) {
+	t.Helper()
+	if !strings.HasPrefix(call.ContentType, "multipart/form-data; boundary=----geckoformboundary") {
+		t.Errorf("%s: unexpected content type %q", request, call.ContentType)
+	}
+	if got := call.Form.Get("request"); got != request {
+		t.Errorf("%s: request field is %q", request, got)
+	}
+	if got := call.Form.Get("items"); got != items {
+		t.Errorf("%s: items field is %q, want %q", request, got, items)
+	}
+	if got := call.Form.Get("crypto"); got != crypto {
+		t.Errorf("%s: crypto field is %q, want %q", request, got, crypto)
+	}
+}
+
+func TestFavoritesRequests(t *testing.T) {
+	f := newFakeAPI(t)
+	c := f.newClient()
+	docs := f.addFolder(0, "docs")
+	a := f.addFile(docs, "a.txt", []byte("a"), 1700000000)
+	b := f.addFile(0, "b.txt", []byte("b"), 1700000000)
+	secret := api.Item{UID: "file-crypto", Crypto: 1}
+
+	// Plain and encrypted items go out in one request per collection
+	if err := c.AddFavorites(a, secret, b); err != nil {
+		t.Fatalf("AddFavorites failed: %v", err)
+	}
+	calls := f.callsTo("/fave-add")
+	if len(calls) != 2 {
+		t.Fatalf("Expected 2 fave-add requests, got %d", len(calls))
+	}
+	checkItemsRequest(t, calls[0], "fave-add", a.UID+","+b.UID, "")
+	checkItemsRequest(t, calls[1], "fave-add", secret.UID, "1")
+
+	folder, _, _ := f.item(f.folderUID(docs))
+	if err := c.AddFavorites(folder); err != nil {
+		t.Fatalf("AddFavorites failed: %v", err)
+	}
+	results, err := c.ListFavorites(client.SearchOptions{Cloud: true})
+	if err != nil {
+		t.Fatalf("ListFavorites failed: %v", err)
+	}
+	var paths []string
+	for _, r := range results {
+		paths = append(paths, r.Path)
+	}
+	if want := []string{"/b.txt", "/docs", "/docs/a.txt"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("ListFavorites returned %v, want %v", paths, want)
+	}
+
+	if err := c.RemoveFavorites(b); err != nil {
+		t.Fatalf("RemoveFavorites failed: %v", err)
+	}
+	call, _ := f.lastCall("/fave-remove")
+	checkItemsRequest(t, call, "fave-remove", b.UID, "")
+	if item, _, _ := f.item(b.UID); item.Fave != 0 {
+		t.Error("Expected b.txt to be no favorite anymore")
+	}
+}
+
+func TestSetFolderColorRequest(t *testing.T) {
+	f := newFakeAPI(t)
+	c := f.newClient()
+	docs := f.addFolder(0, "docs")
+	folder, _, _ := f.item(f.folderUID(docs))
+
+	if err := c.SetFolderColor("blue", folder); err != nil {
+		t.Fatalf("SetFolderColor failed: %v", err)
+	}
+	call, _ := f.lastCall("/folder-color")
+	checkItemsRequest(t, call, "folder-color", folder.UID, "")
+	if got := call.Form.Get("color"); got != "blue" {
+		t.Errorf("Expected color field blue, got %q", got)
+	}
+	items, err := c.ListFolder(0)
+	if err != nil {
+		t.Fatalf("ListFolder failed: %v", err)
+	}
+	if got := api.ItemColor(*findItemByName(items, "docs")); got != "blue" {
+		t.Errorf("Expected the folder to be listed as blue, got %q", got)
+	}
+
+	file := f.addFile(0, "a.txt", []byte("a"), 1700000000)
+	f.resetCalls()
+	if err := c.SetFolderColor("red", folder, file); err == nil {
+		t.Error("Expected coloring a file to fail")
+	}
+	if n := f.count("/folder-color"); n != 0 {
+		t.Errorf("Expected no request when a file is passed, got %d", n)
+	}
+}
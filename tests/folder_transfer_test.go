@@ -0,0 +1,199 @@
+package tests
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/StarHack/go-icedrive/client"
+)
+
+func TestFolderUploadDownloadRoundTrip(t *testing.T) {
+	skipIfNoCredentials(t)
+
+	c := client.NewClient()
+	if err := c.LoginWithUsernameAndPassword(testEmail, testPassword); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+
+	t.Log("Step 1: Build local tree")
+	localDir := t.TempDir()
+	mtime := time.Unix(1700000000, 0)
+	files := map[string]string{
+		"a.txt":           "alpha",
+		"sub/b.txt":       "bravo",
+		"sub/deep/c.txt":  "charlie",
+		"sub/skip.tmp":    "ignored by .icedriveignore",
+		"excluded/d.txt":  "excluded by glob",
+		".icedriveignore": "*.tmp\n",
+	}
+	for name, content := range files {
+		p := filepath.Join(localDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		if err := os.Chtimes(p, mtime, mtime); err != nil {
+			t.Fatalf("Failed to set mtime: %v", err)
+		}
+	}
+
+	t.Log("Step 2: Create remote folder and upload")
+	dirName := fmt.Sprintf("test_dir_transfer_%d", time.Now().Unix())
+	if err := c.CreateFolder(0, dirName); err != nil {
+		t.Fatalf("Failed to create folder: %v", err)
+	}
+	time.Sleep(1 * time.Second)
+	items, err := c.ListFolder(0)
+	if err != nil {
+		t.Fatalf("Failed to list root: %v", err)
+	}
+	remoteDir := findItemByName(items, dirName)
+	if remoteDir == nil {
+		t.Fatalf("Created folder not found")
+	}
+	defer c.Delete(*remoteDir)
+
+	up, err := c.UploadDir(localDir, client.FolderID(*remoteDir), client.UploadDirOptions{Exclude: []string{"excluded"}})
+	if err != nil {
+		t.Fatalf("UploadDir failed: %v", err)
+	}
+	if err := up.Err(); err != nil {
+		t.Fatalf("UploadDir reported failures: %v", err)
+	}
+	if up.Uploaded != 3 {
+		t.Errorf("Expected 3 uploaded files, got %d: %+v", up.Uploaded, up.Results)
+	}
+
+	t.Log("Step 3: Download and compare")
+	time.Sleep(2 * time.Second)
+	downDir := t.TempDir()
+	down, err := c.DownloadDir(client.FolderID(*remoteDir), downDir, client.DownloadDirOptions{})
+	if err != nil {
+		t.Fatalf("DownloadDir failed: %v", err)
+	}
+	if err := down.Err(); err != nil {
+		t.Fatalf("DownloadDir reported failures: %v", err)
+	}
+	for _, name := range []string{"a.txt", "sub/b.txt", "sub/deep/c.txt"} {
+		p := filepath.Join(downDir, filepath.FromSlash(name))
+		data, err := os.ReadFile(p)
+		if err != nil {
+			t.Errorf("Missing downloaded file %s: %v", name, err)
+			continue
+		}
+		if string(data) != files[name] {
+			t.Errorf("Content mismatch for %s", name)
+		}
+		if fi, err := os.Stat(p); err == nil && !fi.ModTime().Equal(mtime) {
+			t.Errorf("Mtime of %s not preserved: %v", name, fi.ModTime())
+		}
+	}
+
+	t.Log("Step 4: Download again, everything should be skipped")
+	again, err := c.DownloadDir(client.FolderID(*remoteDir), downDir, client.DownloadDirOptions{SkipIdentical: true})
+	if err != nil {
+		t.Fatalf("Second DownloadDir failed: %v", err)
+	}
+	if again.Downloaded != 0 || again.Skipped != 3 {
+		t.Errorf("Expected 3 skipped files, got %d downloaded and %d skipped", again.Downloaded, again.Skipped)
+	}
+
+	t.Log("Step 5: Stream the folder as tar and zip archives")
+	var tarOut bytes.Buffer
+	if err := c.WriteArchive(&tarOut, client.FolderID(*remoteDir), client.ArchiveOptions{Format: client.ArchiveTar, Prefix: "export"}); err != nil {
+		t.Fatalf("WriteArchive tar failed: %v", err)
+	}
+	found := map[string]bool{}
+	tr := tar.NewReader(&tarOut)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read tar: %v", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, _ := io.ReadAll(tr)
+		name := hdr.Name[len("export/"):]
+		found[name] = true
+		if string(data) != files[name] {
+			t.Errorf("Tar content mismatch for %s", hdr.Name)
+		}
+		if !hdr.ModTime.Equal(mtime) {
+			t.Errorf("Tar mtime of %s not preserved: %v", hdr.Name, hdr.ModTime)
+		}
+	}
+	if len(found) != 3 {
+		t.Errorf("Expected 3 files in tar, got %v", found)
+	}
+
+	var zipOut bytes.Buffer
+	if err := c.WriteArchive(&zipOut, client.FolderID(*remoteDir), client.ArchiveOptions{Format: client.ArchiveZip}); err != nil {
+		t.Fatalf("WriteArchive zip failed: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(zipOut.Bytes()), int64(zipOut.Len()))
+	if err != nil {
+		t.Fatalf("Failed to read zip: %v", err)
+	}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("Failed to open %s in zip: %v", f.Name, err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		if string(data) != files[f.Name] {
+			t.Errorf("Zip content mismatch for %s", f.Name)
+		}
+	}
+
+	t.Log("Step 6: Extract a tar archive into the remote folder")
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	for name, content := range map[string]string{"extracted/e.txt": "echo", "../escape.txt": "unsafe"} {
+		_ = tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0o644, Size: int64(len(content)), ModTime: mtime})
+		_, _ = tw.Write([]byte(content))
+	}
+	tw.Close()
+	extracted, err := c.ExtractArchive(&archive, client.FolderID(*remoteDir), client.ExtractOptions{Format: client.ArchiveTar})
+	if err != nil {
+		t.Fatalf("ExtractArchive failed: %v", err)
+	}
+	if extracted.Uploaded != 1 || extracted.Skipped != 1 {
+		t.Errorf("Expected 1 uploaded and 1 skipped entry, got %+v", extracted.Results)
+	}
+
+	t.Log("Step 7: Index the folder and refresh it incrementally")
+	idx, err := client.OpenIndex(filepath.Join(t.TempDir(), "index.json.gz"), client.FolderID(*remoteDir), false)
+	if err != nil {
+		t.Fatalf("OpenIndex failed: %v", err)
+	}
+	if _, err := idx.Refresh(c); err != nil {
+		t.Fatalf("Index refresh failed: %v", err)
+	}
+	if item, ok := idx.Lookup("/sub/deep/c.txt"); !ok || item.Filesize != uint64(len(files["sub/deep/c.txt"])) {
+		t.Errorf("Index lookup failed: %+v, %v", item, ok)
+	}
+	stats, err := idx.Refresh(c)
+	if err != nil {
+		t.Fatalf("Second index refresh failed: %v", err)
+	}
+	if stats.Listed != 1 || stats.Reused == 0 {
+		t.Errorf("Expected only the root to be listed again, got %+v", stats)
+	}
+}
@@ -0,0 +1,83 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/StarHack/go-icedrive/api"
+	"github.com/StarHack/go-icedrive/client"
+)
+
+func TestHashCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hashes.json")
+	cache, err := client.LoadHashCache(path)
+	if err != nil {
+		t.Fatalf("Failed to load empty cache: %v", err)
+	}
+
+	item := api.Item{UID: "file-1", Filesize: 10, Moddate: 1700000000}
+	cache.Put(item, "abc")
+	cache.Put(api.Item{UID: "file-2", Filesize: 5, Moddate: 1700000000}, "def")
+	cache.Forget("file-2")
+	if err := cache.Save(); err != nil {
+		t.Fatalf("Failed to save cache: %v", err)
+	}
+
+	loaded, err := client.LoadHashCache(path)
+	if err != nil {
+		t.Fatalf("Failed to reload cache: %v", err)
+	}
+	if sum, ok := loaded.Get(item); !ok || sum != "abc" {
+		t.Errorf("Expected cached hash abc, got %q, %v", sum, ok)
+	}
+	if _, ok := loaded.Get(api.Item{UID: "file-2", Filesize: 5, Moddate: 1700000000}); ok {
+		t.Error("Forgotten hash still present")
+	}
+
+	changed := item
+	changed.Moddate++
+	if _, ok := loaded.Get(changed); ok {
+		t.Error("Expected a changed moddate to invalidate the hash")
+	}
+	changed = item
+	changed.Filesize++
+	if _, ok := loaded.Get(changed); ok {
+		t.Error("Expected a changed size to invalidate the hash")
+	}
+}
+
+func TestCachedRemoteHash(t *testing.T) {
+	c := client.NewClient()
+	item := api.Item{UID: "file-1", Filesize: 10, Moddate: 1700000000}
+	if _, ok := c.CachedRemoteHash(item); ok {
+		t.Error("Expected no hash without a cache")
+	}
+	cache := client.NewHashCache()
+	cache.Put(item, "abc")
+	c.SetHashCache(cache)
+	if sum, err := c.HashRemote(item); err != nil || sum != "abc" {
+		t.Errorf("Expected HashRemote to use the cache, got %q, %v", sum, err)
+	}
+}
+
+func TestHashCacheIgnoresOtherAlgorithms(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hashes.json")
+	item := api.Item{UID: "file-1", Filesize: 10, Moddate: 1700000000}
+	files := map[string]string{
+		"other algorithm": `{"version":2,"files":{"file-1":{"size":10,"moddate":1700000000,"algorithm":"blake3","sum":"abc"}}}`,
+		"older version":   `{"version":1,"files":{"file-1":{"size":10,"moddate":1700000000,"sha256":"abc"}}}`,
+	}
+	for name, data := range files {
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatalf("Failed to write cache: %v", err)
+		}
+		cache, err := client.LoadHashCache(path)
+		if err != nil {
+			t.Fatalf("%s: failed to load cache: %v", name, err)
+		}
+		if sum, ok := cache.Get(item); ok {
+			t.Errorf("%s: expected no SHA-256 hash, got %q", name, sum)
+		}
+	}
+}
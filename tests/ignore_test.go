@@ -0,0 +1,201 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/StarHack/go-icedrive/client"
+)
+
+// TestIgnoreFileRules runs UploadDir against the fake API and checks which
+// files the .icedriveignore rules let through
+func TestIgnoreFileRules(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    map[string]string
+		noIgnore bool
+		want     []string
+	}{
+		{
+			name: "unanchored glob matches at any depth",
+			files: map[string]string{
+				".icedriveignore": "*.tmp\n",
+				"a.tmp":           "",
+				"a.txt":           "",
+				"sub/deep/b.tmp":  "",
+				"sub/deep/b.txt":  "",
+			},
+			want: []string{"a.txt", "sub/deep/b.txt"},
+		},
+		{
+			name: "leading slash anchors to the ignore file's directory",
+			files: map[string]string{
+				".icedriveignore": "/build\n",
+				"build/out.bin":   "",
+				"sub/build":       "",
+				"sub/build.txt":   "",
+			},
+			want: []string{"sub/build", "sub/build.txt"},
+		},
+		{
+			name: "inner slash anchors as well",
+			files: map[string]string{
+				".icedriveignore": "docs/*.md\n",
+				"docs/a.md":       "",
+				"docs/a.txt":      "",
+				"sub/docs/b.md":   "",
+			},
+			want: []string{"docs/a.txt", "sub/docs/b.md"},
+		},
+		{
+			name: "trailing slash matches directories only",
+			files: map[string]string{
+				".icedriveignore": "cache/\n",
+				"cache/a.txt":     "",
+				"sub/cache/b.txt": "",
+				"other/cache":     "a file named cache",
+			},
+			want: []string{"other/cache"},
+		},
+		{
+			name: "negation re-includes an earlier match",
+			files: map[string]string{
+				".icedriveignore": "*.tmp\n!keep.tmp\n",
+				"a.tmp":           "",
+				"keep.tmp":        "",
+				"sub/keep.tmp":    "",
+			},
+			want: []string{"keep.tmp", "sub/keep.tmp"},
+		},
+		{
+			name: "last matching line wins",
+			files: map[string]string{
+				".icedriveignore": "!keep.tmp\n*.tmp\n",
+				"keep.tmp":        "",
+				"a.txt":           "",
+			},
+			want: []string{"a.txt"},
+		},
+		{
+			name: "subdirectory rules are relative and extend the parent's",
+			files: map[string]string{
+				".icedriveignore":     "*.log\n",
+				"sub/.icedriveignore": "/local.txt\n!important.log\n",
+				"local.txt":           "",
+				"sub/local.txt":       "",
+				"sub/deep/local.txt":  "",
+				"sub/important.log":   "",
+				"sub/debug.log":       "",
+				"important.log":       "",
+			},
+			want: []string{"local.txt", "sub/deep/local.txt", "sub/important.log"},
+		},
+		{
+			name: "files below an ignored directory cannot be re-included",
+			files: map[string]string{
+				".icedriveignore": "cache/\n!cache/keep.txt\n",
+				"cache/keep.txt":  "",
+				"a.txt":           "",
+			},
+			want: []string{"a.txt"},
+		},
+		{
+			name: "comments and blank lines are skipped",
+			files: map[string]string{
+				".icedriveignore": "# *.txt\n\n   \n*.bak\n",
+				"a.txt":           "",
+				"a.bak":           "",
+			},
+			want: []string{"a.txt"},
+		},
+		{
+			name:     "NoIgnoreFile uploads everything including the ignore file",
+			noIgnore: true,
+			files: map[string]string{
+				".icedriveignore": "*.tmp\n",
+				"a.tmp":           "",
+			},
+			want: []string{".icedriveignore", "a.tmp"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeAPI(t)
+			c := f.newClient()
+			localDir := t.TempDir()
+			for name, content := range tt.files {
+				p := filepath.Join(localDir, filepath.FromSlash(name))
+				if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+					t.Fatalf("Failed to create dir: %v", err)
+				}
+				if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+					t.Fatalf("Failed to write file: %v", err)
+				}
+			}
+
+			summary, err := c.UploadDir(localDir, 0, client.UploadDirOptions{NoIgnoreFile: tt.noIgnore})
+			if err != nil {
+				t.Fatalf("UploadDir failed: %v", err)
+			}
+			if err := summary.Err(); err != nil {
+				t.Fatalf("UploadDir reported failures: %v", err)
+			}
+			var got []string
+			for _, r := range summary.Results {
+				if r.Status == client.UploadStatusUploaded {
+					got = append(got, r.RemotePath)
+				}
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Uploaded %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUploadDirCreatesFoldersLazily(t *testing.T) {
+	f := newFakeAPI(t)
+	c := f.newClient()
+	f.addFolder(0, "keep")
+	localDir := t.TempDir()
+	for name, content := range map[string]string{
+		".icedriveignore": "*.tmp\n",
+		"keep/a.txt":      "a",
+		"keep/deep/b.txt": "b",
+		"junk/a.tmp":      "",
+		"junk/deep/b.tmp": "",
+		"excluded/c.txt":  "c",
+	} {
+		p := filepath.Join(localDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(localDir, "empty"), 0o755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+
+	summary, err := c.UploadDir(localDir, 0, client.UploadDirOptions{Exclude: []string{"*/c.txt"}})
+	if err != nil {
+		t.Fatalf("UploadDir failed: %v", err)
+	}
+	if err := summary.Err(); err != nil || summary.Uploaded != 2 {
+		t.Fatalf("Expected 2 uploads without failures, got %d: %v", summary.Uploaded, err)
+	}
+	// Only the missing folder that receives a file is created; keep is reused
+	var created []string
+	for _, call := range f.callsTo("/folder-create") {
+		created = append(created, call.Form.Get("filename"))
+	}
+	if !reflect.DeepEqual(created, []string{"deep"}) {
+		t.Errorf("Created folders %v, want [deep]", created)
+	}
+}
@@ -0,0 +1,122 @@
+package tests
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/StarHack/go-icedrive/api"
+	"github.com/StarHack/go-icedrive/client"
+)
+
+func TestIndexRefresh(t *testing.T) {
+	f := newFakeAPI(t)
+	c := f.newClient()
+	root := f.addFolder(0, "tree")
+	a := f.addFolder(root, "a")
+	deep := f.addFolder(a, "deep")
+	b := f.addFolder(root, "b")
+	f.addFile(root, "top.txt", []byte("top"), 1700000000)
+	a1 := f.addFile(a, "a1.txt", []byte("a1"), 1700000000)
+	f.addFile(deep, "d.txt", []byte("deep"), 1700000000)
+	f.addFile(b, "b.txt", []byte("b"), 1700000000)
+
+	path := filepath.Join(t.TempDir(), "index.json.gz")
+	idx, err := client.OpenIndex(path, root, false)
+	if err != nil {
+		t.Fatalf("Failed to open empty index: %v", err)
+	}
+	if !idx.Refreshed().IsZero() {
+		t.Error("Expected a new index never to have been refreshed")
+	}
+	if err := idx.Walk(root, false, func(string, api.Item) error { return nil }); err == nil {
+		t.Error("Expected walking an unindexed folder to fail")
+	}
+
+	refresh := func(step string, want client.IndexRefresh) {
+		t.Helper()
+		f.resetCalls()
+		stats, err := idx.Refresh(c)
+		if err != nil {
+			t.Fatalf("%s: refresh failed: %v", step, err)
+		}
+		if stats != want {
+			t.Errorf("%s: got %+v, want %+v", step, stats, want)
+		}
+		if idx.Unverified() != want.Unverified {
+			t.Errorf("%s: index reports %d unverified folders", step, idx.Unverified())
+		}
+		if n := f.count("/folder-properties"); n != 0 {
+			t.Errorf("%s: %d properties requests made", step, n)
+		}
+		if n := f.count("/collection"); n != stats.Listed {
+			t.Errorf("%s: %d listing requests, %d reported", step, n, stats.Listed)
+		}
+	}
+	lookup := func(step, p string, want bool) {
+		t.Helper()
+		if _, ok := idx.Lookup(p); ok != want {
+			t.Errorf("%s: Lookup(%q) = %v, want %v", step, p, ok, want)
+		}
+	}
+
+	// New folders are listed
+	refresh("first refresh", client.IndexRefresh{Listed: 4})
+	lookup("first refresh", "/a/deep/d.txt", true)
+
+	if err := idx.Save(); err != nil {
+		t.Fatalf("Failed to save index: %v", err)
+	}
+	if idx, err = client.OpenIndex(path, root, false); err != nil {
+		t.Fatalf("Failed to reopen index: %v", err)
+	}
+	if _, err := client.OpenIndex(path, 7, false); err == nil {
+		t.Error("Expected an index of another folder to be rejected")
+	}
+
+	// Subfolders whose listed moddate is unchanged are reused; the moddate of
+	// deep is not listed again, so it is reported as unverified
+	refresh("unchanged", client.IndexRefresh{Listed: 1, Reused: 3, Unverified: 1})
+
+	// A file added below an unverified folder is missed
+	f.addFile(deep, "new.txt", []byte("new"), 1700000500)
+	refresh("file added deep down", client.IndexRefresh{Listed: 1, Reused: 3, Unverified: 1})
+	lookup("file added deep down", "/a/deep/new.txt", false)
+
+	// A change in a bumps its moddate, so it is listed along with deep
+	f.addFile(a, "a2.txt", []byte("a2"), 1700000600)
+	refresh("file added in a", client.IndexRefresh{Listed: 3, Reused: 1})
+	lookup("file added in a", "/a/a2.txt", true)
+	lookup("file added in a", "/a/deep/new.txt", true)
+
+	// A rename that leaves the moddate alone stays invisible
+	f.mutate(a1.UID, func(it *api.Item, _ *[]byte) { it.Filename = "renamed.txt" })
+	refresh("rename", client.IndexRefresh{Listed: 1, Reused: 3, Unverified: 1})
+	lookup("rename", "/a/a1.txt", true)
+	lookup("rename", "/a/renamed.txt", false)
+
+	// It is found once the server bumps the moddate of its folder
+	f.mutate(f.folderUID(a), func(it *api.Item, _ *[]byte) { it.Moddate = 1700000700 })
+	refresh("folder moddate bumped", client.IndexRefresh{Listed: 2, Reused: 2})
+	lookup("folder moddate bumped", "/a/renamed.txt", true)
+
+	// Rebuild lists everything and picks up what Refresh missed
+	f.mutate(f.fileUID(deep, "d.txt"), func(it *api.Item, data *[]byte) {
+		it.Moddate = 1700000800
+		*data = []byte("DEEP")
+	})
+	refresh("same-size replacement", client.IndexRefresh{Listed: 1, Reused: 3, Unverified: 1})
+	if item, _ := idx.Lookup("/a/deep/d.txt"); item.Moddate != 1700000000 {
+		t.Errorf("Expected the stale moddate, got %d", item.Moddate)
+	}
+	f.resetCalls()
+	stats, err := idx.Rebuild(c)
+	if err != nil {
+		t.Fatalf("Rebuild failed: %v", err)
+	}
+	if stats != (client.IndexRefresh{Listed: 4}) || f.count("/collection") != 4 || idx.Unverified() != 0 {
+		t.Errorf("Expected Rebuild to list 4 folders, got %+v", stats)
+	}
+	if item, _ := idx.Lookup("/a/deep/d.txt"); item.Moddate != 1700000800 {
+		t.Errorf("Expected Rebuild to pick up the replacement, got moddate %d", item.Moddate)
+	}
+}
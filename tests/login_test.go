@@ -0,0 +1,71 @@
+package tests
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/StarHack/go-icedrive/api"
+)
+
+// newLoginServer fakes the login endpoints, answering every login with the given body
+func newLoginServer(t *testing.T, answer string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api":
+			if err := r.ParseForm(); err != nil {
+				t.Errorf("ParseForm: %v", err)
+			}
+			switch r.PostForm.Get("request") {
+			case "pow-new":
+				_ = json.NewEncoder(w).Encode(api.POWChallenge{
+					Challenge:      base64.RawURLEncoding.EncodeToString([]byte("challenge")),
+					DifficultyBits: 1,
+					Token:          "pow",
+					Scope:          "login",
+				})
+			case "login":
+				if r.PostForm.Get("pow_proof") == "" {
+					t.Error("Login without a proof-of-work")
+				}
+				_, _ = w.Write([]byte(answer))
+			}
+		case "/user-data":
+			_, _ = w.Write([]byte(`{"id":1,"email":"test@example.com"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestLoginWithUsernameAndPassword(t *testing.T) {
+	srv := newLoginServer(t, `{"error":false,"token":"bearer"}`)
+	h := api.NewHTTPClientWithEnv()
+	h.SetApiBase(srv.URL)
+	user, err := api.LoginWithUsernameAndPassword(h, "test@example.com", "pw", "00")
+	if err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	if user.ID != 1 || h.GetBearerToken() != "bearer" {
+		t.Errorf("Unexpected result: user=%+v token=%q", user, h.GetBearerToken())
+	}
+}
+
+func TestLoginWithoutTokenReportsServerMessage(t *testing.T) {
+	srv := newLoginServer(t, `{"error":true,"message":"Please enter your 2FA code"}`)
+	h := api.NewHTTPClientWithEnv()
+	h.SetApiBase(srv.URL)
+	_, err := api.LoginWithUsernameAndPassword(h, "test@example.com", "pw", "00")
+	if err == nil || !strings.Contains(err.Error(), "Please enter your 2FA code") {
+		t.Errorf("Expected the server's message in the error, got %v", err)
+	}
+	if h.GetBearerToken() != "" {
+		t.Error("Expected no bearer token after a failed login")
+	}
+}
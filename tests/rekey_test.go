@@ -0,0 +1,193 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/StarHack/go-icedrive/api"
+	"github.com/StarHack/go-icedrive/client"
+)
+
+// setupCryptoVault fills the fake API with an encrypted vault under password
+// "old" and returns the plain contents by path
+func setupCryptoVault(t *testing.T, f *fakeAPI) map[string]string {
+	t.Helper()
+	oldKey, _ := api.DeriveCryptoKey("old", "salt")
+	stored, _ := api.CryptoKeyHash(oldKey, api.CryptoHashSHA256)
+	f.cryptoAuth = "ICE::" + stored + "::salt"
+
+	c := f.newClient()
+	if err := c.SetCryptoPassword("old"); err != nil || !c.CryptoKeyVerified() {
+		t.Fatalf("SetCryptoPassword failed: %v (verified=%v)", err, c.CryptoKeyVerified())
+	}
+	docs, err := c.MkdirAll(0, "docs", true)
+	if err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	want := map[string]string{
+		"a.txt":      "alpha",
+		"docs/b.txt": "bravo bravo",
+		"docs/c.txt": "charlie",
+	}
+	for p, content := range want {
+		folder := uint64(0)
+		if filepath.Dir(p) == "docs" {
+			folder = docs
+		}
+		opts := api.UploadOptions{Crypto: true, Size: int64(len(content))}
+		if _, err := c.UploadReader(folder, filepath.Base(p), bytes.NewReader([]byte(content)), opts); err != nil {
+			t.Fatalf("Upload of %s failed: %v", p, err)
+		}
+	}
+	return want
+}
+
+// readVault returns the decrypted contents of the vault by path
+func readVault(t *testing.T, c *client.Client) map[string]string {
+	t.Helper()
+	got := map[string]string{}
+	err := c.Walk(0, true, func(itemPath string, item api.Item) error {
+		if item.IsFolder == 1 {
+			return nil
+		}
+		r, err := c.DownloadFileEncryptedStream(item)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		data, err := io.ReadAll(r)
+		got[itemPath] = string(data)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Walk failed: %v", err)
+	}
+	return got
+}
+
+func TestRekeyInterruptedAndResumed(t *testing.T) {
+	f := newFakeAPI(t)
+	want := setupCryptoVault(t, f)
+	journal := filepath.Join(t.TempDir(), "rekey.json")
+	oldAuth := f.cryptoAuth
+
+	// The second old copy cannot be trashed: the run stops half-way
+	trashes := 0
+	f.fail = func(path string, form url.Values) bool {
+		if path == "/trash-add" {
+			trashes++
+			return trashes == 2
+		}
+		return false
+	}
+	c := f.newClient()
+	if err := c.SetCryptoPassword("old"); err != nil {
+		t.Fatalf("SetCryptoPassword failed: %v", err)
+	}
+	if err := c.RekeyCryptoVault("new", client.RekeyOptions{JournalPath: journal}); err == nil {
+		t.Fatal("Expected the interrupted re-key to fail")
+	}
+	if f.cryptoAuth != oldAuth {
+		t.Fatal("Stored hash must not change before the re-key completes")
+	}
+	if n := f.count("/erase"); n != 0 || f.trashed() != 1 {
+		t.Fatalf("Expected the first old copy in the trash and nothing erased, got %d trashed, %d erased", f.trashed(), n)
+	}
+	f.fail = nil
+
+	// A fresh client resumes with the old password
+	c = f.newClient()
+	if err := c.SetCryptoPassword("old"); err != nil || !c.CryptoKeyVerified() {
+		t.Fatalf("Old password must still verify before completion: %v", err)
+	}
+	uploads := f.count("/upload")
+	if err := c.RekeyCryptoVault("new", client.RekeyOptions{JournalPath: journal}); err != nil {
+		t.Fatalf("Resumed re-key failed: %v", err)
+	}
+	if n := f.count("/upload") - uploads; n != 1 {
+		t.Errorf("Expected only the unfinished file to be uploaded again, got %d uploads", n)
+	}
+	if n := f.count("/erase"); n != len(want) || f.trashed() != 0 {
+		t.Errorf("Expected all %d old copies erased from the trash, got %d erased, %d left", len(want), n, f.trashed())
+	}
+
+	// The server hash now belongs to the new password
+	c = f.newClient()
+	if err := c.SetCryptoPassword("new"); err != nil || !c.CryptoKeyVerified() {
+		t.Fatalf("New password does not verify after re-key: %v", err)
+	}
+	got := readVault(t, c)
+	if len(got) != len(want) {
+		t.Errorf("Expected %d files after re-key, got %v", len(want), got)
+	}
+	for p, content := range want {
+		if got[p] != content {
+			t.Errorf("%s: got %q, want %q", p, got[p], content)
+		}
+	}
+
+	// Running again with the same journal is a no-op
+	if err := c.SetCryptoPassword("old"); !errors.Is(err, api.ErrWrongCryptoPassword) {
+		t.Fatalf("Expected the old password to be rejected after re-key, got %v", err)
+	}
+	c.SkipCryptoHashCheck(true)
+	if err := c.SetCryptoPassword("old"); err != nil {
+		t.Fatalf("SetCryptoPassword failed: %v", err)
+	}
+	before := len(f.callsTo("/upload"))
+	if err := c.RekeyCryptoVault("new", client.RekeyOptions{JournalPath: journal}); err != nil {
+		t.Errorf("Completed journal should finish immediately: %v", err)
+	}
+	if len(f.callsTo("/upload")) != before {
+		t.Error("Completed journal must not upload again")
+	}
+}
+
+func TestRekeyRefusesUnverifiedHash(t *testing.T) {
+	f := newFakeAPI(t)
+	setupCryptoVault(t, f)
+	f.cryptoAuth = "ICE::0123abcd::salt"
+
+	c := f.newClient()
+	if err := c.SetCryptoPassword("old"); !errors.Is(err, api.ErrWrongCryptoPassword) {
+		t.Fatalf("Expected ErrWrongCryptoPassword, got %v", err)
+	}
+	c.SkipCryptoHashCheck(true)
+	if err := c.SetCryptoPassword("old"); err != nil {
+		t.Fatalf("SetCryptoPassword with the check skipped failed: %v", err)
+	}
+	f.resetCalls()
+	err := c.RekeyCryptoVault("new", client.RekeyOptions{JournalPath: filepath.Join(t.TempDir(), "rekey.json")})
+	if !errors.Is(err, client.ErrRekeyUnverified) {
+		t.Fatalf("Expected ErrRekeyUnverified, got %v", err)
+	}
+	if f.count("/upload")+f.count("/folder-rename")+f.count("/erase") != 0 {
+		t.Error("Nothing may change when the re-key is refused")
+	}
+}
+
+func TestRekeyKeepsOldCopiesWhenHashIsNotStored(t *testing.T) {
+	f := newFakeAPI(t)
+	want := setupCryptoVault(t, f)
+	oldAuth := f.cryptoAuth
+	f.cryptoAuthReadOnly = true
+
+	c := f.newClient()
+	if err := c.SetCryptoPassword("old"); err != nil {
+		t.Fatalf("SetCryptoPassword failed: %v", err)
+	}
+	err := c.RekeyCryptoVault("new", client.RekeyOptions{JournalPath: filepath.Join(t.TempDir(), "rekey.json")})
+	if !errors.Is(err, client.ErrRekeyHashNotStored) {
+		t.Fatalf("Expected ErrRekeyHashNotStored, got %v", err)
+	}
+	if f.cryptoAuth != oldAuth {
+		t.Error("Expected the stored hash to be unchanged")
+	}
+	if n := f.count("/erase"); n != 0 || f.trashed() != len(want) {
+		t.Errorf("Expected all %d old copies kept in the trash, got %d trashed, %d erased", len(want), f.trashed(), n)
+	}
+}
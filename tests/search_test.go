@@ -0,0 +1,128 @@
+package tests
+
+import (
+	"net/url"
+	"path"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StarHack/go-icedrive/api"
+	"github.com/StarHack/go-icedrive/client"
+)
+
+func TestSearchQueryMatch(t *testing.T) {
+	report := api.Item{Filename: "Report-2024.PDF", Filesize: 2048, Moddate: 1717200000, FileType: "document", Fave: 1}
+	folder := api.Item{Filename: "Reports", IsFolder: 1, Moddate: 1717200000}
+
+	cases := []struct {
+		name  string
+		query client.SearchQuery
+		item  api.Item
+		want  bool
+	}{
+		{"name substring ignores case", client.SearchQuery{Name: "report"}, report, true},
+		{"glob ignores case", client.SearchQuery{Glob: "report-*.pdf"}, report, true},
+		{"glob mismatch", client.SearchQuery{Glob: "*.txt"}, report, false},
+		{"regexp on path", client.SearchQuery{Regexp: regexp.MustCompile(`^/docs/`)}, report, true},
+		{"extension with dot", client.SearchQuery{Extensions: []string{".pdf"}}, report, true},
+		{"file type", client.SearchQuery{FileTypes: []string{"image"}}, report, false},
+		{"size range", client.SearchQuery{MinSize: 1024, MaxSize: 4096}, report, true},
+		{"too small", client.SearchQuery{MinSize: 4096}, report, false},
+		{"modified after", client.SearchQuery{ModifiedAfter: time.Unix(1700000000, 0)}, report, true},
+		{"modified before", client.SearchQuery{ModifiedBefore: time.Unix(1700000000, 0)}, report, false},
+		{"favorites", client.SearchQuery{Favorites: true}, report, true},
+		{"public", client.SearchQuery{Public: true}, report, false},
+		{"folders excluded by default", client.SearchQuery{Name: "report"}, folder, false},
+		{"folders included", client.SearchQuery{Name: "report", Folders: true}, folder, true},
+		{"size filters skip folders", client.SearchQuery{Folders: true, MinSize: 1}, folder, false},
+	}
+	for _, tc := range cases {
+		if got := tc.query.Match("/docs/"+tc.item.Filename, tc.item); got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestSearchRejectsBadGlob(t *testing.T) {
+	c := client.NewClient()
+	if _, err := c.Search(client.SearchQuery{Glob: "["}, client.SearchOptions{Cloud: true}); err == nil {
+		t.Error("Expected an error for an invalid glob")
+	}
+}
+
+func TestSearchCloudAndCrypto(t *testing.T) {
+	f := newFakeAPI(t)
+	setupCryptoVault(t, f)
+	c := f.newClient()
+	if err := c.SetCryptoPassword("old"); err != nil {
+		t.Fatalf("SetCryptoPassword failed: %v", err)
+	}
+	deep, err := c.MkdirAll(0, "docs/2024/q1", true)
+	if err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	if _, err := c.UploadReader(deep, "report.txt", strings.NewReader("delta"), api.UploadOptions{Crypto: true, Size: 5}); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	photos := f.addFolder(0, "photos")
+	trip := f.addFolder(photos, "trip")
+	day := f.addFolder(trip, "day 1")
+	f.addFile(0, "notes.txt", []byte("n"), 1700000000)
+	f.addFile(photos, "index.txt", []byte("i"), 1700000000)
+	f.addFile(trip, "b.jpg", []byte("b"), 1700000000)
+	f.addFile(day, "a.txt", []byte("a"), 1700000000)
+	f.addFile(day, "z.txt", []byte("z"), 1700000000)
+
+	want := []string{
+		"cloud:/notes.txt",
+		"cloud:/photos/index.txt",
+		"cloud:/photos/trip/day 1/a.txt",
+		"cloud:/photos/trip/day 1/z.txt",
+		"crypto:/a.txt",
+		"crypto:/docs/2024/q1/report.txt",
+		"crypto:/docs/b.txt",
+		"crypto:/docs/c.txt",
+	}
+	for _, concurrency := range []int{1, 4} {
+		results, err := c.Search(client.SearchQuery{Extensions: []string{"txt"}}, client.SearchOptions{Cloud: true, Crypto: true, Concurrency: concurrency})
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		var got []string
+		for _, r := range results {
+			got = append(got, string(r.Collection)+":"+r.Path)
+			if path.Base(r.Path) != r.Item.Filename {
+				t.Errorf("%s: result holds item %q", r.Path, r.Item.Filename)
+			}
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Concurrency %d: found %v, want %v", concurrency, got, want)
+		}
+	}
+
+	// Folders are matched by their full path too
+	results, err := c.Search(client.SearchQuery{Regexp: regexp.MustCompile(`^/docs/2024/`), Folders: true}, client.SearchOptions{Crypto: true})
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(results) != 2 || results[0].Path != "/docs/2024/q1" || results[1].Path != "/docs/2024/q1/report.txt" {
+		t.Errorf("Regexp search found %+v", results)
+	}
+
+	// A folder that cannot be listed is reported, the rest is still searched
+	f.fail = func(p string, form url.Values) bool {
+		return p == "/collection" && form.Get("folderId") == strconv.FormatUint(trip, 10)
+	}
+	results, err = c.Search(client.SearchQuery{Name: ".txt"}, client.SearchOptions{Cloud: true, Concurrency: 2})
+	if err == nil || !strings.Contains(err.Error(), `"/photos/trip"`) {
+		t.Errorf("Expected the failed listing to be reported, got %v", err)
+	}
+	if len(results) != 2 || results[0].Path != "/notes.txt" || results[1].Path != "/photos/index.txt" {
+		t.Errorf("Search with a failed listing found %+v", results)
+	}
+}
@@ -0,0 +1,213 @@
+package tests
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StarHack/go-icedrive/api"
+	"github.com/StarHack/go-icedrive/client"
+)
+
+func TestParsePublicLink(t *testing.T) {
+	cases := []struct {
+		link string
+		want string
+		ok   bool
+	}{
+		{"https://icedrive.net/s/abc123", "abc123", true},
+		{"https://icedrive.net/s/abc123/", "abc123", true},
+		{"  abc123 ", "abc123", true},
+		{"https://icedrive.net/", "", false},
+		{"", "", false},
+	}
+	for _, tc := range cases {
+		got, err := api.ParsePublicLink(tc.link)
+		if (err == nil) != tc.ok || got != tc.want {
+			t.Errorf("ParsePublicLink(%q) = %q, %v; want %q, ok=%v", tc.link, got, err, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestPublicLinksRejectCryptoItems(t *testing.T) {
+	item := api.Item{UID: "file-1", Filename: "secret.txt", Crypto: 1}
+	if err := api.RemovePublicLinks(nil, item); !errors.Is(err, api.ErrCryptoShare) {
+		t.Errorf("Expected ErrCryptoShare, got %v", err)
+	}
+}
+
+func TestNewPublicDownloader(t *testing.T) {
+	d, err := client.NewPublicDownloader("https://icedrive.net/s/abc123", "")
+	if err != nil {
+		t.Fatalf("NewPublicDownloader failed: %v", err)
+	}
+	if d.ShareID() != "abc123" {
+		t.Errorf("ShareID = %q, want abc123", d.ShareID())
+	}
+	if _, err := client.NewPublicDownloader("", ""); err == nil {
+		t.Error("Expected an error for an empty link")
+	}
+}
+
+func TestPublicLinkRequests(t *testing.T) {
+	f := newFakeAPI(t)
+	c := f.newClient()
+	item := f.addFile(0, "report.pdf", []byte("report"), 1700000000)
+	expires := time.Unix(1800000000, 0)
+
+	link, err := c.CreatePublicLink(item, api.PublicLinkOptions{Password: "secret", Expires: expires})
+	if err != nil {
+		t.Fatalf("CreatePublicLink failed: %v", err)
+	}
+	call, _ := f.lastCall("/public-link-create")
+	checkItemsRequest(t, call, "public-link-create", item.UID, "")
+	if call.Form.Get("password") != "secret" || call.Form.Get("expires") != "1800000000" {
+		t.Errorf("Unexpected password or expiry fields: %v", call.Form)
+	}
+	if link.UID != item.UID || link.Password != 1 || link.Expires != 1800000000 || !strings.HasSuffix(link.URL, "/"+link.ID) {
+		t.Errorf("Unexpected link: %+v", link)
+	}
+
+	links, err := c.ListPublicLinks()
+	if err != nil || len(links) != 1 || links[0].ID != link.ID {
+		t.Errorf("ListPublicLinks = %+v, %v; want the new link", links, err)
+	}
+	got, err := c.PublicLink(item)
+	if err != nil || got.ID != link.ID {
+		t.Errorf("PublicLink = %+v, %v; want the new link", got, err)
+	}
+	if call, _ := f.lastCall("/public-link"); call.Form.Get("id") != item.UID {
+		t.Errorf("Expected the item UID as id, got %v", call.Form)
+	}
+
+	if err := c.RevokePublicLinks(item); err != nil {
+		t.Fatalf("RevokePublicLinks failed: %v", err)
+	}
+	call, _ = f.lastCall("/public-link-remove")
+	checkItemsRequest(t, call, "public-link-remove", item.UID, "")
+	if links, err := c.ListPublicLinks(); err != nil || len(links) != 0 {
+		t.Errorf("Expected no links after revoking, got %+v, %v", links, err)
+	}
+
+	f.resetCalls()
+	if _, err := c.CreatePublicLink(api.Item{UID: "file-crypto", Crypto: 1}, api.PublicLinkOptions{}); !errors.Is(err, api.ErrCryptoShare) {
+		t.Errorf("Expected ErrCryptoShare, got %v", err)
+	}
+	if n := f.count("/public-link-create"); n != 0 {
+		t.Errorf("Expected no request for an encrypted item, got %d", n)
+	}
+}
+
+// sharedFolder creates a folder on the fake API, shares it with password and
+// returns a downloader for it
+func sharedFolder(t *testing.T, f *fakeAPI, password string) (uint64, *client.PublicDownloader) {
+	t.Helper()
+	root := f.addFolder(0, "shared")
+	folder, _, _ := f.item(f.folderUID(root))
+	link, err := f.newClient().CreatePublicLink(folder, api.PublicLinkOptions{Password: password})
+	if err != nil {
+		t.Fatalf("CreatePublicLink failed: %v", err)
+	}
+	d, err := client.NewPublicDownloader(link.URL, password)
+	if err != nil {
+		t.Fatalf("NewPublicDownloader failed: %v", err)
+	}
+	d.SetAPIBase(f.srv.URL)
+	return root, d
+}
+
+func TestPublicDownloaderDownloadAll(t *testing.T) {
+	f := newFakeAPI(t)
+	root, d := sharedFolder(t, f, "secret")
+	sub := f.addFolder(root, "sub")
+	f.addFile(root, "a.txt", []byte("alpha"), 1700000000)
+	f.addFile(sub, "b.txt", []byte("bravo"), 1700000100)
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte("compressed"))
+	zw.Close()
+	f.addFile(sub, "c.log"+client.CompressedSuffix, gz.Bytes(), 1700000200)
+	f.resetCalls()
+
+	dest := t.TempDir()
+	summary, err := d.DownloadAll(dest)
+	if err != nil {
+		t.Fatalf("DownloadAll failed: %v", err)
+	}
+	if err := summary.Err(); err != nil || summary.Downloaded != 3 {
+		t.Fatalf("Expected 3 downloads, got %d: %v", summary.Downloaded, err)
+	}
+	for name, want := range map[string]string{"a.txt": "alpha", "sub/b.txt": "bravo", "sub/c.log": "compressed"} {
+		p := filepath.Join(dest, filepath.FromSlash(name))
+		data, err := os.ReadFile(p)
+		if err != nil || string(data) != want {
+			t.Errorf("%s holds %q, %v; want %q", name, data, err, want)
+		}
+	}
+	if fi, err := os.Stat(filepath.Join(dest, "sub", "b.txt")); err != nil || fi.ModTime().Unix() != 1700000100 {
+		t.Errorf("Expected the modification time to be kept, got %v, %v", fi, err)
+	}
+
+	// Anonymous requests carry no token and keep the password out of the URL
+	for _, p := range []string{"/public-share", "/public-download"} {
+		calls := f.callsTo(p)
+		if len(calls) == 0 {
+			t.Errorf("No %s requests", p)
+		}
+		for _, call := range calls {
+			if call.Authorization != "" {
+				t.Errorf("%s sent a bearer token", p)
+			}
+			if strings.Contains(call.Query, "secret") || call.Form.Get("password") != "secret" {
+				t.Errorf("%s: expected the password in the body only, query %q", p, call.Query)
+			}
+			if !strings.HasPrefix(call.ContentType, "multipart/form-data") {
+				t.Errorf("%s: unexpected content type %q", p, call.ContentType)
+			}
+		}
+	}
+
+	wrong, err := client.NewPublicDownloader(d.ShareID(), "guess")
+	if err != nil {
+		t.Fatalf("NewPublicDownloader failed: %v", err)
+	}
+	wrong.SetAPIBase(f.srv.URL)
+	if _, err := wrong.List(0); err == nil {
+		t.Error("Expected a wrong password to fail")
+	}
+}
+
+func TestPublicDownloaderRejectsTraversal(t *testing.T) {
+	f := newFakeAPI(t)
+	root, d := sharedFolder(t, f, "")
+	f.addFile(root, "ok.txt", []byte("ok"), 1700000000)
+	evil := f.addFile(root, "../evil.txt", []byte("evil"), 1700000000)
+	up := f.addFolder(root, "..")
+	f.addFile(up, "evil2.txt", []byte("evil"), 1700000000)
+
+	parent := t.TempDir()
+	dest := filepath.Join(parent, "dest")
+	summary, err := d.DownloadAll(dest)
+	if err != nil {
+		t.Fatalf("DownloadAll failed: %v", err)
+	}
+	if summary.Downloaded != 1 || summary.Failed != 2 {
+		t.Errorf("Expected 1 download and 2 refused names, got %+v", summary.Results)
+	}
+	for _, name := range []string{"evil.txt", "evil2.txt"} {
+		if _, err := os.Stat(filepath.Join(parent, name)); err == nil {
+			t.Errorf("%s was written outside the destination", name)
+		}
+	}
+	if err := d.Download(evil, dest); err == nil {
+		t.Error("Expected Download to refuse an escaping name")
+	}
+	if _, err := os.Stat(filepath.Join(parent, "evil.txt")); err == nil {
+		t.Error("Download wrote outside the destination")
+	}
+}
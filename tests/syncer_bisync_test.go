@@ -0,0 +1,263 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StarHack/go-icedrive/api"
+	"github.com/StarHack/go-icedrive/syncer"
+)
+
+func TestParseConflictPolicy(t *testing.T) {
+	for _, policy := range []syncer.ConflictPolicy{syncer.ConflictNewerWins, syncer.ConflictKeepBoth, syncer.ConflictPrompt} {
+		parsed, err := syncer.ParseConflictPolicy(policy.String())
+		if err != nil || parsed != policy {
+			t.Errorf("Round trip of %v failed: %v, %v", policy, parsed, err)
+		}
+	}
+	if _, err := syncer.ParseConflictPolicy("coin-flip"); err == nil {
+		t.Error("Expected unknown policy to fail")
+	}
+}
+
+func TestPromptConflict(t *testing.T) {
+	var out strings.Builder
+	prompt := syncer.PromptConflict(strings.NewReader("what?\nb\n"), &out)
+	conflict := syncer.Conflict{
+		Path:         "docs/report.txt",
+		LocalSize:    10,
+		LocalModTime: time.Unix(1700000000, 0),
+		Remote:       api.Item{Filesize: 12, Moddate: 1700000100},
+	}
+	if got := prompt(conflict); got != syncer.ConflictKeepBoth {
+		t.Errorf("Expected keep-both, got %v", got)
+	}
+	if !strings.Contains(out.String(), "docs/report.txt") {
+		t.Errorf("Prompt does not name the file: %q", out.String())
+	}
+	if got := prompt(conflict); got != syncer.ConflictSkip {
+		t.Errorf("Expected skip at end of input, got %v", got)
+	}
+}
+
+func TestPlanBisync(t *testing.T) {
+	alpha := syncFile{"alpha", syncT0}
+	bravo := syncFile{"bravo", syncT0}
+	older := syncFile{"older", syncT0 + 60}
+	newer := syncFile{"newer!", syncT0 + 120}
+	base := map[string]syncFile{"a.txt": alpha}
+	keepRemote := func(syncer.Conflict) syncer.ConflictPolicy { return syncer.ConflictKeepRemote }
+	undecided := func(syncer.Conflict) syncer.ConflictPolicy { return syncer.ConflictPrompt }
+
+	runSyncCases(t, syncer.Bisync, []syncCase{
+		// One side changed
+		{
+			name:   "unchanged on both sides",
+			base:   base,
+			local:  map[string]syncFile{"a.txt": alpha},
+			remote: map[string]syncFile{"a.txt": alpha},
+		},
+		{
+			name:  "new locally",
+			local: map[string]syncFile{"a.txt": alpha},
+			want:  []string{"create remote a.txt (new locally)"},
+		},
+		{
+			name:   "new remotely",
+			remote: map[string]syncFile{"a.txt": alpha},
+			want:   []string{"create local a.txt (new remotely)"},
+		},
+		{
+			name:   "changed locally",
+			base:   base,
+			local:  map[string]syncFile{"a.txt": older},
+			remote: map[string]syncFile{"a.txt": alpha},
+			want:   []string{"update remote a.txt (changed locally)"},
+		},
+		{
+			name:   "changed remotely",
+			base:   base,
+			local:  map[string]syncFile{"a.txt": alpha},
+			remote: map[string]syncFile{"a.txt": older},
+			want:   []string{"update local a.txt (changed remotely)"},
+		},
+		{
+			name:   "touched locally is unchanged with Hash",
+			base:   base,
+			local:  map[string]syncFile{"a.txt": {"alpha", syncT0 + 60}},
+			remote: map[string]syncFile{"a.txt": alpha},
+			opts:   syncer.Options{Hash: true},
+		},
+		{
+			name:   "deleted locally",
+			base:   base,
+			remote: map[string]syncFile{"a.txt": alpha},
+			want:   []string{"delete remote a.txt"},
+		},
+		{
+			name:  "deleted remotely",
+			base:  base,
+			local: map[string]syncFile{"a.txt": alpha},
+			want:  []string{"delete local a.txt"},
+		},
+		{
+			name: "deleted on both sides",
+			base: base,
+		},
+
+		// Both sides changed
+		{
+			name:   "deleted locally but changed remotely",
+			base:   base,
+			remote: map[string]syncFile{"a.txt": older},
+			want:   []string{"create local a.txt (deleted locally but changed remotely)"},
+		},
+		{
+			name:  "deleted remotely but changed locally",
+			base:  base,
+			local: map[string]syncFile{"a.txt": older},
+			want:  []string{"create remote a.txt (deleted remotely but changed locally)"},
+		},
+		{
+			name:   "changed identically on both sides",
+			base:   base,
+			local:  map[string]syncFile{"a.txt": older},
+			remote: map[string]syncFile{"a.txt": older},
+		},
+		{
+			name:   "added identically on both sides",
+			local:  map[string]syncFile{"a.txt": alpha},
+			remote: map[string]syncFile{"a.txt": alpha},
+		},
+		{
+			name:   "newer wins keeps the newer local copy",
+			base:   base,
+			local:  map[string]syncFile{"a.txt": newer},
+			remote: map[string]syncFile{"a.txt": older},
+			want:   []string{"conflict a.txt: keep-local", "update remote a.txt (conflict, local kept)"},
+		},
+		{
+			name:   "newer wins keeps the newer remote copy",
+			base:   base,
+			local:  map[string]syncFile{"a.txt": older},
+			remote: map[string]syncFile{"a.txt": newer},
+			want:   []string{"conflict a.txt: keep-remote", "update local a.txt (conflict, remote kept)"},
+		},
+		{
+			name:   "newer wins keeps both at equal times",
+			base:   base,
+			local:  map[string]syncFile{"a.txt": {"local", syncT0 + 60}},
+			remote: map[string]syncFile{"a.txt": {"remote!", syncT0 + 60}},
+			want: []string{
+				"conflict a.txt: keep-both",
+				"rename local a.txt -> a (conflict).txt",
+				"create remote a (conflict).txt (conflict, local copy)",
+				"create local a.txt (conflict, remote copy)",
+			},
+		},
+		{
+			name:   "added differently on both sides is a conflict",
+			local:  map[string]syncFile{"a.txt": older},
+			remote: map[string]syncFile{"a.txt": newer},
+			want:   []string{"conflict a.txt: keep-remote", "update local a.txt (conflict, remote kept)"},
+		},
+		{
+			name:   "keep both",
+			base:   base,
+			local:  map[string]syncFile{"a.txt": newer},
+			remote: map[string]syncFile{"a.txt": older},
+			opts:   syncer.Options{Conflict: syncer.ConflictKeepBoth},
+			want: []string{
+				"conflict a.txt: keep-both",
+				"rename local a.txt -> a (conflict).txt",
+				"create remote a (conflict).txt (conflict, local copy)",
+				"create local a.txt (conflict, remote copy)",
+			},
+		},
+		{
+			name:   "keep local overrides the newer remote copy",
+			base:   base,
+			local:  map[string]syncFile{"a.txt": older},
+			remote: map[string]syncFile{"a.txt": newer},
+			opts:   syncer.Options{Conflict: syncer.ConflictKeepLocal},
+			want:   []string{"conflict a.txt: keep-local", "update remote a.txt (conflict, local kept)"},
+		},
+		{
+			name:   "keep remote overrides the newer local copy",
+			base:   base,
+			local:  map[string]syncFile{"a.txt": newer},
+			remote: map[string]syncFile{"a.txt": older},
+			opts:   syncer.Options{Conflict: syncer.ConflictKeepRemote},
+			want:   []string{"conflict a.txt: keep-remote", "update local a.txt (conflict, remote kept)"},
+		},
+		{
+			name:   "skip leaves both copies",
+			base:   base,
+			local:  map[string]syncFile{"a.txt": newer},
+			remote: map[string]syncFile{"a.txt": older},
+			opts:   syncer.Options{Conflict: syncer.ConflictSkip},
+			want:   []string{"conflict a.txt: skip"},
+		},
+		{
+			name:   "prompt is left open in a dry run",
+			base:   base,
+			local:  map[string]syncFile{"a.txt": newer},
+			remote: map[string]syncFile{"a.txt": older},
+			opts:   syncer.Options{Conflict: syncer.ConflictPrompt, Prompt: keepRemote, DryRun: true},
+			want:   []string{"conflict a.txt: prompt"},
+		},
+		{
+			name:   "prompt answer is applied",
+			base:   base,
+			local:  map[string]syncFile{"a.txt": newer},
+			remote: map[string]syncFile{"a.txt": older},
+			opts:   syncer.Options{Conflict: syncer.ConflictPrompt, Prompt: keepRemote},
+			want:   []string{"conflict a.txt: keep-remote", "update local a.txt (conflict, remote kept)"},
+		},
+		{
+			name:   "undecided prompt skips",
+			base:   base,
+			local:  map[string]syncFile{"a.txt": newer},
+			remote: map[string]syncFile{"a.txt": older},
+			opts:   syncer.Options{Conflict: syncer.ConflictPrompt, Prompt: undecided},
+			want:   []string{"conflict a.txt: skip"},
+		},
+
+		// Folders
+		{
+			name:   "new folders are created on the other side",
+			local:  map[string]syncFile{"l/": {}},
+			remote: map[string]syncFile{"r/": {}},
+			want:   []string{"mkdir remote l", "mkdir local r"},
+		},
+		{
+			name:   "folder deleted locally is deleted remotely",
+			base:   map[string]syncFile{"sub/a.txt": alpha, "sub/b.txt": bravo},
+			remote: map[string]syncFile{"sub/a.txt": alpha, "sub/b.txt": bravo},
+			want:   []string{"delete remote sub"},
+		},
+		{
+			name:  "folder deleted remotely is deleted locally",
+			base:  map[string]syncFile{"sub/a.txt": alpha, "sub/b.txt": bravo},
+			local: map[string]syncFile{"sub/a.txt": alpha, "sub/b.txt": bravo},
+			want:  []string{"delete local sub"},
+		},
+		{
+			name:   "folder deleted locally survives a remote change inside",
+			base:   map[string]syncFile{"sub/a.txt": alpha, "sub/b.txt": bravo},
+			remote: map[string]syncFile{"sub/a.txt": older, "sub/b.txt": bravo},
+			want: []string{
+				"mkdir local sub",
+				"create local sub/a.txt (deleted locally but changed remotely)",
+				"delete remote sub/b.txt",
+			},
+		},
+		{
+			name:   "excluded paths are ignored on both sides",
+			local:  map[string]syncFile{"a.tmp": alpha},
+			remote: map[string]syncFile{"b.tmp": bravo},
+			opts:   syncer.Options{Exclude: []string{"*.tmp"}},
+		},
+	})
+}
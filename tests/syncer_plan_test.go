@@ -0,0 +1,374 @@
+package tests
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StarHack/go-icedrive/api"
+	"github.com/StarHack/go-icedrive/client"
+	"github.com/StarHack/go-icedrive/syncer"
+)
+
+const syncT0 = int64(1700000000)
+
+// syncFile is the content and modification time of a file in a sync fixture
+type syncFile struct {
+	data  string
+	mtime int64
+}
+
+// syncCase describes both sides of a sync and the state of the last run.
+// Paths ending in "/" are empty folders.
+type syncCase struct {
+	name string
+	// base is what both sides held after the last sync, recorded in the state
+	base map[string]syncFile
+	// moved maps a base path to the remote path its file was moved to, keeping its UID
+	moved  map[string]string
+	local  map[string]syncFile
+	remote map[string]syncFile
+	opts   syncer.Options
+	want   []string
+}
+
+// planSync builds the case on a temporary directory and the fake API, indexes
+// the remote folder into Options.Index and returns the computed plan
+func planSync(t *testing.T, tc syncCase) *syncer.Plan {
+	t.Helper()
+	f := newFakeAPI(t)
+	c := f.newClient()
+	rootID := f.addFolder(0, "sync")
+	localDir := t.TempDir()
+
+	folders := map[string]uint64{".": rootID}
+	var mkdir func(p string) uint64
+	mkdir = func(p string) uint64 {
+		if id, ok := folders[p]; ok {
+			return id
+		}
+		id := f.addFolder(mkdir(path.Dir(p)), path.Base(p))
+		folders[p] = id
+		return id
+	}
+
+	// Files of the last sync that are still on the server keep their UID
+	uids := map[string]string{}
+	movedTo := map[string]string{}
+	for basePath, remotePath := range tc.moved {
+		movedTo[remotePath] = basePath
+	}
+	for p, spec := range tc.remote {
+		if strings.HasSuffix(p, "/") {
+			mkdir(strings.TrimSuffix(p, "/"))
+			continue
+		}
+		basePath := p
+		if from, ok := movedTo[p]; ok {
+			basePath = from
+		}
+		orig, inBase := tc.base[basePath]
+		if !inBase {
+			orig = spec
+		}
+		item := f.addFile(mkdir(path.Dir(p)), path.Base(p), []byte(orig.data), uint64(orig.mtime))
+		if inBase {
+			uids[basePath] = item.UID
+		}
+		if orig != spec {
+			f.mutate(item.UID, func(it *api.Item, data *[]byte) {
+				it.Moddate = uint64(spec.mtime)
+				*data = []byte(spec.data)
+			})
+		}
+	}
+
+	for p, spec := range tc.local {
+		full := filepath.Join(localDir, filepath.FromSlash(p))
+		if strings.HasSuffix(p, "/") {
+			if err := os.MkdirAll(full, 0o755); err != nil {
+				t.Fatalf("Failed to create dir: %v", err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(full, []byte(spec.data), 0o644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		mtime := time.Unix(spec.mtime, 0)
+		if err := os.Chtimes(full, mtime, mtime); err != nil {
+			t.Fatalf("Failed to set mtime: %v", err)
+		}
+	}
+
+	statePath := filepath.Join(localDir, syncer.StateFileName)
+	state, err := syncer.LoadState(statePath, rootID, false)
+	if err != nil {
+		t.Fatalf("Failed to load state: %v", err)
+	}
+	for p, spec := range tc.base {
+		uid, ok := uids[p]
+		if !ok {
+			uid = "file-gone"
+		}
+		sum := sha256.Sum256([]byte(spec.data))
+		state.Set(p, &syncer.Entry{
+			Size:          int64(len(spec.data)),
+			ModTime:       spec.mtime,
+			UID:           uid,
+			RemoteModdate: uint64(spec.mtime),
+			SHA256:        hex.EncodeToString(sum[:]),
+		})
+	}
+	if err := state.Save(); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+
+	idx, err := client.OpenIndex(filepath.Join(t.TempDir(), "index.json.gz"), rootID, false)
+	if err != nil {
+		t.Fatalf("Failed to open index: %v", err)
+	}
+	if _, err := idx.Refresh(c); err != nil {
+		t.Fatalf("Failed to refresh index: %v", err)
+	}
+
+	opts := tc.opts
+	opts.LocalDir = localDir
+	opts.FolderID = rootID
+	opts.Index = idx
+	s, err := syncer.New(c, opts)
+	if err != nil {
+		t.Fatalf("Failed to create syncer: %v", err)
+	}
+	plan, err := s.Plan()
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	return plan
+}
+
+// conflictStamp is the time in the names of conflict copies
+var conflictStamp = regexp.MustCompile(`\(conflict [^)]*\)`)
+
+// planLines renders the conflicts and actions of a plan without sizes, which
+// the cases don't care about, and without the time of conflict copies
+func planLines(plan *syncer.Plan) []string {
+	var lines []string
+	for _, c := range plan.Conflicts {
+		lines = append(lines, fmt.Sprintf("conflict %s: %s", c.Path, c.Resolution))
+	}
+	for _, a := range plan.Actions {
+		a.Path, a.OldPath = conflictStamp.ReplaceAllString(a.Path, "(conflict)"), conflictStamp.ReplaceAllString(a.OldPath, "(conflict)")
+		switch a.Type {
+		case syncer.ActionRename:
+			lines = append(lines, fmt.Sprintf("%s %s %s -> %s", a.Type, a.Target, a.OldPath, a.Path))
+		case syncer.ActionCreate, syncer.ActionUpdate:
+			lines = append(lines, fmt.Sprintf("%s %s %s (%s)", a.Type, a.Target, a.Path, a.Reason))
+		default:
+			lines = append(lines, fmt.Sprintf("%s %s %s", a.Type, a.Target, a.Path))
+		}
+	}
+	return lines
+}
+
+func runSyncCases(t *testing.T, direction syncer.Direction, tests []syncCase) {
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.opts.Direction = direction
+			got := planLines(planSync(t, tc))
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Plan:\n  %s\nwant:\n  %s", strings.Join(got, "\n  "), strings.Join(tc.want, "\n  "))
+			}
+		})
+	}
+}
+
+func TestPlanUpload(t *testing.T) {
+	alpha := syncFile{"alpha", syncT0}
+	bravo := syncFile{"bravo", syncT0}
+	runSyncCases(t, syncer.Upload, []syncCase{
+		{
+			name:  "new files and folders are created",
+			local: map[string]syncFile{"a.txt": alpha, "sub/b.txt": bravo, "empty/": {}},
+			want: []string{
+				"mkdir remote empty",
+				"mkdir remote sub",
+				"create remote a.txt (new)",
+				"create remote sub/b.txt (new)",
+			},
+		},
+		{
+			name:   "unchanged files are left alone",
+			base:   map[string]syncFile{"a.txt": alpha},
+			local:  map[string]syncFile{"a.txt": alpha},
+			remote: map[string]syncFile{"a.txt": alpha},
+		},
+		{
+			name:   "identical files without state are in sync",
+			local:  map[string]syncFile{"a.txt": alpha},
+			remote: map[string]syncFile{"a.txt": alpha},
+		},
+		{
+			name:   "modified file is updated",
+			base:   map[string]syncFile{"a.txt": alpha},
+			local:  map[string]syncFile{"a.txt": {"alpha2", syncT0 + 60}},
+			remote: map[string]syncFile{"a.txt": alpha},
+			want:   []string{"update remote a.txt (modified)"},
+		},
+		{
+			name:   "size change with the same time is updated",
+			base:   map[string]syncFile{"a.txt": alpha},
+			local:  map[string]syncFile{"a.txt": {"alphabet", syncT0}},
+			remote: map[string]syncFile{"a.txt": alpha},
+			want:   []string{"update remote a.txt (size changed)"},
+		},
+		{
+			name:   "touched file is updated without Hash",
+			base:   map[string]syncFile{"a.txt": alpha},
+			local:  map[string]syncFile{"a.txt": {"alpha", syncT0 + 60}},
+			remote: map[string]syncFile{"a.txt": alpha},
+			want:   []string{"update remote a.txt (modified)"},
+		},
+		{
+			name:   "touched file is in sync with Hash",
+			base:   map[string]syncFile{"a.txt": alpha},
+			local:  map[string]syncFile{"a.txt": {"alpha", syncT0 + 60}},
+			remote: map[string]syncFile{"a.txt": alpha},
+			opts:   syncer.Options{Hash: true},
+		},
+		{
+			name:   "deletions are propagated with Delete",
+			base:   map[string]syncFile{"a.txt": alpha, "sub/b.txt": bravo},
+			remote: map[string]syncFile{"a.txt": alpha, "sub/b.txt": bravo},
+			opts:   syncer.Options{Delete: true},
+			want:   []string{"delete remote a.txt", "delete remote sub"},
+		},
+		{
+			name:   "deletions are kept without Delete",
+			base:   map[string]syncFile{"a.txt": alpha},
+			remote: map[string]syncFile{"a.txt": alpha},
+		},
+		{
+			name:   "renamed file is moved",
+			base:   map[string]syncFile{"a.txt": alpha},
+			local:  map[string]syncFile{"b.txt": alpha},
+			remote: map[string]syncFile{"a.txt": alpha},
+			opts:   syncer.Options{Delete: true},
+			want:   []string{"rename remote a.txt -> b.txt"},
+		},
+		{
+			name:   "file moved to a new folder keeps its source folder",
+			base:   map[string]syncFile{"old/a.txt": alpha},
+			local:  map[string]syncFile{"new/b.txt": alpha},
+			remote: map[string]syncFile{"old/a.txt": alpha},
+			opts:   syncer.Options{Delete: true},
+			want:   []string{"mkdir remote new", "rename remote old/a.txt -> new/b.txt"},
+		},
+		{
+			name:   "renames are uploads without Delete",
+			base:   map[string]syncFile{"a.txt": alpha},
+			local:  map[string]syncFile{"b.txt": alpha},
+			remote: map[string]syncFile{"a.txt": alpha},
+			want:   []string{"create remote b.txt (new)"},
+		},
+		{
+			name:   "ambiguous renames are uploads",
+			base:   map[string]syncFile{"a.txt": alpha, "c.txt": {"gamma", syncT0}},
+			local:  map[string]syncFile{"b.txt": alpha},
+			remote: map[string]syncFile{"a.txt": alpha, "c.txt": {"gamma", syncT0}},
+			opts:   syncer.Options{Delete: true},
+			want:   []string{"create remote b.txt (new)", "delete remote a.txt", "delete remote c.txt"},
+		},
+		{
+			name:   "excluded paths are neither uploaded nor deleted",
+			local:  map[string]syncFile{"a.tmp": alpha, "build/x.txt": alpha, "c.txt": alpha},
+			remote: map[string]syncFile{"d.tmp": bravo, "build/y.txt": bravo},
+			opts:   syncer.Options{Delete: true, Exclude: []string{"*.tmp", "build"}},
+			want:   []string{"create remote c.txt (new)"},
+		},
+	})
+}
+
+func TestPlanDownload(t *testing.T) {
+	alpha := syncFile{"alpha", syncT0}
+	bravo := syncFile{"bravo", syncT0}
+	runSyncCases(t, syncer.Download, []syncCase{
+		{
+			name:   "new files and folders are created",
+			remote: map[string]syncFile{"a.txt": alpha, "sub/b.txt": bravo, "empty/": {}},
+			want: []string{
+				"mkdir local empty",
+				"mkdir local sub",
+				"create local a.txt (new)",
+				"create local sub/b.txt (new)",
+			},
+		},
+		{
+			name:   "unchanged files are left alone",
+			base:   map[string]syncFile{"a.txt": alpha},
+			local:  map[string]syncFile{"a.txt": alpha},
+			remote: map[string]syncFile{"a.txt": alpha},
+		},
+		{
+			name:   "modified file is updated",
+			base:   map[string]syncFile{"a.txt": alpha},
+			local:  map[string]syncFile{"a.txt": alpha},
+			remote: map[string]syncFile{"a.txt": {"alpha2", syncT0 + 60}},
+			want:   []string{"update local a.txt (modified)"},
+		},
+		{
+			name:   "touched remote file is in sync with Hash",
+			base:   map[string]syncFile{"a.txt": alpha},
+			local:  map[string]syncFile{"a.txt": {"alpha", syncT0 + 60}},
+			remote: map[string]syncFile{"a.txt": alpha},
+			opts:   syncer.Options{Hash: true},
+		},
+		{
+			name:  "deletions are propagated with Delete",
+			base:  map[string]syncFile{"a.txt": alpha, "sub/b.txt": bravo},
+			local: map[string]syncFile{"a.txt": alpha, "sub/b.txt": bravo},
+			opts:  syncer.Options{Delete: true},
+			want:  []string{"delete local a.txt", "delete local sub"},
+		},
+		{
+			name:  "deletions are kept without Delete",
+			base:  map[string]syncFile{"a.txt": alpha},
+			local: map[string]syncFile{"a.txt": alpha},
+		},
+		{
+			name:   "moved remote file is renamed locally",
+			base:   map[string]syncFile{"a.txt": alpha},
+			moved:  map[string]string{"a.txt": "sub/b.txt"},
+			local:  map[string]syncFile{"a.txt": alpha},
+			remote: map[string]syncFile{"sub/b.txt": alpha},
+			opts:   syncer.Options{Delete: true},
+			want:   []string{"mkdir local sub", "rename local a.txt -> sub/b.txt"},
+		},
+		{
+			name:   "moved remote file is downloaded if the local copy changed",
+			base:   map[string]syncFile{"a.txt": alpha},
+			moved:  map[string]string{"a.txt": "b.txt"},
+			local:  map[string]syncFile{"a.txt": {"alpha2", syncT0 + 60}},
+			remote: map[string]syncFile{"b.txt": alpha},
+			opts:   syncer.Options{Delete: true},
+			want:   []string{"create local b.txt (new)", "delete local a.txt"},
+		},
+		{
+			name:   "excluded paths are neither downloaded nor deleted",
+			local:  map[string]syncFile{"a.tmp": alpha, "build/x.txt": alpha},
+			remote: map[string]syncFile{"d.tmp": bravo, "build/y.txt": bravo, "c.txt": bravo},
+			opts:   syncer.Options{Delete: true, Exclude: []string{"*.tmp", "build"}},
+			want:   []string{"create local c.txt (new)"},
+		},
+	})
+}
@@ -0,0 +1,43 @@
+package tests
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/StarHack/go-icedrive/syncer"
+)
+
+func TestSyncStatePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	state, err := syncer.LoadState(path, 42, true)
+	if err != nil {
+		t.Fatalf("Failed to load empty state: %v", err)
+	}
+	state.Set("a/b.txt", &syncer.Entry{Size: 3, ModTime: 1700000000, UID: "file-1", RemoteModdate: 1700000000})
+	state.Set("a/c/d.txt", &syncer.Entry{Size: 4, UID: "file-2"})
+	state.Set("e.txt", &syncer.Entry{Size: 5, UID: "file-3"})
+	state.Move("e.txt", "f.txt")
+	state.DeleteTree("a/c")
+	if err := state.Save(); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+
+	loaded, err := syncer.LoadState(path, 42, true)
+	if err != nil {
+		t.Fatalf("Failed to reload state: %v", err)
+	}
+	if e := loaded.Get("a/b.txt"); e == nil || e.UID != "file-1" || e.ModTime != 1700000000 {
+		t.Errorf("Unexpected entry for a/b.txt: %+v", e)
+	}
+	if loaded.Get("a/c/d.txt") != nil {
+		t.Error("Deleted tree still present")
+	}
+	if loaded.Get("e.txt") != nil || loaded.Get("f.txt") == nil {
+		t.Error("Move was not persisted")
+	}
+
+	if _, err := syncer.LoadState(path, 7, true); err == nil {
+		t.Error("Expected state of another folder to be rejected")
+	}
+}
@@ -0,0 +1,51 @@
+package tests
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/StarHack/go-icedrive/api"
+)
+
+func TestUploadConflictPolicyNames(t *testing.T) {
+	for _, p := range []api.UploadConflictPolicy{api.UploadOverwrite, api.UploadRename, api.UploadSkipIdentical, api.UploadFail} {
+		parsed, err := api.ParseUploadConflictPolicy(p.String())
+		if err != nil || parsed != p {
+			t.Errorf("Round trip of %v gave %v, %v", p, parsed, err)
+		}
+	}
+	if _, err := api.ParseUploadConflictPolicy("replace"); err == nil {
+		t.Error("Expected an error for an unknown policy")
+	}
+}
+
+func TestFileExistsError(t *testing.T) {
+	err := fmt.Errorf("upload: %w", &api.FileExistsError{FolderID: 3, Name: "a.txt", Existing: api.Item{ID: 9}})
+	if !errors.Is(err, api.ErrFileExists) {
+		t.Error("Expected errors.Is to match ErrFileExists")
+	}
+	var exists *api.FileExistsError
+	if !errors.As(err, &exists) || exists.Existing.ID != 9 {
+		t.Errorf("Expected errors.As to expose the existing item, got %+v", exists)
+	}
+	if errors.Is(err, api.ErrUploadSkipped) {
+		t.Error("FileExistsError must not match ErrUploadSkipped")
+	}
+}
+
+func TestItemMatches(t *testing.T) {
+	mod := time.Unix(1700000000, 500)
+	plain := api.Item{Filesize: 100, Moddate: 1700000000}
+	if !api.ItemMatches(plain, 100, mod) {
+		t.Error("Expected identical plain item to match")
+	}
+	if api.ItemMatches(plain, 101, mod) || api.ItemMatches(plain, 100, mod.Add(time.Second)) {
+		t.Error("Expected size or time difference not to match")
+	}
+	encrypted := api.Item{Filesize: api.CryptoFileSize(100), Moddate: 1700000000, Crypto: 1}
+	if !api.ItemMatches(encrypted, 100, mod) {
+		t.Errorf("Expected encrypted item of size %d to match plain size 100", encrypted.Filesize)
+	}
+}
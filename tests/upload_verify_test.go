@@ -0,0 +1,52 @@
+package tests
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/StarHack/go-icedrive/client"
+)
+
+func TestIntegrityError(t *testing.T) {
+	err := fmt.Errorf("upload: %w", &client.IntegrityError{Name: "a.bin", Size: 10, StoredSize: 8})
+	if !errors.Is(err, client.ErrIntegrity) {
+		t.Error("Expected errors.Is to match ErrIntegrity")
+	}
+	var integrity *client.IntegrityError
+	if !errors.As(err, &integrity) || integrity.StoredSize != 8 {
+		t.Errorf("Expected errors.As to expose the stored size, got %+v", integrity)
+	}
+}
+
+func TestVerifiedUpload(t *testing.T) {
+	skipIfNoCredentials(t)
+
+	c := client.NewClient()
+	if err := c.LoginWithUsernameAndPassword(testEmail, testPassword); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	c.SetUploadVerification(client.VerifyContent)
+
+	testFilePath, _ := generateTestFile(t, 300*1024)
+	if err := c.UploadFile(0, testFilePath); err != nil {
+		t.Fatalf("Verified upload failed: %v", err)
+	}
+
+	time.Sleep(2 * time.Second)
+	items, err := c.ListFolder(0)
+	if err != nil {
+		t.Fatalf("Failed to list folder: %v", err)
+	}
+	item := findItemByName(items, filepath.Base(testFilePath))
+	if item == nil {
+		t.Fatal("Uploaded file not found")
+	}
+	defer c.Delete(*item)
+
+	if err := c.VerifyUpload(*item, 300*1024+1, ""); !errors.Is(err, client.ErrIntegrity) {
+		t.Errorf("Expected a size mismatch to be reported, got %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+package tests
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/StarHack/go-icedrive/api"
+	"github.com/StarHack/go-icedrive/client"
+)
+
+func TestFormatSize(t *testing.T) {
+	cases := map[uint64]string{
+		0:                    "0 B",
+		1023:                 "1023 B",
+		1024:                 "1.0 KiB",
+		1536 * 1024:          "1.5 MiB",
+		3 << 40:              "3.0 TiB",
+		5*1024*1024*1024 - 1: "5.0 GiB",
+	}
+	for n, want := range cases {
+		if got := client.FormatSize(n); got != want {
+			t.Errorf("FormatSize(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
+
+func TestUsageReportTree(t *testing.T) {
+	report := &client.UsageReport{
+		Cloud: &client.UsageNode{Name: "cloud", Path: "/", Size: 3072, Files: 3, Children: []*client.UsageNode{
+			{Name: "Photos", Path: "/Photos", Size: 2048, Files: 2, Children: []*client.UsageNode{
+				{Name: "2024", Path: "/Photos/2024", Size: 1024, Files: 1},
+			}},
+		}},
+		TrashSize:      512,
+		TrashItems:     1,
+		Reconciliation: client.Reconciliation{StorageUsed: 8192, Counted: 3584, Difference: 4608},
+	}
+
+	var out bytes.Buffer
+	if err := report.WriteTree(&out, 1); err != nil {
+		t.Fatalf("Failed to write tree: %v", err)
+	}
+	text := out.String()
+	for _, want := range []string{"cloud (3 files)", "  Photos (2 files)", "trash (1 items)", "4.5 KiB not in listings"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Tree output lacks %q:\n%s", want, text)
+		}
+	}
+	if strings.Contains(text, "2024") {
+		t.Errorf("Tree output goes deeper than requested:\n%s", text)
+	}
+}
+
+func TestDiskUsage(t *testing.T) {
+	f := newFakeAPI(t)
+	setupCryptoVault(t, f)
+	c := f.newClient()
+	if err := c.SetCryptoPassword("old"); err != nil {
+		t.Fatalf("SetCryptoPassword failed: %v", err)
+	}
+
+	docs := f.addFolder(0, "docs")
+	pdf := f.addFile(docs, "a.pdf", make([]byte, 100), 1700000000)
+	f.mutate(pdf.UID, func(it *api.Item, _ *[]byte) { it.FileType = "document" })
+	deep := f.addFolder(f.addFolder(0, "photos"), "deep")
+	jpg := f.addFile(deep, "b.jpg", make([]byte, 300), 1700000000)
+	f.mutate(jpg.UID, func(it *api.Item, _ *[]byte) { it.FileType = "image" })
+	f.addFile(0, "c.txt", make([]byte, 50), 1700000000)
+
+	// A trashed file and a trashed folder, whose contents count through its properties
+	old := f.addFile(0, "old.txt", make([]byte, 40), 1700000000)
+	junk := f.addFolder(0, "junk")
+	f.addFile(junk, "x.bin", make([]byte, 60), 1700000000)
+	junkFolder, _, _ := f.item(f.folderUID(junk))
+	for _, item := range []api.Item{old, junkFolder} {
+		if err := c.TrashItem(item); err != nil {
+			t.Fatalf("TrashItem failed: %v", err)
+		}
+	}
+	f.storageExtra = 1000
+
+	// Encrypted files are listed with their stored, encrypted size
+	var cryptoSize uint64
+	f.mu.Lock()
+	for _, it := range f.items {
+		if it.Crypto == 1 {
+			cryptoSize += it.Filesize
+		}
+	}
+	f.mu.Unlock()
+
+	report, err := c.DiskUsage(client.UsageOptions{Cloud: true, Crypto: true, Trash: true, Top: 4})
+	if err != nil {
+		t.Fatalf("DiskUsage failed: %v", err)
+	}
+	if r := report.Cloud; r.Size != 450 || r.Files != 3 || r.Folders != 3 {
+		t.Errorf("Cloud usage is %d bytes, %d files, %d folders, want 450, 3, 3", r.Size, r.Files, r.Folders)
+	}
+	if r := report.Crypto; r.Size != cryptoSize || r.Files != 3 || r.Folders != 1 {
+		t.Errorf("Crypto usage is %d bytes, %d files, %d folders, want %d, 3, 1", r.Size, r.Files, r.Folders, cryptoSize)
+	}
+	if report.TrashSize != 100 || report.TrashItems != 2 {
+		t.Errorf("Trash usage is %d bytes in %d items, want 100 in 2", report.TrashSize, report.TrashItems)
+	}
+	if call, _ := f.lastCall("/collection"); call.Form.Get("type") != "trash" {
+		t.Errorf("Expected the trash to be listed, last listing was of type %q", call.Form.Get("type"))
+	}
+
+	if want := []client.UsageEntry{
+		{Collection: api.CollectionCloud, Path: "/photos/deep/b.jpg", Size: 300},
+		{Collection: api.CollectionCloud, Path: "/docs/a.pdf", Size: 100},
+		{Collection: api.CollectionCloud, Path: "/c.txt", Size: 50},
+	}; !reflect.DeepEqual(report.LargestFiles[:3], want) {
+		t.Errorf("Largest files %+v, want %+v first", report.LargestFiles, want)
+	}
+	if len(report.LargestFiles) != 4 || report.LargestFiles[3].Collection != api.CollectionCrypto {
+		t.Errorf("Expected an encrypted file fourth, got %+v", report.LargestFiles)
+	}
+	var folders []string
+	for _, e := range report.LargestFolders {
+		folders = append(folders, string(e.Collection)+":"+e.Path)
+	}
+	if want := []string{"cloud:/photos", "cloud:/photos/deep", "cloud:/docs", "crypto:/docs"}; !reflect.DeepEqual(folders, want) {
+		t.Errorf("Largest folders %v, want %v", folders, want)
+	}
+	if got := report.ByFileType[0]; got != (client.TypeUsage{Key: "image", Files: 1, Size: 300}) {
+		t.Errorf("Largest file type is %+v", got)
+	}
+	if got := report.ByExtension[1]; got != (client.TypeUsage{Key: "txt", Files: 4, Size: 50 + cryptoSize}) {
+		t.Errorf("Second largest extension is %+v", got)
+	}
+
+	counted := 450 + cryptoSize + 100
+	if want := (client.Reconciliation{StorageUsed: counted + 1000, Counted: counted, Difference: 1000}); report.Reconciliation != want {
+		t.Errorf("Reconciliation is %+v, want %+v", report.Reconciliation, want)
+	}
+}